@@ -0,0 +1,464 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/api"
+	"github.com/qalisa/pierceflare/cli/internal/clock"
+	"github.com/qalisa/pierceflare/cli/internal/cloudflare"
+	"github.com/qalisa/pierceflare/cli/internal/dnscheck"
+	"github.com/qalisa/pierceflare/cli/internal/health"
+	"github.com/qalisa/pierceflare/cli/internal/ip"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/notify"
+	"github.com/qalisa/pierceflare/cli/internal/queue"
+	"github.com/qalisa/pierceflare/cli/internal/schedule"
+	"github.com/qalisa/pierceflare/cli/internal/systemd"
+	"github.com/qalisa/pierceflare/cli/internal/transport"
+	"github.com/qalisa/pierceflare/cli/signing"
+)
+
+// Family restricts IP detection to an address family
+type Family = ip.Family
+
+// Address families
+const (
+	FamilyAny  = ip.FamilyAny
+	FamilyIPv4 = ip.FamilyIPv4
+	FamilyIPv6 = ip.FamilyIPv6
+)
+
+// Detection describes a successful IP detection (address, family, source, duration)
+type Detection = ip.Result
+
+// Class is the kind of range an IP address belongs to (public, private, shared, ...)
+type Class = ip.Class
+
+// Address classes
+const (
+	ClassPublic        = ip.ClassPublic
+	ClassPrivate       = ip.ClassPrivate
+	ClassShared        = ip.ClassShared
+	ClassUniqueLocal   = ip.ClassUniqueLocal
+	ClassDocumentation = ip.ClassDocumentation
+	ClassLoopback      = ip.ClassLoopback
+	ClassLinkLocal     = ip.ClassLinkLocal
+	ClassReserved      = ip.ClassReserved
+)
+
+// Topology describes how the host reaches the Internet, deduced from its local addresses
+type Topology = ip.Topology
+
+// Network topologies
+const (
+	TopologyUnknown = ip.TopologyUnknown
+	TopologyDirect  = ip.TopologyDirect
+	TopologyNAT     = ip.TopologyNAT
+	TopologyCGNAT   = ip.TopologyCGNAT
+)
+
+// LocalAddress is an address assigned to the host, with its class
+type LocalAddress = ip.LocalAddress
+
+// FlareResult is the acknowledgement of a flare by the server
+type FlareResult = api.FlareResult
+
+// IPChange describes a new IP address acknowledged by the server
+type IPChange struct {
+	Previous string // Previously flared address, empty on the first flare
+	Current  string
+	Flare    *FlareResult
+}
+
+// Agent keeps the DNS record bound to an API token up to date with the public IP address of the host
+type Agent struct {
+	settings
+
+	log           *logger.Logger
+	apiClient     *api.Client
+	ipRetriever   *ip.Retriever
+	health        *health.Status
+	cloudflare    *cloudflare.Client // Updates the record directly when the server is unavailable, if enabled
+	backendStates []*backendState    // Additional backends, see WithBackends
+	verifier      *dnscheck.Verifier // Verifies the propagation of flared addresses, if enabled
+	matcher       *dnscheck.Matcher  // Tells whether the record already serves an address, if enabled
+	notifier      *notify.Notifier
+	scheduler     *schedule.Scheduler // Times of the checks in Run
+
+	paused   atomic.Bool        // Checks are suspended, see Pause
+	triggers chan chan struct{} // Checks requested out of schedule, see Trigger
+
+	mu     sync.Mutex // Serializes checks and flares
+	compat *Compatibility
+	domain string // Domain bound to the API token, as of the last token check
+	state
+	stats stats
+}
+
+// NewAgent creates an Agent. WithAPIKey and WithServerURL are required to talk to the server,
+// an Agent without them can only Detect.
+func NewAgent(opts ...Option) (*Agent, error) {
+	a := &Agent{
+		settings: settings{
+			checkInterval:      DefaultCheckInterval,
+			revalidateInterval: DefaultRevalidateInterval,
+			logLevel:           LogLevelInfo,
+			logTimestamp:       true,
+			successLogPeriod:   DefaultSuccessLogPeriod,
+			clock:              clock.Real,
+		},
+	}
+	for _, opt := range opts {
+		opt(&a.settings)
+	}
+
+	// Logger
+	var journal *systemd.Journal
+	var journalErr error
+	if a.logger == nil && a.journalIdentifier != "" {
+		journal, journalErr = systemd.NewJournal(a.journalIdentifier)
+	}
+	switch {
+	case a.logger != nil:
+		a.log = logger.NewWithSink(logger.LogLevelDebug, a.successLogPeriod, loggerSink{a.logger})
+	case journal != nil:
+		a.log = logger.NewWithSink(a.logLevel, a.successLogPeriod, journal)
+	default:
+		a.log = logger.New(a.logTimestamp, a.logLevel, a.successLogPeriod)
+		if a.logOutput != nil {
+			a.log.SetOutput(a.logOutput)
+		}
+	}
+	a.log.SetClock(a.clock)
+	a.log.SetPrefix(a.name)
+	a.log.SetField("uplink", a.name)
+	if journalErr != nil {
+		a.log.Info("journald unavailable, logging to the standard output: %v", journalErr)
+	}
+
+	// Server and IP sources
+	apiTransport := a.apiTransport
+	if apiTransport.Timeout == 0 {
+		apiTransport.Timeout = api.DefaultTimeout
+	}
+	httpClient, err := transport.NewClient(apiTransport)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API transport: %w", err)
+	}
+	a.apiClient = api.NewClient(a.apiKey, a.serverURL, httpClient, a.log)
+	if a.signRequests {
+		signer := signing.NewSigner(a.apiKey)
+		signer.SetClock(a.clock.Now)
+		a.apiClient.EnableSigning(signer)
+	}
+
+	retrieverOptions := []ip.Option{ip.WithClock(a.clock), ip.WithHedging(a.hedgeDelay)}
+	if a.sources != nil {
+		retrieverOptions = append(retrieverOptions, ip.WithSources(a.sources...))
+	}
+	a.ipRetriever, err = ip.NewRetriever(a.log, a.detectTransport, retrieverOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid detection transport: %w", err)
+	}
+
+	if a.cloudflareOptions != nil && a.cloudflareOptions.Token != "" {
+		cloudflareTransport := a.detectTransport
+		cloudflareTransport.Timeout = apiTransport.Timeout
+		cloudflareClient, err := transport.NewClient(cloudflareTransport)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Cloudflare transport: %w", err)
+		}
+		a.cloudflare = cloudflare.NewClient(*a.cloudflareOptions, cloudflareClient)
+		if a.fallbackAfter <= 0 {
+			a.fallbackAfter = DefaultFallbackAfter
+		}
+	}
+
+	if a.propagationOptions != nil || a.precheckResolver != nil {
+		dohClient, err := transport.NewClient(a.detectTransport)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DoH transport: %w", err)
+		}
+		if a.propagationOptions != nil {
+			if a.verifier, err = dnscheck.New(*a.propagationOptions, dohClient); err != nil {
+				return nil, fmt.Errorf("invalid propagation check: %w", err)
+			}
+		}
+		if a.precheckResolver != nil {
+			if a.matcher, err = dnscheck.NewMatcher(*a.precheckResolver, dohClient, a.detectTransport.Timeout); err != nil {
+				return nil, fmt.Errorf("invalid DNS precheck: %w", err)
+			}
+		}
+	}
+
+	scheduleOptions := a.schedule
+	if scheduleOptions.Interval == 0 {
+		scheduleOptions.Interval = a.checkInterval
+	}
+	if a.scheduler, err = schedule.New(scheduleOptions); err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+
+	if a.queue == nil {
+		a.queue, _ = queue.Open("")
+	}
+
+	for _, b := range a.backends {
+		a.backendStates = append(a.backendStates, &backendState{backend: b})
+	}
+
+	a.triggers = make(chan chan struct{}, 1)
+	a.health = health.New(a.healthFile)
+	a.notifier = notify.New(a.notifyURL, a.log)
+
+	return a, nil
+}
+
+// Name returns the name of the agent, empty if none was given
+func (a *Agent) Name() string {
+	return a.name
+}
+
+// requireServer checks that the agent can talk to the server
+func (a *Agent) requireServer() error {
+	if a.apiKey == "" || a.serverURL == "" {
+		return ErrNotConfigured
+	}
+	return nil
+}
+
+// Domain asks the server the domain the API token is bound to, checking it against WithExpectedDomain.
+// It is empty if the server cannot tell.
+func (a *Agent) Domain(ctx context.Context) (string, error) {
+	if err := a.requireServer(); err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.negotiate(ctx)
+	return a.checkToken(ctx)
+}
+
+// BoundDomain returns the domain the API token was bound to at the last token check, without asking
+// the server; empty before the first check
+func (a *Agent) BoundDomain() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.domain
+}
+
+// Detect detects the public IP address of the given family, without flaring it
+func (a *Agent) Detect(ctx context.Context, family Family) (*Detection, error) {
+	return a.ipRetriever.Lookup(ctx, family)
+}
+
+// Healthy reports whether the last check succeeded, and the reason of the failure otherwise
+func (a *Agent) Healthy() (bool, string) {
+	healthy, reason, _ := a.health.Get()
+	return healthy, reason
+}
+
+// Flare describes what FlareOnce did
+type Flare struct {
+	Compatibility  *Compatibility     // Outcome of the negotiation with the server
+	Domain         string             // Domain bound to the API token, empty if the server cannot tell
+	Detection      *Detection         // Detected address, nil if detection failed
+	DetectDuration time.Duration      // Time spent detecting the address
+	Result         *FlareResult       // Acknowledgement of the server, nil if the flare failed
+	FlareDuration  time.Duration      // Time spent flaring the address
+	Backends       []BackendResult    // Updates of the backends given to WithBackends
+	Propagation    *PropagationResult // Propagation of the address, with WithPropagationCheck
+	PropagationErr error              // Why the address did not propagate, see ErrNotPropagated
+}
+
+// FlareOnce checks the token, detects the current IP address and flares it unconditionally.
+// On failure, the returned Flare holds what was done before the failure.
+func (a *Agent) FlareOnce(ctx context.Context) (*Flare, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	flare := &Flare{}
+	if err := a.requireServer(); err != nil {
+		return flare, err
+	}
+
+	a.negotiate(ctx)
+	flare.Compatibility = a.compat
+
+	// Check token validity
+	domain, err := a.checkToken(ctx)
+	flare.Domain = domain
+	if err != nil {
+		a.log.Error("Token validation error: %v", err)
+		return flare, err
+	}
+
+	a.log.Debug("API token valid")
+
+	start := a.clock.Now()
+	detection, err := a.ipRetriever.Lookup(ctx, FamilyAny)
+	flare.DetectDuration = a.clock.Now().Sub(start)
+	if err != nil {
+		a.log.Error("Error retrieving IP address: %v", err)
+		return flare, err
+	}
+
+	flare.Detection = detection
+	a.log.Debug("Current IP address: %s", detection.Address)
+	if err := a.acceptDetection(detection); err != nil {
+		return flare, err
+	}
+
+	// Backends do not depend on the server
+	flare.Backends = a.updateBackends(ctx, detection.Address, true)
+
+	// Never send a dummy request (always a real update)
+	start = a.clock.Now()
+	result, err := a.sendFlare(ctx, detection.Address)
+	flare.FlareDuration = a.clock.Now().Sub(start)
+	if err != nil {
+		a.log.Error("Error sending IP update: %v", err)
+		if api.IsUnavailable(err) {
+			a.enqueue(detection.Address, err)
+		}
+		return flare, err
+	}
+	a.dequeue()
+
+	flare.Result = result
+	a.log.Info("IP update successful")
+
+	if a.verifier != nil {
+		flare.Propagation, flare.PropagationErr = a.checkPropagation(ctx, a.publishedAddress(detection.Address, result))
+	}
+	return flare, nil
+}
+
+// Run negotiates the API version with the server and checks the token, then checks the IP address periodically and flares it when it changes, until ctx is canceled.
+// It only returns an error if the server refuses the token at startup, or if the token is bound to another
+// domain than expected. When the server cannot be reached, the token is checked again before each check
+// and the flares are queued or go through the Cloudflare fallback meanwhile. Later failures are handled
+// (backoff, revalidation of refused tokens) and reported through OnFailure, notifications and health.
+func (a *Agent) Run(ctx context.Context) error {
+	if err := a.requireServer(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.negotiate(ctx)
+	_, err := a.checkToken(ctx)
+	switch {
+	case err == nil:
+		a.log.Debug("API token valid")
+	case ctx.Err() != nil:
+		a.mu.Unlock()
+		return nil
+	case api.IsAuthError(err) || errors.Is(err, ErrDomainMismatch):
+		a.mu.Unlock()
+		a.log.Error("Token validation error: %v", err)
+		return err
+	default:
+		a.tokenUnchecked = true
+		a.log.Error("Unable to check the API token, the server seems unavailable: %v", err)
+		a.log.Info("The token will be checked again before each check, flares are queued meanwhile")
+		a.setUnhealthy("unable to check the API token: %v", err)
+	}
+	a.showQueued()
+	a.mu.Unlock()
+
+	a.log.Debug("Checks scheduled %s", a.scheduler)
+
+	// The ticker is reset after each check to fire at the next scheduled one
+	next := a.scheduler.First(a.clock.Now())
+	ticker := a.clock.NewTicker(a.untilCheck(next))
+	defer ticker.Stop()
+	reschedule := func(at time.Time) {
+		next = at
+		ticker.Reset(a.untilCheck(next))
+		a.updateStats(func(s *Stats) { s.NextCheck = next })
+	}
+
+	// Flares that did not reach the server are retried between checks
+	retry := a.clock.NewTicker(queueRetryInterval)
+	defer retry.Stop()
+
+	// The heartbeat shows the loop is not stuck, between checks too
+	var heartbeat <-chan time.Time
+	if a.heartbeatInterval > 0 {
+		heartbeatTicker := a.clock.NewTicker(a.heartbeatInterval)
+		defer heartbeatTicker.Stop()
+		heartbeat = heartbeatTicker.C()
+	}
+	beat := func() {
+		now := a.clock.Now()
+		a.updateStats(func(s *Stats) { s.Heartbeat = now })
+	}
+
+	a.updateStats(func(s *Stats) { s.Running = true })
+	defer a.updateStats(func(s *Stats) { s.Running = false; s.Heartbeat = time.Time{}; s.NextCheck = time.Time{} })
+	defer func() {
+		a.mu.Lock()
+		a.stopPropagation()
+		a.mu.Unlock()
+	}()
+	beat()
+	for _, fn := range a.onStart {
+		fn()
+	}
+
+	// Initial check, unless delayed
+	if next.After(a.clock.Now()) {
+		a.log.Info("First check at %s", next.Format(time.DateTime))
+		reschedule(next)
+	} else {
+		a.Check(ctx)
+		reschedule(a.scheduler.Next(a.clock.Now()))
+		beat()
+	}
+
+	// Main loop
+	for {
+		select {
+		case <-ticker.C():
+			if next.Sub(a.clock.Now()) > time.Second {
+				// Tick of a previous schedule
+				continue
+			}
+			// Scheduled check
+			a.Check(ctx)
+			reschedule(a.scheduler.Next(a.clock.Now()))
+			beat()
+		case done := <-a.triggers:
+			// Check out of schedule, the next scheduled one following it
+			a.Check(ctx)
+			reschedule(a.scheduler.Next(a.clock.Now()))
+			beat()
+			if done != nil {
+				close(done)
+			}
+		case <-retry.C():
+			if a.scheduler.Quiet(a.clock.Now()) {
+				continue
+			}
+			a.RetryQueued(ctx)
+			beat()
+		case <-heartbeat:
+			beat()
+		case <-ctx.Done():
+			// Graceful termination
+			return nil
+		}
+	}
+}
+
+// untilCheck returns the delay until a check at t, at least a millisecond for the ticker
+func (a *Agent) untilCheck(t time.Time) time.Duration {
+	return max(t.Sub(a.clock.Now()), time.Millisecond)
+}
@@ -0,0 +1,406 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/clock"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+const (
+	testToken   = "test-token"
+	testDomain  = "home.example.com"
+	testAddress = "93.184.216.34"
+)
+
+// testAgent is an agent talking to a fake server, on a fake clock
+type testAgent struct {
+	*client.Agent
+	srv     *testserver.Server
+	echo    *testserver.Echo
+	clock   *clock.Fake
+	changes []client.IPChange
+	errs    []error
+
+	mu sync.Mutex // Guards errs while Run is running
+}
+
+// newTestAgent starts a fake server and an echo service answering responses (testAddress by default)
+func newTestAgent(t *testing.T, responses []testserver.EchoResponse, opts ...client.Option) *testAgent {
+	t.Helper()
+
+	if len(responses) == 0 {
+		responses = []testserver.EchoResponse{testserver.EchoIP(testAddress)}
+	}
+	ta := &testAgent{
+		srv:   testserver.New(map[string]string{testToken: testDomain}),
+		echo:  testserver.NewEcho(responses...),
+		clock: clock.NewFake(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)),
+	}
+	t.Cleanup(ta.srv.Close)
+	t.Cleanup(ta.echo.Close)
+
+	opts = append([]client.Option{
+		client.WithAPIKey(testToken),
+		client.WithServerURL(ta.srv.URL),
+		client.WithSources(ta.echo.Source()),
+		client.WithLogOutput(io.Discard),
+		client.WithClock(ta.clock),
+		client.OnIPChange(func(change client.IPChange) { ta.changes = append(ta.changes, change) }),
+		client.OnFailure(func(err error) {
+			ta.mu.Lock()
+			defer ta.mu.Unlock()
+			ta.errs = append(ta.errs, err)
+		}),
+	}, opts...)
+
+	var err error
+	if ta.Agent, err = client.NewAgent(opts...); err != nil {
+		t.Fatalf("NewAgent: %v", err)
+	}
+	return ta
+}
+
+// check runs a check, then returns the flares the server received during it
+func (ta *testAgent) check() []testserver.Request {
+	before := len(ta.srv.Flares())
+	ta.Check(context.Background())
+	return ta.srv.Flares()[before:]
+}
+
+// failures returns the failures reported so far
+func (ta *testAgent) failures() []error {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	return append([]error(nil), ta.errs...)
+}
+
+// run starts Run in the background, returning once its initial check is done. stop cancels Run and
+// returns its result; it is called when the test ends, if not before.
+func (ta *testAgent) run(t *testing.T) (stop func() error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- ta.Run(ctx)
+	}()
+	var once sync.Once
+	var err error
+	stop = func() error {
+		once.Do(func() {
+			cancel()
+			err = <-done
+		})
+		return err
+	}
+	t.Cleanup(func() { stop() })
+
+	// The next check is scheduled once the initial one is done
+	for deadline := time.Now().Add(5 * time.Second); ta.Stats().NextCheck.IsZero(); time.Sleep(time.Millisecond) {
+		select {
+		case err := <-done:
+			done <- err // For stop
+			t.Fatalf("Run returned at startup: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Run not started")
+		}
+	}
+	return stop
+}
+
+// waitFor waits until cond holds, for the work Run does in the background on ticks of the fake clock
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// trigger runs a check through Run, then returns the flares the server received during it
+func (ta *testAgent) trigger(t *testing.T) []testserver.Request {
+	t.Helper()
+
+	before := len(ta.srv.Flares())
+	if err := ta.Trigger(context.Background()); err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	return ta.srv.Flares()[before:]
+}
+
+func TestRevokedToken(t *testing.T) {
+	ta := newTestAgent(t, nil, client.WithRevalidateInterval(10*time.Minute))
+	ta.srv.RevokeToken(testToken)
+
+	if flares := ta.check(); len(flares) != 1 || flares[0].Status != 403 {
+		t.Fatalf("flares = %+v, want one refused with HTTP 403", flares)
+	}
+	if len(ta.errs) != 1 || !errors.Is(ta.errs[0], client.ErrAuthRevoked) {
+		t.Fatalf("failures = %v, want ErrAuthRevoked", ta.errs)
+	}
+	if healthy, _ := ta.Healthy(); healthy {
+		t.Error("agent healthy after the token was refused")
+	}
+
+	// Suspended until the revalidation
+	ta.clock.Advance(5 * time.Minute)
+	requests := len(ta.srv.Requests())
+	ta.Check(context.Background())
+	if got := len(ta.srv.Requests()); got != requests {
+		t.Fatalf("%d requests sent while suspended, want none", got-requests)
+	}
+
+	// Still refused at the revalidation
+	ta.clock.Advance(5 * time.Minute)
+	if flares := ta.check(); len(flares) != 0 {
+		t.Fatalf("flared %d times while the token is refused", len(flares))
+	}
+	if got := ta.srv.Requests()[len(ta.srv.Requests())-1]; got.Path != testserver.RouteInfos || got.Status != 403 {
+		t.Fatalf("revalidation = %s answered %d, want %s refused with HTTP 403", got.Path, got.Status, testserver.RouteInfos)
+	}
+
+	// Restored: flared again at the next revalidation
+	ta.srv.AddToken(testToken, testDomain)
+	ta.clock.Advance(10 * time.Minute)
+	if flares := ta.check(); len(flares) != 1 || flares[0].Status != 200 {
+		t.Fatalf("flares = %+v, want one acknowledged once the token is restored", flares)
+	}
+	if healthy, reason := ta.Healthy(); !healthy {
+		t.Errorf("agent unhealthy after the token was restored: %s", reason)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	ta := newTestAgent(t, nil)
+	ta.srv.SetRateLimit(10, time.Minute)
+	ta.srv.Script(testserver.Behavior{Route: testserver.RouteFlare, RateLimited: true, RateLimitReset: 30 * time.Second})
+
+	if flares := ta.check(); len(flares) != 1 || flares[0].Status != 429 {
+		t.Fatalf("flares = %+v, want one refused with HTTP 429", flares)
+	}
+	var apiErr *client.APIError
+	if len(ta.errs) != 1 || !errors.As(ta.errs[0], &apiErr) || !errors.Is(apiErr, client.ErrRateLimited) || apiErr.RetryAfter != 30*time.Second {
+		t.Fatalf("failures = %v, want ErrRateLimited advising 30s", ta.errs)
+	}
+
+	stats := ta.Stats()
+	if want := ta.clock.Now().Add(30 * time.Second); !stats.BackoffUntil.Equal(want) {
+		t.Errorf("BackoffUntil = %s, want %s", stats.BackoffUntil, want)
+	}
+	if stats.RateLimit == nil || stats.RateLimit.Limit != 10 || stats.RateLimit.Remaining != 0 {
+		t.Errorf("RateLimit = %+v, want a limit of 10 with no request remaining", stats.RateLimit)
+	}
+
+	// Nothing is sent while backing off
+	ta.clock.Advance(29 * time.Second)
+	requests := len(ta.srv.Requests())
+	ta.Check(context.Background())
+	ta.RetryQueued(context.Background())
+	if got := len(ta.srv.Requests()); got != requests {
+		t.Fatalf("%d requests sent while backing off, want none", got-requests)
+	}
+
+	ta.clock.Advance(time.Second)
+	if flares := ta.check(); len(flares) != 1 || flares[0].Status != 200 {
+		t.Fatalf("flares = %+v, want one acknowledged after the backoff", flares)
+	}
+	if len(ta.changes) != 1 || ta.changes[0].Current != testAddress {
+		t.Errorf("IP changes = %+v, want %s", ta.changes, testAddress)
+	}
+}
+
+func TestUnresolvable(t *testing.T) {
+	ta := newTestAgent(t, nil)
+	ta.srv.Script(testserver.Behavior{Route: testserver.RouteFlare, Unresolvable: true})
+
+	if flares := ta.check(); len(flares) != 1 || flares[0].Status != 500 {
+		t.Fatalf("flares = %+v, want one failed with HTTP 500", flares)
+	}
+	if len(ta.errs) != 1 || !errors.Is(ta.errs[0], client.ErrUnresolvable) {
+		t.Fatalf("failures = %v, want ErrUnresolvable", ta.errs)
+	}
+	stats := ta.Stats()
+	if stats.Queued != "" {
+		t.Errorf("flare of %s queued, an unresolvable emitter is not a server outage", stats.Queued)
+	}
+	if stats.LastFlareError == "" || stats.LastFlareResult != nil {
+		t.Errorf("last flare error = %q, result = %+v, want a failure", stats.LastFlareError, stats.LastFlareResult)
+	}
+
+	// The address was not acknowledged, the next check flares it again
+	ta.clock.Advance(5 * time.Minute)
+	if flares := ta.check(); len(flares) != 1 || flares[0].Status != 200 {
+		t.Fatalf("flares = %+v, want one acknowledged", flares)
+	}
+	if healthy, reason := ta.Healthy(); !healthy {
+		t.Errorf("agent unhealthy after the flare was acknowledged: %s", reason)
+	}
+}
+
+func TestResolvedIPOverride(t *testing.T) {
+	const resolved = "203.0.113.50"
+
+	ta := newTestAgent(t, nil)
+	ta.srv.Script(testserver.Behavior{Route: testserver.RouteFlare, ResolvedIP: resolved})
+
+	if flares := ta.check(); len(flares) != 1 || *flares[0].Flare.IP != testAddress {
+		t.Fatalf("flares = %+v, want one of %s", flares, testAddress)
+	}
+	if len(ta.changes) != 1 {
+		t.Fatalf("IP changes = %+v, want one", ta.changes)
+	}
+	change := ta.changes[0]
+	if change.Current != testAddress || change.Flare == nil || change.Flare.ResolvedIP != resolved {
+		t.Errorf("IP change = %+v (flare %+v), want %s resolved as %s", change, change.Flare, testAddress, resolved)
+	}
+	if result := ta.Stats().LastFlareResult; result == nil || result.ResolvedIP != resolved {
+		t.Errorf("last flare result = %+v, want resolvedIp %s", result, resolved)
+	}
+
+	flare, err := ta.FlareOnce(context.Background())
+	if err != nil {
+		t.Fatalf("FlareOnce: %v", err)
+	}
+	if flare.Result.ResolvedIP != testAddress {
+		t.Errorf("FlareOnce resolvedIp = %s, want %s once the override is consumed", flare.Result.ResolvedIP, testAddress)
+	}
+}
+
+func TestChangeDetection(t *testing.T) {
+	const changed = "93.184.216.35"
+
+	var logs bytes.Buffer
+	ta := newTestAgent(t, []testserver.EchoResponse{
+		testserver.EchoIP(testAddress),
+		testserver.EchoLine(testAddress),
+		testserver.EchoIP(testAddress),
+		testserver.EchoIP(changed),
+		testserver.EchoIP(changed),
+	}, client.WithLogOutput(&logs), client.WithLogTimestamp(false), client.WithSuccessLogPeriod(2))
+
+	steps := []struct {
+		flared  bool   // Whether the check flares
+		current string // Address acknowledged after the check
+	}{
+		{flared: true, current: testAddress},
+		{flared: false, current: testAddress},
+		{flared: false, current: testAddress},
+		{flared: true, current: changed},
+		{flared: false, current: changed},
+		{flared: false, current: changed},
+		{flared: false, current: changed},
+	}
+	for i, step := range steps {
+		flares := ta.check()
+		if got := len(flares) == 1; got != step.flared || len(flares) > 1 {
+			t.Fatalf("check %d sent %d flares, want flared = %v", i, len(flares), step.flared)
+		}
+		if got := ta.Stats().CurrentIP; got != step.current {
+			t.Fatalf("current IP after check %d = %s, want %s", i, got, step.current)
+		}
+		ta.clock.Advance(5 * time.Minute)
+	}
+
+	want := []client.IPChange{{Current: testAddress}, {Previous: testAddress, Current: changed}}
+	if len(ta.changes) != len(want) {
+		t.Fatalf("IP changes = %+v, want %+v", ta.changes, want)
+	}
+	for i, change := range ta.changes {
+		if change.Previous != want[i].Previous || change.Current != want[i].Current || change.Flare == nil {
+			t.Errorf("IP change %d = %+v, want %+v with the acknowledgement", i, change, want[i])
+		}
+	}
+
+	stats := ta.Stats()
+	if stats.Checks != uint64(len(steps)) || stats.Flares != 2 || stats.Failures != 0 {
+		t.Errorf("stats = %d checks, %d flares, %d failures, want %d, 2, 0", stats.Checks, stats.Flares, stats.Failures, len(steps))
+	}
+
+	// Unchanged addresses are logged every other check: the success counter is not reset by flares
+	if got := strings.Count(logs.String(), "IP unchanged"); got != 2 {
+		t.Errorf("%d success messages logged for 5 unchanged checks, want 2:\n%s", got, logs.String())
+	}
+}
+
+func TestRun(t *testing.T) {
+	const (
+		second = "93.184.216.35"
+		third  = "93.184.216.36"
+	)
+
+	ta := newTestAgent(t, []testserver.EchoResponse{
+		testserver.EchoIP(testAddress),
+		testserver.EchoIP(second),
+		testserver.EchoIP(third),
+	}, client.WithRevalidateInterval(30*time.Minute))
+	stop := ta.run(t)
+
+	// Initial check
+	if flares := ta.srv.Flares(); len(flares) != 1 || *flares[0].Flare.IP != testAddress {
+		t.Fatalf("flares = %+v, want %s flared at startup", flares, testAddress)
+	}
+	if next := ta.Stats().NextCheck; !next.Equal(ta.clock.Now().Add(client.DefaultCheckInterval)) {
+		t.Fatalf("next check at %s, want after %s", next, client.DefaultCheckInterval)
+	}
+
+	// Scheduled check, the server failing
+	ta.srv.Script(testserver.Behavior{Route: testserver.RouteFlare, Status: http.StatusServiceUnavailable})
+	ta.clock.Advance(client.DefaultCheckInterval)
+	waitFor(t, "the flare to be queued", func() bool { return ta.Stats().Queued == second })
+	if flares := ta.srv.Flares(); len(flares) != 2 || flares[1].Status != http.StatusServiceUnavailable {
+		t.Fatalf("flares = %+v, want %s failed with HTTP 503", flares, second)
+	}
+
+	// Retried before the next check
+	ta.clock.Advance(15 * time.Second)
+	waitFor(t, "the retry", func() bool { return ta.Stats().CurrentIP == second })
+	if flares := ta.srv.Flares(); len(flares) != 3 || flares[2].Status != http.StatusOK || *flares[2].Flare.IP != second {
+		t.Fatalf("flares = %+v, want %s acknowledged when retried", flares, second)
+	}
+	if stats := ta.Stats(); stats.Queued != "" {
+		t.Errorf("flare of %s still queued once delivered", stats.Queued)
+	}
+
+	// Refused token: suspended until the revalidation
+	ta.srv.Script(testserver.Behavior{Route: testserver.RouteFlare, Status: http.StatusUnauthorized})
+	next := ta.Stats().NextCheck
+	ta.clock.Advance(next.Sub(ta.clock.Now()))
+	waitFor(t, "the refused flare", func() bool { return len(ta.failures()) == 2 })
+	if err := ta.failures()[1]; !errors.Is(err, client.ErrUnauthenticated) {
+		t.Fatalf("failure = %v, want ErrUnauthenticated", err)
+	}
+	if healthy, _ := ta.Healthy(); healthy {
+		t.Error("agent healthy after the token was refused")
+	}
+	requests := len(ta.srv.Requests())
+	for range 3 {
+		next := ta.Stats().NextCheck
+		ta.clock.Advance(next.Sub(ta.clock.Now()))
+		waitFor(t, "the next check", func() bool { return ta.Stats().NextCheck.After(next) })
+	}
+	if got := len(ta.srv.Requests()); got != requests {
+		t.Fatalf("%d requests sent while suspended, want none", got-requests)
+	}
+
+	// Clean exit
+	if err := stop(); err != nil {
+		t.Errorf("Run = %v, want nil once canceled", err)
+	}
+	if stats := ta.Stats(); stats.Running || !stats.NextCheck.IsZero() {
+		t.Errorf("running = %v, next check at %s after Run returned", stats.Running, stats.NextCheck)
+	}
+}
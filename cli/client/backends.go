@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/qalisa/pierceflare/cli/internal/backend"
+	"github.com/qalisa/pierceflare/cli/internal/backend/dyndns2"
+	"github.com/qalisa/pierceflare/cli/internal/backend/rfc2136"
+	"github.com/qalisa/pierceflare/cli/internal/transport"
+)
+
+// Backend points records to the addresses detected by the agent. Backends given to WithBackends are
+// updated besides the PierceFlare server, from the same detection loop.
+type Backend = backend.Backend
+
+// RFC2136Options configures dynamic DNS updates (RFC 2136) signed with TSIG, e.g. for BIND
+type RFC2136Options = rfc2136.Options
+
+// DynDNS2Options configures updates through the dyndns2 protocol (GET /nic/update)
+type DynDNS2Options = dyndns2.Options
+
+// NewRFC2136Backend creates a Backend replacing a record of an authoritative server with dynamic updates
+func NewRFC2136Backend(opts RFC2136Options) (Backend, error) {
+	b, err := rfc2136.New(opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RFC 2136 backend: %w", err)
+	}
+	return b, nil
+}
+
+// NewDynDNS2Backend creates a Backend updating a host of a dyndns2 provider, through an HTTP client
+// configured by transportOptions
+func NewDynDNS2Backend(opts DynDNS2Options, transportOptions TransportOptions) (Backend, error) {
+	httpClient, err := transport.NewClient(transportOptions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dyndns2 transport: %w", err)
+	}
+	b, err := dyndns2.New(opts, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dyndns2 backend: %w", err)
+	}
+	return b, nil
+}
+
+// BackendResult is the outcome of the update of a backend
+type BackendResult struct {
+	Name string
+	Err  error
+}
+
+// backendState tracks what was sent to a backend, so that it is only updated on change and
+// retried at the next check after a failure
+type backendState struct {
+	backend Backend
+	lastIP  string
+}
+
+// updateBackends points the backends to address: those already pointing there are skipped, unless forced.
+// Failures are logged and reported to OnFailure, but do not affect the health of the agent, which
+// follows the PierceFlare server. Canceling ctx stops the update in progress and skips the others.
+func (a *Agent) updateBackends(ctx context.Context, address string, force bool) []BackendResult {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return nil
+	}
+
+	var results []BackendResult
+	for _, state := range a.backendStates {
+		if !force && state.lastIP == address {
+			continue
+		}
+
+		name := state.backend.Name()
+		err := state.backend.Update(ctx, addr)
+		results = append(results, BackendResult{Name: name, Err: err})
+		if err != nil && ctx.Err() != nil {
+			break // Stopping
+		}
+		if err != nil {
+			a.log.Error("Failed to update %s: %v", name, err)
+			a.fail(fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		state.lastIP = address
+		a.log.Info("%s updated to %s", name, address)
+	}
+	return results
+}
@@ -0,0 +1,349 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/api"
+	"github.com/qalisa/pierceflare/cli/internal/ip"
+	"github.com/qalisa/pierceflare/cli/internal/notify"
+)
+
+// state holds the state of the continuous mode between two checks
+type state struct {
+	lastSentIP   string      // Last IP acknowledged by the server
+	lastTopology ip.Topology // Topology of the last detection, to warn only when it changes
+
+	authSuspended  bool      // The server refused the API token, flares are suspended
+	nextRevalidate time.Time // When to check again whether the API token is accepted
+	backoffUntil   time.Time // No request is sent to the server before this time (rate limiting)
+
+	serverFailures int    // Flares failed in a row because the server was unavailable
+	fallbackActive bool   // The record is updated directly through Cloudflare until the server is back
+	fallbackIP     string // Address set through Cloudflare
+
+	cancelPropagation func() // Cancels the verification of the propagation of the last flared address
+
+	tokenUnchecked bool // The server could not be reached to check the API token when Run started
+}
+
+// Check runs a single iteration of the continuous mode: it flares the IP address if it changed since
+// the last flare, unless the agent is backing off (rate limiting) or its token is refused. Canceling ctx
+// stops the requests in progress.
+func (a *Agent) Check(ctx context.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.paused.Load() {
+		a.log.Debug("Paused, skipping check")
+		return
+	}
+
+	// Run negotiates before its first check, Check may be called without it
+	a.negotiate(ctx)
+
+	now := a.clock.Now()
+
+	// While rate limited, do not send anything to the server
+	if now.Before(a.backoffUntil) {
+		a.log.Debug("Rate limited, skipping check until %s", a.backoffUntil.Format(time.TimeOnly))
+		return
+	}
+
+	// While the token is refused, only check periodically whether it has been restored
+	if a.authSuspended {
+		if now.Before(a.nextRevalidate) {
+			a.log.Debug("API token refused, next revalidation at %s", a.nextRevalidate.Format(time.TimeOnly))
+			return
+		}
+
+		if !a.revalidate(ctx) {
+			return
+		}
+	}
+
+	if a.tokenUnchecked && !a.checkDeferredToken(ctx) {
+		return
+	}
+
+	a.processIPCheck(ctx)
+}
+
+// revalidate checks whether a previously refused API token is accepted again
+func (a *Agent) revalidate(ctx context.Context) bool {
+	a.log.Info("Checking whether the API token has been restored...")
+
+	_, err := a.checkToken(ctx)
+	if err != nil && ctx.Err() != nil {
+		return false // Stopping
+	}
+	if err != nil {
+		a.nextRevalidate = a.clock.Now().Add(a.revalidateInterval)
+		a.handleAPIError(err)
+		return false
+	}
+
+	a.authSuspended = false
+	a.lastSentIP = "" // Force a new flare, the record may have been changed meanwhile
+	a.log.Info("API token accepted again, resuming updates")
+	a.notifier.Notify(notify.EventAuthRestored, "API token accepted again, resuming updates")
+	return true
+}
+
+// checkDeferredToken checks the API token that could not be checked when Run started. It reports whether
+// the check should go on: while the server is still unavailable, the flare is attempted anyway, so that
+// it is queued or goes through the Cloudflare fallback.
+func (a *Agent) checkDeferredToken(ctx context.Context) bool {
+	_, err := a.checkToken(ctx)
+	switch {
+	case err == nil:
+		a.tokenUnchecked = false
+		a.log.Info("Server reachable, API token valid")
+		return true
+	case ctx.Err() != nil:
+		return false // Stopping
+	case api.IsAuthError(err) || errors.Is(err, ErrDomainMismatch):
+		a.tokenUnchecked = false
+		a.log.Error("Token validation error: %v", err)
+		a.handleAPIError(err)
+		return false
+	default:
+		a.log.Debug("API token still unchecked, server unavailable: %v", err)
+		return true
+	}
+}
+
+// processIPCheck checks the current IP and sends it if it has changed or if dummy updates are enabled
+func (a *Agent) processIPCheck(ctx context.Context) {
+	now := a.clock.Now()
+	a.updateStats(func(s *Stats) { s.Checks++; s.LastCheck = now })
+
+	detection, err := a.ipRetriever.Lookup(ctx, ip.FamilyAny)
+	if err != nil && ctx.Err() != nil {
+		return // Stopping
+	}
+	if err != nil {
+		a.log.Error("Error retrieving IP address: %v", err)
+		a.fail(err)
+		a.setUnhealthy("unable to retrieve IP address: %v", err)
+		return
+	}
+
+	if err := a.acceptDetection(detection); err != nil {
+		a.fail(err)
+		a.setUnhealthy("%v", err)
+		return
+	}
+	currentIP := detection.Address
+
+	a.log.Debug("IP check: current=%s, last=%s", currentIP, a.lastSentIP)
+
+	// If DummyUpdates is enabled, always send a dummy update
+	if a.dummyUpdates {
+		a.log.Info("Sending a test update (PIERCEFLARE_DUMMY_UPDATES mode enabled)")
+
+		// Send a dummy (test) update
+		if _, err := a.apiClient.SendIPUpdate(ctx, currentIP, true); err != nil {
+			if ctx.Err() != nil {
+				return // Stopping
+			}
+			a.log.Error("Failed to send test update to server: %v", err)
+			a.handleAPIError(err)
+			return
+		}
+
+		a.setHealthy()
+		a.log.Info("Test update successful")
+		return
+	}
+
+	// Backends do not depend on the server
+	a.updateBackends(ctx, currentIP, false)
+
+	// Check if the IP has changed. During a fallback, the server is tried at every check: once it
+	// acknowledges the address, it manages the record again.
+	ipChanged := currentIP != a.lastSentIP || a.fallbackActive
+
+	// Before its first flare (e.g. after a restart), the record may already serve the address. Once the
+	// agent has flared, its own state is more recent than what resolvers may still answer.
+	if a.lastSentIP == "" && !a.fallbackActive && a.servedByDNS(ctx, currentIP) {
+		a.lastSentIP = currentIP
+		a.dequeue()
+		a.updateStats(func(s *Stats) { s.Skipped++; s.CurrentIP = currentIP; s.LastChange = a.clock.Now() })
+		a.setHealthy()
+		return
+	}
+
+	if ipChanged {
+		if a.fallbackActive && currentIP == a.fallbackIP {
+			a.log.Debug("Trying the server again for %s (Cloudflare fallback active)", currentIP)
+		} else if a.lastSentIP != "" {
+			a.log.Info("IP address changed: %s -> %s", a.lastSentIP, currentIP)
+		} else {
+			a.log.Info("Initial IP detected: %s", currentIP)
+		}
+
+		// Send a real (not dummy) update
+		result, err := a.sendFlare(ctx, currentIP)
+		if err != nil {
+			if ctx.Err() != nil {
+				return // Stopping
+			}
+			a.log.Error("Failed to update IP on server: %v", err)
+			// Retried before the next check, the fallback only covers the record meanwhile
+			if api.IsUnavailable(err) {
+				a.enqueue(currentIP, err)
+			}
+			if a.flareFallback(ctx, currentIP, err) {
+				return
+			}
+			a.handleAPIError(err)
+			return
+		}
+		a.log.Info("IP update successful")
+		a.flared(currentIP, result)
+	} else {
+		// A flare still queued for another address is superseded by the acknowledged one
+		a.dequeue()
+
+		// Periodic log to indicate everything is working normally
+		a.setHealthy()
+		a.log.LogSuccess("IP unchanged (%s) - Connection with PierceFlare server maintained", currentIP)
+		a.log.Debug("IP address unchanged (%s). No update needed.", currentIP)
+	}
+}
+
+// detected records the last address detected of each family
+func (a *Agent) detected(detection *ip.Result) {
+	a.updateStats(func(s *Stats) {
+		if detection.Family == ip.FamilyIPv6 {
+			s.IPv6 = detection.Address
+		} else {
+			s.IPv4 = detection.Address
+		}
+	})
+}
+
+// sendFlare sends address to the server (a real update), recording the outcome
+func (a *Agent) sendFlare(ctx context.Context, address string) (*FlareResult, error) {
+	now := a.clock.Now()
+	result, err := a.apiClient.SendIPUpdate(ctx, address, false)
+	a.updateStats(func(s *Stats) {
+		s.LastFlare, s.LastFlareIP, s.LastFlareResult, s.LastFlareError = now, address, result, ""
+		if err != nil {
+			s.LastFlareError = err.Error()
+		}
+	})
+	return result, err
+}
+
+// flared records an address acknowledged by the server
+func (a *Agent) flared(address string, result *FlareResult) {
+	a.serverReachable()
+	a.dequeue()
+	if a.matcher != nil {
+		a.matcher.Forget(a.domain)
+	}
+
+	change := IPChange{Previous: a.lastSentIP, Current: address, Flare: result}
+	a.lastSentIP = address
+	a.updateStats(func(s *Stats) { s.Flares++; s.CurrentIP = address; s.LastChange = a.clock.Now() })
+	a.setHealthy()
+	a.verifyPropagation(a.publishedAddress(address, result))
+
+	for _, fn := range a.onIPChange {
+		fn(change)
+	}
+}
+
+// acceptDetection records a detected address, then checks that it may be flared given the topology of
+// the network. Checks and one-shot flares share it, so that both report the address even when refused.
+func (a *Agent) acceptDetection(detection *ip.Result) error {
+	a.detected(detection)
+	return a.checkTopology(detection)
+}
+
+// checkTopology warns when the host is behind carrier-grade NAT, and refuses to flare if configured to
+func (a *Agent) checkTopology(detection *ip.Result) error {
+	changed := detection.Topology != a.lastTopology
+	a.lastTopology = detection.Topology
+
+	if detection.Topology != ip.TopologyCGNAT {
+		return nil
+	}
+
+	if changed {
+		a.log.Error("WARNING: this host is behind carrier-grade NAT (local address in 100.64.0.0/10). "+
+			"%s is shared by the customers of the carrier, the DNS record will not reach this host.", detection.Address)
+	}
+	if a.refuseCGNAT {
+		if changed {
+			a.log.Error("Updates refused while behind carrier-grade NAT")
+		}
+		return ip.ErrBehindCGNAT
+	}
+	return nil
+}
+
+// handleAPIError adapts the behavior of the agent to a failed server call
+func (a *Agent) handleAPIError(err error) {
+	a.fail(err)
+
+	switch {
+	case api.IsAuthError(err):
+		a.nextRevalidate = a.clock.Now().Add(a.revalidateInterval)
+		if !a.authSuspended {
+			a.authSuspended = true
+			if errors.Is(err, api.ErrUnauthenticated) {
+				a.log.Error("Server rejected the API token as malformed (HTTP 401), check PIERCEFLARE_API_KEY")
+			} else {
+				a.log.Error("Server refused the API token (HTTP 403), it has probably been revoked")
+			}
+			a.log.Info("Updates suspended, the token will be revalidated every %s", a.revalidateInterval)
+			a.notifier.Notify(notify.EventAuthRevoked, "API token refused by server: %v", err)
+		}
+		a.setUnhealthy("API token refused by server")
+
+	case errors.Is(err, ErrDomainMismatch):
+		// Same as a refused token: wait for the key to be fixed
+		a.nextRevalidate = a.clock.Now().Add(a.revalidateInterval)
+		a.authSuspended = true
+		a.setUnhealthy("API token bound to an unexpected domain")
+
+	case errors.Is(err, api.ErrRateLimited):
+		delay := api.RetryAfter(err)
+		a.backoffUntil = a.clock.Now().Add(delay)
+		a.updateStats(func(s *Stats) { s.BackoffUntil = a.backoffUntil })
+		a.log.Info("Rate limited by server, pausing requests for %s", delay)
+		a.notifier.Notify(notify.EventRateLimited, "Rate limited by server, pausing requests for %s", delay)
+		a.setUnhealthy("rate limited by server")
+
+	default:
+		a.setUnhealthy("%v", err)
+	}
+}
+
+// fail reports a failed check to the callbacks
+func (a *Agent) fail(err error) {
+	a.updateStats(func(s *Stats) { s.Failures++ })
+	for _, fn := range a.onFailure {
+		fn(err)
+	}
+}
+
+// setHealthy marks the agent healthy and notifies recovery
+func (a *Agent) setHealthy() {
+	if a.health.SetHealthy() {
+		a.notifier.Notify(notify.EventRecovered, "IP updates are working again")
+	}
+}
+
+// setUnhealthy marks the agent unhealthy and notifies the first failure
+func (a *Agent) setUnhealthy(format string, args ...interface{}) {
+	reason := fmt.Sprintf(format, args...)
+	if a.health.SetUnhealthy(reason) {
+		a.notifier.Notify(notify.EventUnhealthy, "IP updates are failing: %s", reason)
+	}
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Pause suspends the checks of the agent, e.g. during a maintenance window; Run keeps running and
+// resumes checking after Resume. It returns false if the agent was already paused.
+func (a *Agent) Pause() bool {
+	if !a.paused.CompareAndSwap(false, true) {
+		return false
+	}
+	a.log.Info("Paused, checks suspended until resumed")
+	return true
+}
+
+// Resume ends a Pause, checking the address right away. It returns false if the agent was not paused.
+func (a *Agent) Resume() bool {
+	if !a.paused.CompareAndSwap(true, false) {
+		return false
+	}
+	a.log.Info("Resumed, checking the address")
+
+	// The address may have changed meanwhile; if a check is already requested, it covers this one
+	select {
+	case a.triggers <- nil:
+	default:
+	}
+	return true
+}
+
+// Paused reports whether the checks of the agent are suspended
+func (a *Agent) Paused() bool {
+	return a.paused.Load()
+}
+
+// Trigger makes Run check the address immediately, instead of waiting for the next scheduled check,
+// and waits until the check is done. The schedule resumes from it.
+func (a *Agent) Trigger(ctx context.Context) error {
+	stats := a.Stats()
+	switch {
+	case !stats.Running:
+		return ErrNotRunning
+	case stats.Paused:
+		return ErrPaused
+	case a.clock.Now().Before(stats.BackoffUntil):
+		return fmt.Errorf("%w, requests paused until %s", ErrRateLimited, stats.BackoffUntil.Format(time.TimeOnly))
+	}
+
+	a.log.Info("Check triggered")
+	done := make(chan struct{})
+	select {
+	case a.triggers <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
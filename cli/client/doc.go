@@ -0,0 +1,25 @@
+// Package client embeds the PierceFlare DDNS agent in other Go programs.
+//
+// An Agent detects the public IP address of the host and flares it to a PierceFlare server,
+// which updates the Cloudflare DNS record bound to the API token:
+//
+//	agent, err := client.NewAgent(
+//		client.WithAPIKey(os.Getenv("PIERCEFLARE_API_KEY")),
+//		client.WithServerURL("https://pierceflare.example.com"),
+//		client.WithLogger(client.NewSlogLogger(slog.Default())),
+//		client.OnIPChange(func(change client.IPChange) {
+//			slog.Info("public IP changed", "from", change.Previous, "to", change.Current)
+//		}),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//
+//	// Check periodically until ctx is canceled...
+//	err = agent.Run(ctx)
+//
+//	// ...or flare once
+//	flare, err := agent.FlareOnce(ctx)
+//
+// The pierceflare-cli binary is a thin wrapper around this package.
+package client
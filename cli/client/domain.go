@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/qalisa/pierceflare/cli/internal/api"
+)
+
+// checkToken checks that the server accepts the API token and returns the domain bound to it,
+// empty if the server cannot tell. The domain is logged when it changes, prefixes the log messages
+// of the agent and must match the expected domain, if any.
+func (a *Agent) checkToken(ctx context.Context) (string, error) {
+	if !a.compat.Supports(api.CapabilityInfos) {
+		return "", nil
+	}
+
+	domain, err := a.apiClient.GetBoundDomain(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if domain != a.domain {
+		previousKey := a.queueKey()
+		a.domain = domain
+		a.requeue(previousKey)
+		a.log.SetPrefix(strings.TrimSpace(a.name + " " + domain))
+		a.log.SetField("domain", domain)
+		a.updateStats(func(s *Stats) { s.Domain = domain })
+		a.log.Info("API token bound to %s", domain)
+	}
+
+	if a.expectedDomain != "" && !sameDomain(domain, a.expectedDomain) {
+		a.log.Error("API token bound to %s instead of %s, check the API key deployed on this host", domain, a.expectedDomain)
+		return domain, fmt.Errorf("%w: %s instead of %s", ErrDomainMismatch, domain, a.expectedDomain)
+	}
+
+	return domain, nil
+}
+
+// sameDomain compares domain names, ignoring case and the trailing dot of fully qualified names
+func sameDomain(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/qalisa/pierceflare/cli/internal/api"
+	"github.com/qalisa/pierceflare/cli/internal/ip"
+)
+
+// Errors returned by the Agent. Use errors.Is to test for them.
+var (
+	// ErrNoIPFound is returned when no source gave a valid IP address
+	ErrNoIPFound = ip.ErrNoIPFound
+	// ErrBehindCGNAT is returned instead of flaring when WithRefuseCGNAT is set and the host is behind carrier-grade NAT
+	ErrBehindCGNAT = ip.ErrBehindCGNAT
+	// ErrUnauthenticated is returned when the server did not accept the request credentials (HTTP 401)
+	ErrUnauthenticated = api.ErrUnauthenticated
+	// ErrAuthRevoked is returned when the server does not know the API token anymore (HTTP 403)
+	ErrAuthRevoked = api.ErrAuthRevoked
+	// ErrRateLimited is returned when the server asks the client to slow down (HTTP 429)
+	ErrRateLimited = api.ErrRateLimited
+	// ErrUnresolvable is returned when the server could not resolve the IP of the flare emitter
+	ErrUnresolvable = api.ErrUnresolvable
+	// ErrServer is returned when the server answered with an unexpected failure
+	ErrServer = api.ErrServer
+	// ErrNetwork is returned when the server could not be reached
+	ErrNetwork = api.ErrNetwork
+	// ErrDomainMismatch is returned when the API token is bound to another domain than the one given to WithExpectedDomain
+	ErrDomainMismatch = errors.New("API token bound to an unexpected domain")
+	// ErrNotConfigured is returned when talking to the server without API key or server URL
+	ErrNotConfigured = errors.New("API key and server URL are required")
+	// ErrNotRunning is returned by Trigger when Run is not checking periodically
+	ErrNotRunning = errors.New("agent not running")
+	// ErrPaused is returned by Trigger while the agent is paused
+	ErrPaused = errors.New("agent paused")
+)
+
+// APIError describes a failed call to the PierceFlare server (status code, server error code, advised retry delay)
+type APIError = api.Error
+
+// IsAuthError reports whether err means the server refused the API token
+func IsAuthError(err error) bool {
+	return api.IsAuthError(err)
+}
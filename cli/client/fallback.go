@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/qalisa/pierceflare/cli/internal/api"
+	"github.com/qalisa/pierceflare/cli/internal/notify"
+)
+
+// flareFallback updates the record directly through Cloudflare once the server has been unavailable
+// for fallbackAfter flares in a row. It reports whether the address was handled this way.
+func (a *Agent) flareFallback(ctx context.Context, address string, err error) bool {
+	if a.cloudflare == nil || !api.IsUnavailable(err) {
+		return false
+	}
+
+	a.serverFailures++
+	if a.serverFailures < a.fallbackAfter {
+		a.log.Debug("Server unavailable (%d/%d before falling back to Cloudflare)", a.serverFailures, a.fallbackAfter)
+		return false
+	}
+
+	// Already pointing there, only wait for the server to come back
+	if a.fallbackActive && a.fallbackIP == address {
+		a.log.Debug("Server still unavailable, %s already set through Cloudflare", address)
+		return true
+	}
+
+	record := a.cloudflare.RecordName(a.domain)
+	if record == "" {
+		a.log.Error("Cloudflare fallback unavailable: no record name configured and the domain bound to the token is unknown")
+		return false
+	}
+
+	addr, parseErr := netip.ParseAddr(address)
+	if parseErr != nil {
+		return false
+	}
+
+	changed, cfErr := a.cloudflare.Update(ctx, record, addr)
+	if cfErr != nil {
+		a.log.Error("Cloudflare fallback failed: %v", cfErr)
+		return false
+	}
+
+	if !a.fallbackActive {
+		a.log.Error("PierceFlare server unavailable for %d flares in a row, updating %s directly through Cloudflare", a.serverFailures, record)
+		a.notifier.Notify(notify.EventFallback, "PierceFlare server unavailable, %s updated directly through Cloudflare", record)
+	}
+	if changed {
+		a.log.Info("%s now points to %s (Cloudflare fallback)", record, address)
+	} else {
+		a.log.Info("%s already points to %s (Cloudflare fallback)", record, address)
+	}
+
+	a.fallbackActive = true
+	a.fallbackIP = address
+	a.updateStats(func(s *Stats) { s.Fallback = true })
+	a.setHealthy()
+	return true
+}
+
+// serverReachable ends the fallback, if any, after a flare acknowledged by the server
+func (a *Agent) serverReachable() {
+	a.serverFailures = 0
+	if !a.fallbackActive {
+		return
+	}
+
+	a.fallbackActive = false
+	a.fallbackIP = ""
+	a.updateStats(func(s *Stats) { s.Fallback = false })
+	a.log.Info("PierceFlare server available again, back to server-mediated flares")
+	a.notifier.Notify(notify.EventFallbackEnded, "PierceFlare server available again, back to server-mediated flares")
+}
@@ -0,0 +1,75 @@
+package client_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+func TestCloudflareFallback(t *testing.T) {
+	const cfToken = "cf-token"
+
+	cf := testserver.NewCloudflare(cfToken, "example.com")
+	defer cf.Close()
+
+	// The server is already unavailable when the agent starts
+	ta := newTestAgent(t, nil, client.WithCloudflareFallback(client.CloudflareOptions{
+		Token:  cfToken,
+		Record: testDomain,
+		APIURL: cf.APIURL(),
+	}, 3))
+	ta.srv.SetDefault(testserver.Behavior{Status: http.StatusServiceUnavailable})
+	ta.run(t)
+
+	// Cloudflare is left alone until the server failed 3 flares in a row
+	for i := 2; i <= 3; i++ {
+		if cf.Requests() != 0 {
+			t.Fatalf("Cloudflare called after %d unavailable flares, want 3", i-1)
+		}
+		if flares := ta.trigger(t); len(flares) != 1 || flares[0].Status != http.StatusServiceUnavailable {
+			t.Fatalf("flares = %+v, want one failed with HTTP 503", flares)
+		}
+	}
+	if content, ok := cf.Record("A", testDomain); !ok || content != testAddress {
+		t.Fatalf("record = %q (exists: %v), want %s written by the fallback", content, ok, testAddress)
+	}
+	stats := ta.Stats()
+	if !stats.Fallback || stats.Queued != testAddress {
+		t.Errorf("fallback = %v, queued = %q, want true, %s", stats.Fallback, stats.Queued, testAddress)
+	}
+	if healthy, reason := ta.Healthy(); !healthy {
+		t.Errorf("agent unhealthy while the fallback covers the record: %s", reason)
+	}
+
+	// Already pointing there, Cloudflare is not called again
+	requests := cf.Requests()
+	ta.trigger(t)
+	if got := cf.Requests(); got != requests {
+		t.Errorf("%d Cloudflare requests for an address already set", got-requests)
+	}
+
+	// Once the server is back, the address is flared to it and it manages the record again
+	ta.srv.SetDefault(testserver.Behavior{})
+	if flares := ta.trigger(t); len(flares) != 1 || flares[0].Status != http.StatusOK {
+		t.Fatalf("flares = %+v, want one acknowledged once the server is back", flares)
+	}
+	stats = ta.Stats()
+	if stats.Fallback || stats.Queued != "" || stats.CurrentIP != testAddress || stats.Domain != testDomain {
+		t.Errorf("fallback = %v, queued = %q, current IP = %s, domain = %q, want false, none, %s, %s",
+			stats.Fallback, stats.Queued, stats.CurrentIP, stats.Domain, testAddress, testDomain)
+	}
+	if len(ta.changes) != 1 || ta.changes[0].Current != testAddress {
+		t.Errorf("IP changes = %+v, want %s acknowledged by the server", ta.changes, testAddress)
+	}
+
+	// The server manages the record again, no more trying it at every check
+	requests = cf.Requests()
+	if flares := ta.trigger(t); len(flares) != 0 {
+		t.Errorf("flared %d times the acknowledged address", len(flares))
+	}
+	if got := cf.Requests(); got != requests {
+		t.Errorf("%d Cloudflare requests after the fallback ended", got-requests)
+	}
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+)
+
+// Logger receives the messages of the Agent
+type Logger interface {
+	Debug(msg string)
+	Info(msg string)
+	Error(msg string)
+}
+
+// LogLevel is the verbosity of the default logger
+type LogLevel = logger.LogLevel
+
+// Verbosity levels of the default logger
+const (
+	LogLevelError = logger.LogLevelError
+	LogLevelInfo  = logger.LogLevelInfo
+	LogLevelDebug = logger.LogLevelDebug
+)
+
+// NewSlogLogger creates a Logger forwarding messages to a structured logger
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Debug(msg string) { l.logger.Log(context.Background(), slog.LevelDebug, msg) }
+func (l slogLogger) Info(msg string)  { l.logger.Log(context.Background(), slog.LevelInfo, msg) }
+func (l slogLogger) Error(msg string) { l.logger.Log(context.Background(), slog.LevelError, msg) }
+
+// loggerSink adapts a Logger to the internal logger
+type loggerSink struct {
+	logger Logger
+}
+
+func (s loggerSink) Log(level logger.LogLevel, message string) {
+	switch level {
+	case logger.LogLevelError:
+		s.logger.Error(message)
+	case logger.LogLevelDebug:
+		s.logger.Debug(message)
+	default:
+		s.logger.Info(message)
+	}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+
+	"github.com/qalisa/pierceflare/cli/internal/api"
+)
+
+// Compatibility is the outcome of the negotiation of the API version and capabilities with the server
+type Compatibility = api.Compatibility
+
+// Capability is a feature of the PierceFlare API the agent relies on
+type Capability = api.Capability
+
+// API capabilities
+const (
+	CapabilityInfos = api.CapabilityInfos
+	CapabilityFlare = api.CapabilityFlare
+	CapabilityDummy = api.CapabilityDummy
+)
+
+// Compatibility returns the outcome of the negotiation with the server, nil before the first
+// FlareOnce or Run
+func (a *Agent) Compatibility() *Compatibility {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.compat
+}
+
+// negotiate compares the API of the server with the compiled-in one, once. It never fails: the agent
+// warns about incompatibilities and disables the features the server does not advertise.
+func (a *Agent) negotiate(ctx context.Context) {
+	if a.compat != nil {
+		return
+	}
+
+	compat, err := a.apiClient.Negotiate(ctx)
+	a.compat = compat
+
+	switch {
+	case err != nil:
+		a.log.Info("Unable to check the API version of the server, assuming version %s: %v", compat.ClientVersion, err)
+	case !compat.Compatible:
+		a.log.Error("WARNING: incompatible server: %s. Updates will probably fail, upgrade the client or the server.", compat.Reason)
+	default:
+		a.log.Debug("Server API version %s, client API version %s", compat.ServerVersion, compat.ClientVersion)
+	}
+
+	if !compat.Supports(api.CapabilityInfos) {
+		a.log.Info("The server does not advertise GET /api/infos, the API token will not be checked before flaring")
+	}
+	if a.dummyUpdates && !compat.Supports(api.CapabilityDummy) {
+		a.log.Info("The server does not support test updates, dummy updates disabled")
+		a.dummyUpdates = false
+	}
+}
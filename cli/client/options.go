@@ -0,0 +1,272 @@
+package client
+
+import (
+	"io"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/clock"
+	"github.com/qalisa/pierceflare/cli/internal/cloudflare"
+	"github.com/qalisa/pierceflare/cli/internal/ip"
+	"github.com/qalisa/pierceflare/cli/internal/schedule"
+	"github.com/qalisa/pierceflare/cli/internal/transport"
+)
+
+// Default settings of an Agent
+const (
+	DefaultCheckInterval      = 5 * time.Minute
+	DefaultRevalidateInterval = 15 * time.Minute
+	DefaultSuccessLogPeriod   = 10
+	DefaultFallbackAfter      = 3
+)
+
+// Source gives the public IP address of the host, as seen by a remote service.
+// Implement it to plug custom detection methods (router API, cloud metadata...).
+type Source = ip.Source
+
+// HTTPSource is an echo service answering the address of the caller in the body of a GET request
+type HTTPSource = ip.HTTPSource
+
+// DefaultSources returns the echo services queried by default, in order
+func DefaultSources() []Source {
+	return ip.DefaultSources()
+}
+
+// TransportOptions configures HTTP clients: proxy, source address or interface binding, TLS, timeout
+type TransportOptions = transport.Options
+
+// CloudflareOptions configures the direct access to the Cloudflare API used by WithCloudflareFallback
+type CloudflareOptions = cloudflare.Options
+
+// ScheduleOptions configures when Run checks the address: cron expression, random delays, quiet windows.
+// Its Interval defaults to the one set by WithCheckInterval.
+type ScheduleOptions = schedule.Options
+
+// Clock gives the current time and creates tickers
+type Clock = clock.Clock
+
+// Option configures an Agent
+type Option func(*settings)
+
+// settings holds the configuration of an Agent
+type settings struct {
+	name               string
+	apiKey             string
+	serverURL          string
+	expectedDomain     string
+	apiTransport       TransportOptions
+	detectTransport    TransportOptions
+	sources            []Source
+	hedgeDelay         time.Duration
+	checkInterval      time.Duration
+	schedule           ScheduleOptions
+	revalidateInterval time.Duration
+	dummyUpdates       bool
+	refuseCGNAT        bool
+	signRequests       bool
+	cloudflareOptions  *CloudflareOptions
+	fallbackAfter      int
+	backends           []Backend
+	propagationOptions *PropagationOptions
+	precheckResolver   *string
+	queue              *Queue
+	logger             Logger
+	logLevel           LogLevel
+	logOutput          io.Writer
+	logTimestamp       bool
+	successLogPeriod   int
+	journalIdentifier  string
+	heartbeatInterval  time.Duration
+	healthFile         string
+	notifyURL          string
+	clock              Clock
+	onIPChange         []func(IPChange)
+	onFailure          []func(error)
+	onStart            []func()
+}
+
+// WithName names the agent, e.g. after the uplink it tracks; the name prefixes its log messages
+func WithName(name string) Option {
+	return func(s *settings) { s.name = name }
+}
+
+// WithAPIKey sets the API token generated by the PierceFlare server (required)
+func WithAPIKey(apiKey string) Option {
+	return func(s *settings) { s.apiKey = apiKey }
+}
+
+// WithServerURL sets the URL of the PierceFlare server (required)
+func WithServerURL(serverURL string) Option {
+	return func(s *settings) { s.serverURL = serverURL }
+}
+
+// WithExpectedDomain makes the agent refuse to work, failing with ErrDomainMismatch, if the API token
+// is bound to another domain, to catch keys deployed on the wrong host
+func WithExpectedDomain(domain string) Option {
+	return func(s *settings) { s.expectedDomain = domain }
+}
+
+// WithAPITransport configures the HTTP client talking to the PierceFlare server
+func WithAPITransport(opts TransportOptions) Option {
+	return func(s *settings) { s.apiTransport = opts }
+}
+
+// WithDetectTransport configures the HTTP clients querying the IP sources
+func WithDetectTransport(opts TransportOptions) Option {
+	return func(s *settings) { s.detectTransport = opts }
+}
+
+// WithSources replaces the IP sources queried, in order (DefaultSources by default)
+func WithSources(sources ...Source) Option {
+	return func(s *settings) { s.sources = sources }
+}
+
+// WithHedgedDetection queries the next IP source when the previous ones did not answer within delay,
+// the first valid answer being used and the other queries canceled. This bounds the time lost on a slow
+// source without giving up the order of the sources. With 0 (the default), sources are queried one
+// after the other.
+func WithHedgedDetection(delay time.Duration) Option {
+	return func(s *settings) { s.hedgeDelay = delay }
+}
+
+// WithCheckInterval sets the interval between two checks in Run (DefaultCheckInterval by default), unless
+// WithSchedule sets a cron expression
+func WithCheckInterval(interval time.Duration) Option {
+	return func(s *settings) { s.checkInterval = interval }
+}
+
+// WithSchedule sets when Run checks the address: following a cron expression instead of the interval,
+// with random delays spreading the checks of hosts started together, outside of quiet windows
+func WithSchedule(opts ScheduleOptions) Option {
+	return func(s *settings) { s.schedule = opts }
+}
+
+// WithRevalidateInterval sets how often a token refused by the server is checked again (DefaultRevalidateInterval by default)
+func WithRevalidateInterval(interval time.Duration) Option {
+	return func(s *settings) { s.revalidateInterval = interval }
+}
+
+// WithDummyUpdates makes Run send a test flare at every check, which the server does not propagate to Cloudflare
+func WithDummyUpdates(enabled bool) Option {
+	return func(s *settings) { s.dummyUpdates = enabled }
+}
+
+// WithRefuseCGNAT makes the agent refuse to flare, failing with ErrBehindCGNAT, when the host is behind
+// carrier-grade NAT (the DNS record would point to the carrier). By default, it only warns.
+func WithRefuseCGNAT(refuse bool) Option {
+	return func(s *settings) { s.refuseCGNAT = refuse }
+}
+
+// WithRequestSigning makes the agent sign its requests to the server (see package signing), so that
+// a server verifying signatures refuses altered or replayed requests
+func WithRequestSigning(enabled bool) Option {
+	return func(s *settings) { s.signRequests = enabled }
+}
+
+// WithCloudflareFallback makes Run update the record directly through the Cloudflare API once the server
+// has been unavailable (unreachable or HTTP 5xx) for after flares in a row (DefaultFallbackAfter if 0).
+// The server is still tried at every check, and manages the record again as soon as it acknowledges a flare.
+// Calls to Cloudflare use the detection transport, with the timeout of the API transport.
+func WithCloudflareFallback(opts CloudflareOptions, after int) Option {
+	return func(s *settings) {
+		s.cloudflareOptions = &opts
+		s.fallbackAfter = after
+	}
+}
+
+// WithBackends adds backends pointed to the detected address besides the PierceFlare server, e.g. to keep
+// an internal authoritative server in sync (NewRFC2136Backend, NewDynDNS2Backend or a custom Backend).
+// Each backend is only updated when the address changes, and retried at the next check after a failure.
+func WithBackends(backends ...Backend) Option {
+	return func(s *settings) { s.backends = append(s.backends, backends...) }
+}
+
+// WithPropagationCheck makes the agent verify that the resolvers of the options serve each flared address,
+// as the server only queues flares: Run verifies in the background and alerts (log, notification, Stats)
+// if the record does not converge in time, FlareOnce waits for the outcome. Proxied records, whose
+// resolvers answer Cloudflare edge addresses, are not verified. DoH queries use the detection transport.
+func WithPropagationCheck(opts PropagationOptions) Option {
+	return func(s *settings) { s.propagationOptions = &opts }
+}
+
+// WithDNSPrecheck makes Run look up the record of the domain bound to the token before its first flare
+// (at startup, or once a refused token is accepted again), and skip the flare if the record already serves
+// the address: restarted agents do not consume the rate limit of the server. resolver is a host[:port], a
+// DoH URL or AuthoritativeResolvers (the default, when empty). Answers are cached for their TTL.
+func WithDNSPrecheck(resolver string) Option {
+	return func(s *settings) { s.precheckResolver = &resolver }
+}
+
+// WithQueue keeps the flares that did not reach the server (unreachable or HTTP 5xx) in q, to retry them
+// with backoff between checks and across restarts when q is persisted to a file. Each domain has a single
+// slot: a new address supersedes the pending one. By default, agents keep their flares in memory.
+func WithQueue(q *Queue) Option {
+	return func(s *settings) { s.queue = q }
+}
+
+// WithLogger passes the messages of the agent to l instead of the standard output
+func WithLogger(l Logger) Option {
+	return func(s *settings) { s.logger = l }
+}
+
+// WithLogLevel sets the verbosity of the agent (LogLevelInfo by default)
+func WithLogLevel(level LogLevel) Option {
+	return func(s *settings) { s.logLevel = level }
+}
+
+// WithLogOutput redirects the default logger (stdout by default); ignored when WithLogger is used
+func WithLogOutput(w io.Writer) Option {
+	return func(s *settings) { s.logOutput = w }
+}
+
+// WithLogTimestamp enables or disables timestamps in the messages of the default logger (enabled by default)
+func WithLogTimestamp(enabled bool) Option {
+	return func(s *settings) { s.logTimestamp = enabled }
+}
+
+// WithJournald sends the messages of the agent to journald with its native protocol, tagged with identifier
+// and with the name of the agent and its domain as structured fields (PIERCEFLARE_UPLINK, PIERCEFLARE_DOMAIN).
+// The default logger is used if journald cannot be reached; ignored when WithLogger is used.
+func WithJournald(identifier string) Option {
+	return func(s *settings) { s.journalIdentifier = identifier }
+}
+
+// WithSuccessLogPeriod sets the number of successful checks between two success messages (0 = every check)
+func WithSuccessLogPeriod(period int) Option {
+	return func(s *settings) { s.successLogPeriod = period }
+}
+
+// WithHeartbeat makes Run record a heartbeat (see Stats) at this interval between checks, so that a
+// watchdog can tell a stuck agent from one waiting for its next check. Disabled by default.
+func WithHeartbeat(interval time.Duration) Option {
+	return func(s *settings) { s.heartbeatInterval = interval }
+}
+
+// WithHealthFile makes the agent keep a file present only while it is healthy, for container probes
+func WithHealthFile(path string) Option {
+	return func(s *settings) { s.healthFile = path }
+}
+
+// WithNotifyURL makes the agent post notifications (token revoked, rate limited, failures, recovery) to a webhook
+func WithNotifyURL(url string) Option {
+	return func(s *settings) { s.notifyURL = url }
+}
+
+// WithClock replaces the clock of the agent, for tests
+func WithClock(c Clock) Option {
+	return func(s *settings) { s.clock = c }
+}
+
+// OnIPChange registers a callback called after the server acknowledged a new IP address
+func OnIPChange(fn func(IPChange)) Option {
+	return func(s *settings) { s.onIPChange = append(s.onIPChange, fn) }
+}
+
+// OnFailure registers a callback called when a check fails (detection or flare)
+func OnFailure(fn func(error)) Option {
+	return func(s *settings) { s.onFailure = append(s.onFailure, fn) }
+}
+
+// OnStart registers a callback called when Run passed its startup checks, before the first check
+func OnStart(fn func()) Option {
+	return func(s *settings) { s.onStart = append(s.onStart, fn) }
+}
@@ -0,0 +1,65 @@
+package client_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/dnswire"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+func TestDNSPrecheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		answer    testserver.DNSAnswer
+		wantFlare bool
+	}{
+		{name: "served", answer: testserver.DNSAnswer{Addrs: []string{testAddress}, TTL: 300}},
+		{name: "stale", answer: testserver.DNSAnswer{Addrs: []string{"93.184.216.35"}, TTL: 300}, wantFlare: true},
+		{name: "no record", answer: testserver.DNSAnswer{Rcode: dnswire.RcodeNXDomain}, wantFlare: true},
+		{name: "lookup error", answer: testserver.DNSAnswer{Rcode: 2}, wantFlare: true}, // SERVFAIL
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dns := testserver.NewDNS(tt.answer)
+			defer dns.Close()
+
+			ta := newTestAgent(t, nil, client.WithDNSPrecheck(dns.Addr()))
+			ta.run(t)
+
+			flares := ta.srv.Flares()
+			if tt.wantFlare != (len(flares) == 1) || len(flares) > 1 {
+				t.Fatalf("flares = %+v, want flared: %v", flares, tt.wantFlare)
+			}
+			if tt.wantFlare && flares[0].Status != http.StatusOK {
+				t.Errorf("flare status = %d, want %d", flares[0].Status, http.StatusOK)
+			}
+			if dns.Queries() == 0 {
+				t.Error("record not looked up before the first flare")
+			}
+
+			stats := ta.Stats()
+			wantSkipped := uint64(0)
+			if !tt.wantFlare {
+				wantSkipped = 1
+			}
+			if stats.Skipped != wantSkipped || stats.CurrentIP != testAddress {
+				t.Errorf("skipped = %d, current IP = %s, want %d, %s", stats.Skipped, stats.CurrentIP, wantSkipped, testAddress)
+			}
+			if healthy, reason := ta.Healthy(); !healthy {
+				t.Errorf("agent unhealthy: %s", reason)
+			}
+
+			// Once the address is known, the record is not looked up anymore
+			queries := dns.Queries()
+			if flares := ta.trigger(t); len(flares) != 0 {
+				t.Errorf("flares = %+v, want none for an unchanged address", flares)
+			}
+			if got := dns.Queries(); got != queries {
+				t.Errorf("%d lookups after the first check, want none", got-queries)
+			}
+		})
+	}
+}
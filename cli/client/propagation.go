@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/dnscheck"
+	"github.com/qalisa/pierceflare/cli/internal/notify"
+)
+
+// PropagationOptions configures the verification of flared addresses (resolvers, timeouts)
+type PropagationOptions = dnscheck.Options
+
+// PropagationResult describes the propagation of a flared address on each resolver
+type PropagationResult = dnscheck.Result
+
+// AuthoritativeResolvers designates, in PropagationOptions.Resolvers, the authoritative servers of the domain
+const AuthoritativeResolvers = dnscheck.Authoritative
+
+// ErrNotPropagated is returned when resolvers still do not serve a flared address at the end of the verification
+var ErrNotPropagated = dnscheck.ErrNotConverged
+
+// publishedAddress returns the address the server writes to the record for a flare of address: the one it
+// resolved (the remote address of the request, unless private), the flared one if it did not tell.
+// Behind a proxy, a bound source address or another uplink, it may differ from the detected address.
+func (a *Agent) publishedAddress(address string, result *FlareResult) string {
+	if result == nil || result.ResolvedIP == "" {
+		return address
+	}
+	if result.ResolvedIP != address {
+		a.log.Debug("The server publishes %s instead of the flared %s", result.ResolvedIP, address)
+	}
+	return result.ResolvedIP
+}
+
+// verifyPropagation starts verifying in the background that the resolvers serve address, canceling the
+// verification of the previous address, if any
+func (a *Agent) verifyPropagation(address string) {
+	a.stopPropagation()
+	if a.verifier == nil {
+		return
+	}
+
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return
+	}
+	if a.domain == "" {
+		a.log.Debug("Domain bound to the token unknown, propagation of %s not verified", address)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancelPropagation = cancel
+	a.updateStats(func(s *Stats) { s.PropagationTime = 0; s.PropagationFailed = false })
+
+	domain := a.domain
+	go func() {
+		defer cancel()
+		a.log.Debug("Verifying the propagation of %s to %s", address, domain)
+		result, err := a.verifier.Verify(ctx, domain, addr)
+		a.reportPropagation(result, err)
+	}()
+}
+
+// stopPropagation cancels the verification in progress, if any
+func (a *Agent) stopPropagation() {
+	if a.cancelPropagation != nil {
+		a.cancelPropagation()
+		a.cancelPropagation = nil
+	}
+}
+
+// reportPropagation logs the outcome of a verification and alerts when the record did not converge
+func (a *Agent) reportPropagation(result *PropagationResult, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		a.log.Debug("Verification of the propagation of %s canceled", result.Address)
+
+	case err != nil:
+		a.log.Error("DNS record of %s does not serve %s: %v", result.Domain, result.Address, err)
+		a.notifier.Notify(notify.EventPropagationFailed, "DNS record of %s does not serve %s: %v", result.Domain, result.Address, err)
+		a.updateStats(func(s *Stats) { s.PropagationFailed = true })
+
+	case result.Proxied:
+		a.log.Info("%s is proxied by Cloudflare, resolvers answer edge addresses instead of %s", result.Domain, result.Address)
+
+	default:
+		a.log.Info("%s serves %s after %s (%s)", result.Domain, result.Address, result.Duration.Round(time.Millisecond), strings.Join(resolverNames(result), ", "))
+		a.updateStats(func(s *Stats) { s.PropagationTime = result.Duration })
+	}
+}
+
+// checkPropagation verifies that the resolvers serve address, waiting for the outcome
+func (a *Agent) checkPropagation(ctx context.Context, address string) (*PropagationResult, error) {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	if a.domain == "" {
+		a.log.Debug("Domain bound to the token unknown, propagation of %s not verified", address)
+		return nil, nil
+	}
+
+	a.log.Info("Verifying the propagation of %s to %s...", address, a.domain)
+	result, err := a.verifier.Verify(ctx, a.domain, addr)
+	a.reportPropagation(result, err)
+	return result, err
+}
+
+// servedByDNS reports whether the record of the domain bound to the token already serves address,
+// with WithDNSPrecheck. Lookup failures are logged, the address is flared then.
+func (a *Agent) servedByDNS(ctx context.Context, address string) bool {
+	if a.matcher == nil || a.domain == "" {
+		return false
+	}
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+
+	served, answer, err := a.matcher.Serves(ctx, a.domain, addr)
+	switch {
+	case err != nil:
+		a.log.Debug("Unable to look up %s on %s, flaring: %v", a.domain, a.matcher.Name(), err)
+		return false
+	case !served && len(answer) == 0:
+		a.log.Debug("%s has no record according to %s, flaring %s", a.domain, a.matcher.Name(), address)
+		return false
+	case !served:
+		a.log.Debug("%s serves %v according to %s, flaring %s", a.domain, answer, a.matcher.Name(), address)
+		return false
+	}
+
+	a.log.Info("%s already serves %s according to %s, flare skipped", a.domain, address, a.matcher.Name())
+	return true
+}
+
+// resolverNames lists the resolvers of a result
+func resolverNames(result *PropagationResult) []string {
+	names := make([]string, len(result.Resolvers))
+	for i, r := range result.Resolvers {
+		names[i] = r.Name
+	}
+	return names
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/api"
+	"github.com/qalisa/pierceflare/cli/internal/queue"
+)
+
+// Queue holds the flares the server could not be reached for, one per domain, optionally persisted to a
+// file. A Queue may be shared by the agents of several uplinks.
+type Queue = queue.Queue
+
+// QueueEntry is a pending flare
+type QueueEntry = queue.Entry
+
+// OpenQueue loads the queue persisted to path (see WithQueue), kept in memory only if path is empty
+func OpenQueue(path string) (*Queue, error) {
+	return queue.Open(path)
+}
+
+// queueRetryInterval is the delay before retrying a queued flare, doubled after each failure up to the
+// interval between checks
+const queueRetryInterval = 15 * time.Second
+
+// queueKey identifies the slot of the agent in the queue
+func (a *Agent) queueKey() string {
+	switch {
+	case a.domain != "":
+		return a.domain
+	case a.expectedDomain != "":
+		return a.expectedDomain // Until the server is reachable to tell the domain bound to the token
+	case a.name != "":
+		return a.name
+	default:
+		return "default"
+	}
+}
+
+// enqueue records a flare of address that did not reach the server, to be retried with backoff
+func (a *Agent) enqueue(address string, err error) {
+	now := a.clock.Now()
+	attempts := 1
+	if e := a.queue.Get(a.queueKey()); e != nil && e.IP == address {
+		attempts = e.Attempts + 1
+	}
+
+	delay := queueRetryInterval
+	for i := 1; i < attempts && delay < a.checkInterval; i++ {
+		delay *= 2
+	}
+	delay = min(delay, a.checkInterval)
+
+	entry, saveErr := a.queue.Put(a.queueKey(), address, now, now.Add(delay), err)
+	if saveErr != nil {
+		a.log.Error("Unable to persist the flare queue: %v", saveErr)
+	}
+	if entry.Attempts == 1 {
+		a.log.Info("Flare of %s queued until the server is reachable", address)
+	}
+	a.log.Debug("Flare of %s queued (attempt %d), next attempt at %s", address, entry.Attempts, entry.NextAttempt.Format(time.TimeOnly))
+	a.updateStats(func(s *Stats) { s.Queued = entry.IP; s.QueuedSince = entry.Queued })
+}
+
+// dequeue drops the pending flare of the agent, delivered or superseded by the address acknowledged last
+func (a *Agent) dequeue() {
+	if a.queue.Get(a.queueKey()) == nil {
+		return
+	}
+	if err := a.queue.Remove(a.queueKey()); err != nil {
+		a.log.Error("Unable to persist the flare queue: %v", err)
+	}
+	a.updateStats(func(s *Stats) { s.Queued = ""; s.QueuedSince = time.Time{} })
+}
+
+// requeue moves the pending flare of the agent from the slot it was queued in, before the domain bound
+// to the API token changed (e.g. became known once the server was reachable)
+func (a *Agent) requeue(previousKey string) {
+	if err := a.queue.Move(previousKey, a.queueKey()); err != nil {
+		a.log.Error("Unable to persist the flare queue: %v", err)
+	}
+	if entry := a.queue.Get(a.queueKey()); entry != nil {
+		a.updateStats(func(s *Stats) { s.Queued = entry.IP; s.QueuedSince = entry.Queued })
+	}
+}
+
+// showQueued reports the pending flare of the agent, if any
+func (a *Agent) showQueued() {
+	entry := a.queue.Get(a.queueKey())
+	if entry == nil {
+		a.updateStats(func(s *Stats) { s.Queued = ""; s.QueuedSince = time.Time{} })
+		return
+	}
+	a.log.Info("Flare of %s pending since %s", entry.IP, entry.Queued.Format(time.DateTime))
+	a.updateStats(func(s *Stats) { s.Queued = entry.IP; s.QueuedSince = entry.Queued })
+}
+
+// RetryQueued sends the pending flare of the agent, if its next attempt is due. Run calls it between
+// checks, so that a flare that did not reach the server is not delayed until the next check.
+func (a *Agent) RetryQueued(ctx context.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.clock.Now()
+	entry := a.queue.Get(a.queueKey())
+	if entry == nil || now.Before(entry.NextAttempt) || now.Before(a.backoffUntil) || a.authSuspended || a.paused.Load() {
+		return
+	}
+
+	// The token could not be checked at startup: the slot of the agent may change with the domain
+	if a.tokenUnchecked {
+		if !a.checkDeferredToken(ctx) {
+			return
+		}
+		if entry = a.queue.Get(a.queueKey()); entry == nil {
+			return
+		}
+	}
+
+	a.log.Debug("Retrying the flare of %s, queued at %s", entry.IP, entry.Queued.Format(time.TimeOnly))
+	result, err := a.sendFlare(ctx, entry.IP)
+	if err != nil {
+		if ctx.Err() != nil {
+			return // Stopping, the flare stays queued
+		}
+		a.log.Error("Failed to update IP on server: %v", err)
+		if api.IsUnavailable(err) {
+			a.enqueue(entry.IP, err)
+		}
+		if a.flareFallback(ctx, entry.IP, err) {
+			return
+		}
+		a.handleAPIError(err)
+		return
+	}
+
+	a.log.Info("Queued flare of %s delivered after %s", entry.IP, now.Sub(entry.Queued).Round(time.Second))
+	a.flared(entry.IP, result)
+}
@@ -0,0 +1,64 @@
+package client_test
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+func TestQueuedFlareDelivered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, err := client.OpenQueue(path)
+	if err != nil {
+		t.Fatalf("OpenQueue: %v", err)
+	}
+
+	// Queued before a restart, while the address can no longer be detected
+	queued := time.Date(2026, 3, 2, 11, 0, 0, 0, time.UTC)
+	if _, err := q.Put(testDomain, testAddress, queued, queued, errors.New("HTTP 503")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	ta := newTestAgent(t, []testserver.EchoResponse{testserver.EchoStatus(http.StatusInternalServerError)},
+		client.WithQueue(q), client.WithExpectedDomain(testDomain))
+	ta.srv.SetDefault(testserver.Behavior{Status: http.StatusServiceUnavailable})
+	ta.run(t)
+
+	if stats := ta.Stats(); stats.Queued != testAddress || !stats.QueuedSince.Equal(queued) {
+		t.Fatalf("queued = %q since %s, want %s since %s", stats.Queued, stats.QueuedSince, testAddress, queued)
+	}
+
+	// Retried between checks while the server is unavailable
+	ta.clock.Advance(15 * time.Second)
+	waitFor(t, "the retry of the queued flare", func() bool {
+		entry := q.Get(testDomain)
+		return entry != nil && entry.Attempts == 2
+	})
+	if flares := ta.srv.Flares(); len(flares) != 1 || flares[0].Status != http.StatusServiceUnavailable {
+		t.Fatalf("flares = %+v, want one failed with HTTP 503", flares)
+	}
+
+	// Delivered once the server is back
+	ta.srv.SetDefault(testserver.Behavior{})
+	ta.clock.Advance(30 * time.Second)
+	waitFor(t, "the delivery of the queued flare", func() bool { return len(ta.srv.Flares()) == 2 })
+	if flare := ta.srv.Flares()[1]; flare.Status != http.StatusOK || *flare.Flare.IP != testAddress {
+		t.Fatalf("flare = %+v, want %s acknowledged", flare, testAddress)
+	}
+	waitFor(t, "the acknowledgement", func() bool { return ta.Stats().CurrentIP == testAddress })
+
+	if stats := ta.Stats(); stats.Queued != "" || stats.Domain != testDomain {
+		t.Errorf("queued = %q, domain = %q, want none, %s", stats.Queued, stats.Domain, testDomain)
+	}
+	reopened, err := client.OpenQueue(path)
+	if err != nil {
+		t.Fatalf("OpenQueue: %v", err)
+	}
+	if entries := reopened.Entries(); len(entries) != 0 {
+		t.Errorf("queue file holds %+v, want the delivered flare removed", entries)
+	}
+}
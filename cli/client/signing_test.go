@@ -0,0 +1,39 @@
+package client_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/signing"
+)
+
+func TestRequestSigning(t *testing.T) {
+	tests := []struct {
+		name       string
+		sign       bool
+		skew       time.Duration // Offset of the clock of the server
+		wantStatus int
+	}{
+		{name: "signed", sign: true, wantStatus: http.StatusOK},
+		{name: "unsigned", wantStatus: http.StatusUnauthorized},
+		{name: "clock skew", sign: true, skew: signing.DefaultSkew + time.Minute, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := newTestAgent(t, nil, client.WithRequestSigning(tt.sign))
+			verifier := signing.NewVerifier(0)
+			verifier.SetClock(func() time.Time { return ta.clock.Now().Add(tt.skew) })
+			ta.srv.RequireSignatures(verifier)
+
+			if flares := ta.check(); len(flares) != 1 || flares[0].Status != tt.wantStatus {
+				t.Fatalf("flares = %+v, want one answered with HTTP %d", flares, tt.wantStatus)
+			}
+			if healthy, reason := ta.Healthy(); healthy != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("healthy = %v (%s), want %v", healthy, reason, tt.wantStatus == http.StatusOK)
+			}
+		})
+	}
+}
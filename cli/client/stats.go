@@ -0,0 +1,81 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/api"
+	"github.com/qalisa/pierceflare/cli/internal/ip"
+)
+
+// RateLimit is the request budget advertised by the server's rate limiter
+type RateLimit = api.RateLimit
+
+// SourceHealth describes the recent queries of an IP source: success rate, latency, circuit breaker
+type SourceHealth = ip.SourceHealth
+
+// Stats describes the activity of an Agent, for monitoring (metrics, service manager status)
+type Stats struct {
+	Name       string    // Name of the agent
+	Domain     string    // Domain bound to the API token, empty if unknown
+	Running    bool      // Run passed its startup checks and is checking periodically
+	Paused     bool      // Checks are suspended until Resume (see Pause)
+	Healthy    bool      // Whether the last check succeeded
+	Reason     string    // Why the last check failed
+	CurrentIP  string    // Last address acknowledged by the server, empty before the first flare
+	LastChange time.Time // When CurrentIP was acknowledged
+	LastCheck  time.Time // When the address was last checked
+	Heartbeat  time.Time // Last iteration of the loop of Run, zero if not running
+	Checks     uint64    // Number of address checks
+	Flares     uint64    // Number of addresses acknowledged by the server
+	Skipped    uint64    // Number of flares skipped, the DNS record already serving the address (see WithDNSPrecheck)
+	Failures   uint64    // Number of failed checks (detection or flare)
+	Fallback   bool      // The record is updated directly through Cloudflare, the server being unavailable
+	NextCheck  time.Time // When Run checks the address next, zero if not running
+
+	IPv4 string // Last IPv4 address detected, empty if none
+	IPv6 string // Last IPv6 address detected, empty if none
+
+	LastFlare       time.Time    // When an address was last sent to the server, zero if never
+	LastFlareIP     string       // Address sent last
+	LastFlareResult *FlareResult // Acknowledgement of the last flare, nil if it failed
+	LastFlareError  string       // Why the last flare failed, empty if it was acknowledged
+
+	BackoffUntil time.Time      // No request is sent to the server before this time (rate limiting)
+	RateLimit    *RateLimit     // Budget advertised by the server in its last answer, nil if unknown
+	Sources      []SourceHealth // Health of each IP source, in the order they are configured
+
+	Queued      string    // Address whose flare did not reach the server yet, empty if none (see WithQueue)
+	QueuedSince time.Time // When the flare of Queued (or of an address it superseded) was first queued
+
+	PropagationTime   time.Duration // Time CurrentIP took to be served by every resolver, 0 if unknown (see WithPropagationCheck)
+	PropagationFailed bool          // Resolvers did not serve CurrentIP before the end of the verification
+}
+
+// stats is the part of Stats maintained by the agent, guarded separately from the checks
+// so that monitoring is not blocked by a slow check
+type stats struct {
+	mu sync.Mutex
+	Stats
+}
+
+// Stats returns a snapshot of the activity of the agent
+func (a *Agent) Stats() Stats {
+	a.stats.mu.Lock()
+	s := a.stats.Stats
+	a.stats.mu.Unlock()
+
+	s.Name = a.name
+	s.Healthy, s.Reason = a.Healthy()
+	s.Paused = a.paused.Load()
+	s.RateLimit = a.apiClient.RateLimit()
+	s.Sources = a.ipRetriever.Health()
+	return s
+}
+
+// updateStats modifies the stats of the agent
+func (a *Agent) updateStats(fn func(s *Stats)) {
+	a.stats.mu.Lock()
+	defer a.stats.mu.Unlock()
+	fn(&a.stats.Stats)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/config"
+	"github.com/qalisa/pierceflare/cli/internal/systemd"
+)
+
+// newAgents creates one agent per uplink described by the configuration
+// (PIERCEFLARE_UPLINKS entries, or a single one using the global settings), logging to logOutput
+// or to journald when run by systemd. The extra options are given to every agent.
+func newAgents(cfg *config.Config, logOutput io.Writer, extra ...client.Option) ([]*client.Agent, error) {
+	common := []client.Option{
+		client.WithLogLevel(cfg.LogLevel),
+		client.WithLogOutput(logOutput),
+		client.WithLogTimestamp(cfg.LogTimestamp),
+		client.WithSuccessLogPeriod(cfg.SuccessPeriod),
+		client.WithCheckInterval(cfg.CheckInterval),
+		client.WithSchedule(cfg.ScheduleOptions()),
+		client.WithRevalidateInterval(cfg.RevalidateInterval),
+		client.WithDummyUpdates(cfg.DummyUpdates),
+		client.WithRefuseCGNAT(cfg.RefuseCGNAT),
+		client.WithRequestSigning(cfg.SignRequests),
+		client.WithNotifyURL(cfg.NotifyURL),
+		client.WithHedgedDetection(cfg.HedgeDelay),
+	}
+	// A single queue, with a slot per domain, is shared by the uplinks
+	queue, err := client.OpenQueue(cfg.QueueFile)
+	if err != nil {
+		return nil, err
+	}
+	common = append(common, client.WithQueue(queue))
+
+	if cfg.VerifyPropagation {
+		common = append(common, client.WithPropagationCheck(client.PropagationOptions{
+			Resolvers:    cfg.VerifyResolvers,
+			Timeout:      cfg.VerifyTimeout,
+			QueryTimeout: cfg.DetectTimeout,
+		}))
+	}
+	if cfg.DNSPrecheck {
+		common = append(common, client.WithDNSPrecheck(cfg.DNSPrecheckResolver))
+	}
+	if systemd.JournalStream() {
+		common = append(common, client.WithJournald(journalIdentifier))
+	}
+	common = append(common, extra...)
+
+	// The zone is shared, the record is the one of each uplink
+	fallback := func(record string) client.Option {
+		return client.WithCloudflareFallback(client.CloudflareOptions{
+			Token:  cfg.CloudflareToken,
+			ZoneID: cfg.CloudflareZoneID,
+			Record: record,
+			APIURL: cfg.CloudflareAPIURL,
+		}, cfg.FallbackAfter)
+	}
+
+	if len(cfg.Uplinks) == 0 {
+		backends, err := newBackends(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		agent, err := client.NewAgent(append([]client.Option{
+			client.WithAPIKey(cfg.APIKey),
+			client.WithServerURL(cfg.ServerURL),
+			client.WithExpectedDomain(cfg.ExpectedDomain),
+			client.WithAPITransport(cfg.APITransport()),
+			client.WithDetectTransport(cfg.DetectTransport()),
+			client.WithHealthFile(cfg.HealthFile),
+			fallback(cfg.CloudflareRecord),
+			client.WithBackends(backends...),
+		}, common...)...)
+		if err != nil {
+			return nil, err
+		}
+		return []*client.Agent{agent}, nil
+	}
+
+	agents := make([]*client.Agent, 0, len(cfg.Uplinks))
+	for _, link := range cfg.Uplinks {
+		// Each uplink reports its own health, in its own file
+		healthFile := cfg.HealthFile
+		if healthFile != "" {
+			healthFile += "." + link.Name
+		}
+
+		agent, err := client.NewAgent(append([]client.Option{
+			client.WithName(link.Name),
+			client.WithAPIKey(link.APIKey),
+			client.WithServerURL(link.ServerURL),
+			client.WithExpectedDomain(link.ExpectedDomain),
+			client.WithAPITransport(link.Transport(cfg.APITransport())),
+			client.WithDetectTransport(link.Transport(cfg.DetectTransport())),
+			client.WithHealthFile(healthFile),
+			fallback(link.CloudflareRecord),
+		}, common...)...)
+		if err != nil {
+			return nil, fmt.Errorf("uplink %s: %w", link.Name, err)
+		}
+		agents = append(agents, agent)
+	}
+
+	return agents, nil
+}
+
+// newBackends creates the additional backends configured (RFC 2136, dyndns2)
+func newBackends(cfg *config.Config) ([]client.Backend, error) {
+	var backends []client.Backend
+
+	if cfg.RFC2136Server != "" {
+		b, err := client.NewRFC2136Backend(client.RFC2136Options{
+			Server:       cfg.RFC2136Server,
+			Zone:         cfg.RFC2136Zone,
+			Record:       cfg.RFC2136Record,
+			TTL:          cfg.RFC2136TTL,
+			KeyName:      cfg.RFC2136KeyName,
+			KeySecret:    cfg.RFC2136KeySecret,
+			KeyAlgorithm: cfg.RFC2136KeyAlgorithm,
+			Timeout:      cfg.APITimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, b)
+	}
+
+	if cfg.DynDNS2URL != "" {
+		transport := cfg.DetectTransport()
+		transport.Timeout = cfg.APITimeout
+		b, err := client.NewDynDNS2Backend(client.DynDNS2Options{
+			URL:      cfg.DynDNS2URL,
+			Username: cfg.DynDNS2Username,
+			Password: cfg.DynDNS2Password,
+			Hostname: cfg.DynDNS2Hostname,
+		}, transport)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, b)
+	}
+
+	return backends, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Output formats of the one-shot mode and of the detect command
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// Commands of the CLI, given as first argument
+const (
+	commandRun    = ""       // Default: continuous or one-shot mode
+	commandDetect = "detect" // Detect the public IP addresses without flaring
+	commandWhoami = "whoami" // Show the domain the API token is bound to
+	commandStatus = "status" // Show the state of the continuous mode and the flares waiting for the server
+
+	commandTrigger = "trigger" // Make the continuous mode check the address now
+	commandPause   = "pause"   // Suspend the checks of the continuous mode
+	commandResume  = "resume"  // Resume the checks of the continuous mode
+
+	commandInstallService = "install-service" // Write the systemd units of the service
+	commandServeDynDNS    = "serve-dyndns"    // Relay the dyndns2 updates of routers to the server
+)
+
+const usage = `Usage:
+  pierceflare-cli [--force-ping] [--output text|json]
+  pierceflare-cli detect [--output text|json]
+  pierceflare-cli whoami [--output text|json]
+  pierceflare-cli status [--uplink NAME] [--output text|json]
+  pierceflare-cli trigger|pause|resume [--uplink NAME] [--output text|json]
+  pierceflare-cli install-service [--unit-dir DIR] [--env-file FILE] [--metrics-listen ADDRESS] [--force]
+  pierceflare-cli serve-dyndns [--listen ADDRESS]`
+
+// options holds the parsed command line arguments
+type options struct {
+	command   string
+	forcePing bool
+	output    string
+
+	// install-service
+	unitDir       string
+	envFile       string
+	metricsListen string
+	force         bool
+
+	// serve-dyndns
+	listen string
+
+	// status and control commands
+	uplink string
+}
+
+// parseArgs checks that the passed arguments are valid and parses them
+func parseArgs(args []string) (*options, error) {
+	opts := &options{
+		command: commandRun,
+		output:  outputText,
+		unitDir: defaultUnitDir,
+		envFile: defaultEnvFile,
+	}
+
+	// The command, if any, comes first
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case commandDetect, commandWhoami, commandStatus, commandTrigger, commandPause, commandResume,
+			commandInstallService, commandServeDynDNS:
+			opts.command = args[0]
+		default:
+			return nil, fmt.Errorf("unrecognized command '%s'", args[0])
+		}
+		args = args[1:]
+	}
+
+	// Check each argument
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		// Values may also be given as the next argument
+		readValue := func() error {
+			if !hasValue {
+				if i+1 >= len(args) {
+					return fmt.Errorf("missing value for argument '%s'", name)
+				}
+				i++
+				value = args[i]
+			}
+			return nil
+		}
+		installOnly := func() error {
+			if opts.command != commandInstallService {
+				return fmt.Errorf("unrecognized argument '%s'", arg)
+			}
+			return nil
+		}
+
+		switch name {
+		case "--force-ping":
+			if opts.command != commandRun || hasValue {
+				return nil, fmt.Errorf("unrecognized argument '%s'", arg)
+			}
+			opts.forcePing = true
+
+		case "--unit-dir", "--env-file", "--metrics-listen":
+			if err := installOnly(); err != nil {
+				return nil, err
+			}
+			if err := readValue(); err != nil {
+				return nil, err
+			}
+			switch name {
+			case "--unit-dir":
+				opts.unitDir = value
+			case "--env-file":
+				opts.envFile = value
+			default:
+				opts.metricsListen = value
+			}
+
+		case "--listen":
+			if opts.command != commandServeDynDNS {
+				return nil, fmt.Errorf("unrecognized argument '%s'", arg)
+			}
+			if err := readValue(); err != nil {
+				return nil, err
+			}
+			opts.listen = value
+
+		case "--uplink":
+			if !opts.controlsDaemon() {
+				return nil, fmt.Errorf("unrecognized argument '%s'", arg)
+			}
+			if err := readValue(); err != nil {
+				return nil, err
+			}
+			opts.uplink = value
+
+		case "--force":
+			if err := installOnly(); err != nil {
+				return nil, err
+			}
+			if hasValue {
+				return nil, fmt.Errorf("unrecognized argument '%s'", arg)
+			}
+			opts.force = true
+
+		case "--output":
+			if opts.command == commandInstallService || opts.command == commandServeDynDNS {
+				return nil, fmt.Errorf("unrecognized argument '%s'", arg)
+			}
+			if err := readValue(); err != nil {
+				return nil, err
+			}
+			if value != outputText && value != outputJSON {
+				return nil, fmt.Errorf("invalid output format '%s' (valid formats: %s, %s)", value, outputText, outputJSON)
+			}
+			opts.output = value
+
+		default:
+			return nil, fmt.Errorf("unrecognized argument '%s'", arg)
+		}
+	}
+
+	return opts, nil
+}
+
+// controlsDaemon reports whether the command talks to the running continuous mode
+func (o *options) controlsDaemon() bool {
+	switch o.command {
+	case commandStatus, commandTrigger, commandPause, commandResume:
+		return true
+	default:
+		return false
+	}
+}
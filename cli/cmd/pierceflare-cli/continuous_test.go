@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/clock"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+func TestContinuousServerUnavailable(t *testing.T) {
+	const changed = "93.184.216.35"
+
+	up := testserver.New(map[string]string{testToken: testDomain})
+	defer up.Close()
+	down := testserver.New(map[string]string{testToken: "other.example.com"})
+	defer down.Close()
+	down.SetDefault(testserver.Behavior{Status: http.StatusServiceUnavailable})
+
+	fake := clock.NewFake(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC))
+	started := make(chan struct{}, 2)
+	newAgent := func(srv *testserver.Server, responses ...testserver.EchoResponse) *client.Agent {
+		echo := testserver.NewEcho(responses...)
+		t.Cleanup(echo.Close)
+		agent, err := client.NewAgent(
+			client.WithAPIKey(testToken),
+			client.WithServerURL(srv.URL),
+			client.WithSources(echo.Source()),
+			client.WithLogOutput(io.Discard),
+			client.WithClock(fake),
+			client.OnStart(func() { started <- struct{}{} }),
+		)
+		if err != nil {
+			t.Fatalf("NewAgent: %v", err)
+		}
+		return agent
+	}
+	flaring := newAgent(up, testserver.EchoIP(testAddress), testserver.EchoIP(changed))
+	stalled := newAgent(down, testserver.EchoIP(testAddress))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runContinuous(ctx, logger.New(false, logger.LogLevelError, 1), []*client.Agent{flaring, stalled})
+	}()
+	for range 2 {
+		select {
+		case <-started:
+		case err := <-done:
+			t.Fatalf("runContinuous returned at startup: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("agents not started")
+		}
+	}
+
+	if healthy, _ := stalled.Healthy(); healthy {
+		t.Error("agent healthy while its server is unavailable")
+	}
+
+	// The other uplink keeps flaring
+	if err := flaring.Trigger(ctx); err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	flares := up.Flares()
+	if len(flares) != 2 || *flares[0].Flare.IP != testAddress || *flares[1].Flare.IP != changed {
+		t.Fatalf("flares = %+v, want %s then %s", flares, testAddress, changed)
+	}
+
+	// The token is checked again once the server is back, then the address is flared
+	down.SetDefault(testserver.Behavior{})
+	if err := stalled.Trigger(ctx); err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	if flares := down.Flares(); len(flares) == 0 || flares[len(flares)-1].Status != http.StatusOK {
+		t.Fatalf("flares = %+v, want the last one acknowledged once the server is back", flares)
+	}
+	if stats := stalled.Stats(); stats.Domain != "other.example.com" || stats.CurrentIP != testAddress {
+		t.Errorf("domain = %q, current IP = %s, want other.example.com, %s", stats.Domain, stats.CurrentIP, testAddress)
+	}
+	if healthy, reason := stalled.Healthy(); !healthy {
+		t.Errorf("agent unhealthy once its server is back: %s", reason)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("runContinuous = %v, want nil on cancel", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runContinuous did not return on cancel")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/config"
+	"github.com/qalisa/pierceflare/cli/internal/control"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+)
+
+// controlTimeout bounds the calls to the control API, long enough for a triggered check to complete
+const controlTimeout = 2 * time.Minute
+
+// serveControl serves the control API of the agents on listener until ctx is canceled
+func serveControl(ctx context.Context, log *logger.Logger, listener net.Listener, agents []*client.Agent) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+control.RouteStatus, func(w http.ResponseWriter, r *http.Request) {
+		selected, ok := selectAgents(w, r, agents)
+		if ok {
+			control.WriteJSON(w, http.StatusOK, uplinkStatuses(selected))
+		}
+	})
+	mux.HandleFunc("POST "+control.RouteTrigger, func(w http.ResponseWriter, r *http.Request) {
+		selected, ok := selectAgents(w, r, agents)
+		if !ok {
+			return
+		}
+		if err := triggerAgents(r.Context(), selected); err != nil {
+			control.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		control.WriteJSON(w, http.StatusOK, uplinkStatuses(selected))
+	})
+	for route, apply := range map[string]func(*client.Agent) bool{
+		control.RoutePause:  (*client.Agent).Pause,
+		control.RouteResume: (*client.Agent).Resume,
+	} {
+		mux.HandleFunc("POST "+route, func(w http.ResponseWriter, r *http.Request) {
+			selected, ok := selectAgents(w, r, agents)
+			if !ok {
+				return
+			}
+			for _, agent := range selected {
+				apply(agent)
+			}
+			control.WriteJSON(w, http.StatusOK, uplinkStatuses(selected))
+		})
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Debug("Control API listening on %s", listener.Addr())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("Control API stopped: %v", err)
+	}
+}
+
+// selectAgents returns the agents a request applies to: the uplink it names, every agent otherwise.
+// Unknown uplinks are answered with 404.
+func selectAgents(w http.ResponseWriter, r *http.Request, agents []*client.Agent) ([]*client.Agent, bool) {
+	name := r.URL.Query().Get(control.UplinkParam)
+	if name == "" {
+		return agents, true
+	}
+	for _, agent := range agents {
+		if strings.EqualFold(agent.Name(), name) || strings.EqualFold(agent.BoundDomain(), name) {
+			return []*client.Agent{agent}, true
+		}
+	}
+	control.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown uplink '%s'", name))
+	return nil, false
+}
+
+// triggerAgents checks the address of the agents now, in parallel, returning once every check is done
+func triggerAgents(ctx context.Context, agents []*client.Agent) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(agents))
+	for i, agent := range agents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := agent.Trigger(ctx); err != nil && len(agents) > 1 {
+				errs[i] = fmt.Errorf("%s: %w", uplinkLabel(agent.Stats()), err)
+			} else {
+				errs[i] = err
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// runControlCommand sends a command (trigger, pause, resume) to the running continuous mode
+// and prints the resulting state of the uplinks
+func runControlCommand(opts *options) error {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		return &usageError{err}
+	}
+	if cfg.ControlSocket == "" {
+		err := errors.New("the control socket is disabled (PIERCEFLARE_CONTROL_SOCKET=off)")
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		return &usageError{err}
+	}
+
+	route := map[string]string{
+		commandTrigger: control.RouteTrigger,
+		commandPause:   control.RoutePause,
+		commandResume:  control.RouteResume,
+	}[opts.command]
+
+	ctx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+	defer cancel()
+
+	var uplinks []uplinkStatus
+	err = control.NewClient(cfg.ControlSocket, controlTimeout).Call(ctx, http.MethodPost, route, opts.uplink, &uplinks)
+	if err != nil {
+		err = controlError(cfg.ControlSocket, err)
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		return err
+	}
+
+	if opts.output == outputJSON {
+		return writeJSON(uplinks)
+	}
+	for _, u := range uplinks {
+		fmt.Printf("%s: %s\n", u.label(), u.State)
+	}
+	return nil
+}
+
+// controlError explains a failed call to the control API
+func controlError(socket string, err error) error {
+	var apiErr *control.Error
+	switch {
+	case control.IsNotRunning(err):
+		return fmt.Errorf("pierceflare-cli is not running in continuous mode (no control socket at %s)", socket)
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		return &usageError{err}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,71 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/control"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+func TestControlAPI(t *testing.T) {
+	srv := testserver.New(map[string]string{testToken: testDomain})
+	defer srv.Close()
+	agent := newTestAgent(t, srv)
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := control.Listen(path)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go serveControl(ctx, logger.New(false, logger.LogLevelError, 1), listener, []*client.Agent{agent})
+
+	tests := []struct {
+		name       string
+		method     string
+		route      string
+		uplink     string
+		wantStatus int
+	}{
+		{name: "status", method: http.MethodGet, route: control.RouteStatus, wantStatus: http.StatusOK},
+		{name: "command by GET", method: http.MethodGet, route: control.RoutePause, wantStatus: http.StatusMethodNotAllowed},
+		{name: "status by POST", method: http.MethodPost, route: control.RouteStatus, wantStatus: http.StatusMethodNotAllowed},
+		{name: "unknown route", method: http.MethodGet, route: "/v1/unknown", wantStatus: http.StatusNotFound},
+		{name: "unversioned route", method: http.MethodGet, route: "/status", wantStatus: http.StatusNotFound},
+		{name: "unknown uplink", method: http.MethodPost, route: control.RoutePause, uplink: "backup", wantStatus: http.StatusNotFound},
+		{name: "trigger while not running", method: http.MethodPost, route: control.RouteTrigger, wantStatus: http.StatusConflict},
+		{name: "pause", method: http.MethodPost, route: control.RoutePause, wantStatus: http.StatusOK},
+	}
+
+	c := control.NewClient(path, 5*time.Second)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var statuses []uplinkStatus
+			err := c.Call(context.Background(), tt.method, tt.route, tt.uplink, &statuses)
+
+			var controlErr *control.Error
+			switch {
+			case tt.wantStatus == http.StatusOK && err != nil:
+				t.Fatalf("Call = %v, want success", err)
+			case tt.wantStatus == http.StatusOK && len(statuses) != 1:
+				t.Fatalf("statuses = %+v, want the one of the agent", statuses)
+			case tt.wantStatus != http.StatusOK && (!errors.As(err, &controlErr) || controlErr.StatusCode != tt.wantStatus):
+				t.Fatalf("Call = %v, want refused with HTTP %d", err, tt.wantStatus)
+			}
+		})
+	}
+
+	if !agent.Paused() {
+		t.Error("agent not paused by the pause command")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/config"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+)
+
+// runDetect detects the public IPv4 and IPv6 addresses of every uplink without contacting the PierceFlare server
+func runDetect(opts *options) error {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		return &usageError{err}
+	}
+
+	// Logs never mix with the command output
+	log := logger.New(cfg.LogTimestamp, cfg.LogLevel, cfg.SuccessPeriod)
+	log.SetOutput(os.Stderr)
+
+	agents, err := newAgents(cfg, os.Stderr)
+	if err != nil {
+		log.Error("Invalid configuration: %v", err)
+		return &usageError{err}
+	}
+
+	// Uplinks are detected one after the other, both families concurrently
+	err = nil
+	reports := make([]*report, 0, len(agents))
+	for _, agent := range agents {
+		rep, detectErr := detectUplink(agent)
+		reports = append(reports, rep)
+		if detectErr != nil && err == nil {
+			err = detectErr
+		}
+	}
+
+	if opts.output == outputJSON {
+		if err := writeReports(os.Stdout, reports); err != nil {
+			log.Error("Error writing report: %v", err)
+		}
+		return err
+	}
+
+	for _, rep := range reports {
+		prefix := ""
+		if rep.Uplink != "" {
+			prefix = rep.Uplink + " "
+		}
+
+		for _, family := range []client.Family{client.FamilyIPv4, client.FamilyIPv6} {
+			d := rep.Detected[family.String()]
+			if d.Error != "" {
+				fmt.Printf("%s%s: not detected (%s)\n", prefix, family, d.Error)
+			} else {
+				fmt.Printf("%s%s: %s (source: %s, %dms)%s\n", prefix, family, d.Address, d.Source, d.DurationMs, describeTopology(d))
+			}
+		}
+	}
+
+	return err
+}
+
+// detectUplink detects the public IPv4 and IPv6 addresses of an uplink.
+// It fails with client.ErrNoIPFound only if no address of any family could be found.
+func detectUplink(agent *client.Agent) (*report, error) {
+	rep := newReport()
+	rep.Uplink = agent.Name()
+
+	families := []client.Family{client.FamilyIPv4, client.FamilyIPv6}
+	results := make([]*client.Detection, len(families))
+	errs := make([]error, len(families))
+	durations := make([]time.Duration, len(families))
+
+	var wg sync.WaitGroup
+	for i, family := range families {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			results[i], errs[i] = agent.Detect(context.Background(), family)
+			durations[i] = time.Since(start)
+		}()
+	}
+	wg.Wait()
+
+	var err error = client.ErrNoIPFound
+	for i, family := range families {
+		rep.addDetection(family, results[i], errs[i], durations[i])
+		if errs[i] == nil {
+			err = nil
+		}
+	}
+
+	rep.finish(err)
+	return rep, err
+}
+
+// describeTopology explains the network topology of a detection in human-readable output
+func describeTopology(d *detection) string {
+	var local []string
+	for _, l := range d.Local {
+		local = append(local, fmt.Sprintf("%s %s", l.Class, l.Address))
+	}
+
+	switch d.Topology {
+	case client.TopologyDirect:
+		return ", assigned to this host"
+	case client.TopologyNAT:
+		return fmt.Sprintf(", behind NAT (local: %s)", strings.Join(local, ", "))
+	case client.TopologyCGNAT:
+		return fmt.Sprintf(", WARNING: behind carrier-grade NAT, the address does not reach this host (local: %s)", strings.Join(local, ", "))
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/config"
+)
+
+// Exit codes of the CLI, documented in README.md so that wrappers (cron, systemd) can decide whether to retry
+const (
+	ExitOK             = 0  // Success
+	ExitFailure        = 1  // Unexpected failure
+	ExitUsage          = 2  // Invalid arguments or configuration, do not retry
+	ExitNoIPFound      = 3  // No IP address could be detected, retry later
+	ExitAuth           = 4  // API token missing, malformed or revoked, do not retry
+	ExitRateLimited    = 5  // Rate limited by the server, retry after a delay
+	ExitServerError    = 6  // Server answered with an error, retry later
+	ExitNetworkError   = 7  // Server could not be reached, retry later
+	ExitUnresolvable   = 8  // Server could not resolve the IP of the emitter, check network setup
+	ExitBehindCGNAT    = 9  // Host behind carrier-grade NAT and PIERCEFLARE_REFUSE_CGNAT set, do not retry
+	ExitDomainMismatch = 10 // API token bound to another domain than PIERCEFLARE_EXPECTED_DOMAIN, do not retry
+)
+
+// usageError marks errors caused by the arguments or the configuration
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+// exitCode maps an error to the exit code of the CLI
+func exitCode(err error) int {
+	var usageErr *usageError
+
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, config.ErrMissingAPIKey):
+		return ExitAuth
+	case errors.As(err, &usageErr), errors.Is(err, client.ErrNotConfigured):
+		return ExitUsage
+	case errors.Is(err, client.ErrDomainMismatch):
+		return ExitDomainMismatch
+	case errors.Is(err, client.ErrBehindCGNAT):
+		return ExitBehindCGNAT
+	case errors.Is(err, client.ErrNoIPFound):
+		return ExitNoIPFound
+	case client.IsAuthError(err):
+		return ExitAuth
+	case errors.Is(err, client.ErrRateLimited):
+		return ExitRateLimited
+	case errors.Is(err, client.ErrUnresolvable):
+		return ExitUnresolvable
+	case errors.Is(err, client.ErrServer):
+		return ExitServerError
+	case errors.Is(err, client.ErrNetwork):
+		return ExitNetworkError
+	default:
+		return ExitFailure
+	}
+}
+
+// errorCodes are the stable error codes of the machine-readable output, by exit code
+var errorCodes = map[int]string{
+	ExitFailure:        "FAILURE",
+	ExitUsage:          "USAGE",
+	ExitNoIPFound:      "NO_IP_FOUND",
+	ExitAuth:           "AUTH",
+	ExitRateLimited:    "RATE_LIMITED",
+	ExitServerError:    "SERVER_ERROR",
+	ExitNetworkError:   "NETWORK_ERROR",
+	ExitUnresolvable:   "UNRESOLVABLE",
+	ExitBehindCGNAT:    "BEHIND_CGNAT",
+	ExitDomainMismatch: "DOMAIN_MISMATCH",
+}
+
+// errorCode maps an error to its code in machine-readable output
+func errorCode(err error) string {
+	return errorCodes[exitCode(err)]
+}
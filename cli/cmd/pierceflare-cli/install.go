@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Defaults of install-service
+const (
+	defaultUnitDir = "/etc/systemd/system"
+	defaultEnvFile = "/etc/pierceflare/pierceflare.env"
+)
+
+// Names of the units written by install-service
+const (
+	serviceUnit = "pierceflare.service"
+	socketUnit  = "pierceflare.socket"
+)
+
+// runInstallService writes the systemd units running the continuous mode as a hardened service.
+// It does not run systemctl, the next steps are printed instead.
+func runInstallService(opts *options) error {
+	executable, err := os.Executable()
+	if err == nil {
+		executable, err = filepath.EvalSymlinks(executable)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to locate the executable: %w", err)
+	}
+
+	units := map[string]string{
+		serviceUnit: serviceUnitFile(executable, opts.envFile, opts.metricsListen != ""),
+	}
+	if opts.metricsListen != "" {
+		units[socketUnit] = socketUnitFile(opts.metricsListen)
+	}
+
+	// Check everything before writing anything
+	for name := range units {
+		path := filepath.Join(opts.unitDir, name)
+		if _, err := os.Stat(path); err == nil && !opts.force {
+			return &usageError{fmt.Errorf("%s already exists, use --force to replace it", path)}
+		}
+	}
+
+	for _, name := range []string{serviceUnit, socketUnit} {
+		content, ok := units[name]
+		if !ok {
+			continue
+		}
+		path := filepath.Join(opts.unitDir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return fmt.Errorf("unable to write %s: %w", path, err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+
+	if _, err := os.Stat(opts.envFile); errors.Is(err, fs.ErrNotExist) {
+		fmt.Printf("\n%s does not exist yet: create it with the PIERCEFLARE_* variables (see .env.local), readable by root only.\n", opts.envFile)
+	}
+
+	enable := serviceUnit
+	if opts.metricsListen != "" {
+		enable += " " + socketUnit
+	}
+	fmt.Printf("\nThen start the service:\n  systemctl daemon-reload\n  systemctl enable --now %s\n  journalctl -u %s -f\n", enable, serviceUnit)
+	return nil
+}
+
+// serviceUnitFile returns the service unit running the continuous mode
+func serviceUnitFile(executable, envFile string, withSocket bool) string {
+	var b strings.Builder
+	b.WriteString(`# Written by pierceflare-cli install-service
+[Unit]
+Description=PierceFlare dynamic DNS client
+Documentation=https://github.com/Qalisa/pierceflare
+Wants=network-online.target
+After=network-online.target
+`)
+	if withSocket {
+		fmt.Fprintf(&b, "Requires=%s\nAfter=%s\n", socketUnit, socketUnit)
+	}
+
+	fmt.Fprintf(&b, `
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart=%s
+EnvironmentFile=%s
+Restart=on-failure
+RestartSec=30
+# Invalid configuration, refused token, carrier-grade NAT refused or unexpected domain:
+# restarting does not help, see the exit codes in the README
+RestartPreventExitStatus=%d %d %d %d
+# The agents report their heartbeat at least every 30 seconds
+WatchdogSec=120
+
+# Health file, if any, must be in /run/pierceflare (PIERCEFLARE_HEALTH_FILE),
+# where the control socket of the status, trigger, pause and resume commands is
+DynamicUser=yes
+RuntimeDirectory=pierceflare
+# Flares waiting for the server survive restarts (overridden by the environment file, if set there)
+StateDirectory=pierceflare
+Environment=PIERCEFLARE_QUEUE_FILE=%%S/pierceflare/queue.json
+
+# Hardening
+NoNewPrivileges=yes
+ProtectSystem=strict
+ProtectHome=yes
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+ProtectClock=yes
+ProtectHostname=yes
+ProtectProc=invisible
+RestrictAddressFamilies=AF_INET AF_INET6 AF_UNIX AF_NETLINK
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+SystemCallFilter=@system-service
+UMask=0077
+# Binding to an interface (PIERCEFLARE_INTERFACE) requires CAP_NET_RAW:
+# set both lines to CAP_NET_RAW in that case
+CapabilityBoundingSet=
+AmbientCapabilities=
+
+[Install]
+WantedBy=multi-user.target
+`, quoteUnitValue(executable), envFile, ExitUsage, ExitAuth, ExitBehindCGNAT, ExitDomainMismatch)
+
+	return b.String()
+}
+
+// socketUnitFile returns the socket unit passing the metrics listener to the service
+func socketUnitFile(listen string) string {
+	return fmt.Sprintf(`# Written by pierceflare-cli install-service
+[Unit]
+Description=PierceFlare metrics socket
+
+[Socket]
+ListenStream=%s
+FileDescriptorName=metrics
+Service=%s
+
+[Install]
+WantedBy=sockets.target
+`, listen, serviceUnit)
+}
+
+// quoteUnitValue quotes a path containing spaces for ExecStart=
+func quoteUnitValue(value string) string {
+	if !strings.ContainsAny(value, " \t") {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
@@ -1,186 +1,268 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/qalisa/pierceflare/cli/internal/api"
+	"github.com/qalisa/pierceflare/cli/client"
 	"github.com/qalisa/pierceflare/cli/internal/config"
-	"github.com/qalisa/pierceflare/cli/internal/ip"
+	"github.com/qalisa/pierceflare/cli/internal/control"
 	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/systemd"
 )
 
-// validateArgs checks that the passed arguments are valid
-func validateArgs() {
-	if len(os.Args) > 1 {
-		validArgs := map[string]bool{
-			"--force-ping": true,
-		}
+func main() {
+	// Validate arguments
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(ExitUsage)
+	}
 
-		// Check each argument
-		for i := 1; i < len(os.Args); i++ {
-			if !validArgs[os.Args[i]] {
-				fmt.Printf("[PierceFlare CLI] - Error: Unrecognized argument '%s'\n", os.Args[i])
-				fmt.Println("Valid arguments: --force-ping")
-				os.Exit(1)
-			}
+	// Commands not talking to the server only need the local configuration
+	if opts.command == commandDetect {
+		os.Exit(exitCode(runDetect(opts)))
+	}
+
+	if opts.command == commandWhoami {
+		os.Exit(exitCode(runWhoami(opts)))
+	}
+
+	if opts.command == commandStatus {
+		os.Exit(exitCode(runStatus(opts)))
+	}
+
+	if opts.controlsDaemon() {
+		os.Exit(exitCode(runControlCommand(opts)))
+	}
+
+	if opts.command == commandInstallService {
+		err := runInstallService(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
 		}
+		os.Exit(exitCode(err))
 	}
-}
 
-func main() {
-	// Validate arguments
-	validateArgs()
+	if opts.command == commandServeDynDNS {
+		os.Exit(exitCode(runServeDynDNS(opts)))
+	}
 
 	// Initialize configuration
 	cfg, err := config.New()
 	if err != nil {
-		fmt.Printf("[PierceFlare CLI] - Error: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		os.Exit(exitCode(&usageError{err}))
 	}
 
-	// Initialize logger
+	oneShot := cfg.OneShotMode || opts.forcePing
+	if !oneShot && opts.output != outputText {
+		fmt.Fprintln(os.Stderr, "[PierceFlare CLI] - Error: --output is only supported in one-shot mode (--force-ping)")
+		os.Exit(ExitUsage)
+	}
+
+	// Initialize logger, keeping stdout for the machine-readable output
+	logOutput := io.Writer(os.Stdout)
+	if opts.output == outputJSON {
+		logOutput = os.Stderr
+	}
 	log := logger.New(cfg.LogTimestamp, cfg.LogLevel, cfg.SuccessPeriod)
+	log.SetOutput(logOutput)
+	if systemd.JournalStream() {
+		if journal, err := systemd.NewJournal(journalIdentifier); err == nil {
+			log = logger.NewWithSink(cfg.LogLevel, cfg.SuccessPeriod, journal)
+		}
+	}
 
 	// In verbose mode (info or higher), display startup information
 	log.Info("Client starting...")
 	log.Debug("Server URL: %s", cfg.ServerURL)
+	for _, link := range cfg.Uplinks {
+		log.Debug("Uplink %s: server=%s, address=%s, interface=%s", link.Name, link.ServerURL, link.SourceAddress, link.Interface)
+	}
 	log.Debug("Check interval: %s", cfg.CheckInterval)
 	log.Debug("Verbosity level: %d", cfg.LogLevel)
 	log.Debug("Success log period: %d executions", cfg.SuccessPeriod)
+	log.Debug("Token revalidation interval: %s", cfg.RevalidateInterval)
+	log.Debug("Timeouts: API calls %s, IP detection %s", cfg.APITimeout, cfg.DetectTimeout)
+	if cfg.HedgeDelay > 0 {
+		log.Debug("IP sources hedged after %s", cfg.HedgeDelay)
+	}
+	if cfg.Proxy != "" {
+		log.Debug("Proxy: %s", cfg.Proxy)
+	}
+	if cfg.SourceAddress != "" || cfg.Interface != "" {
+		log.Debug("Outgoing connections bound to: address=%s, interface=%s", cfg.SourceAddress, cfg.Interface)
+	}
+
+	if cfg.SignRequests {
+		log.Debug("Request signing enabled")
+	}
 
 	// Display a message if dummy updates mode is enabled
 	if cfg.DummyUpdates {
 		log.Info("Dummy updates mode (PIERCEFLARE_DUMMY_UPDATES) enabled - Updates will be sent to server with no intent to propagate to Cloudflare's API")
 	}
 
-	// Initialize API client
-	apiClient := api.NewClient(cfg.APIKey, cfg.ServerURL, log)
-
-	// Check token validity
-	if err := apiClient.CheckTokenValidity(); err != nil {
-		log.Error("Token validation error: %v", err)
-		os.Exit(1)
-	}
-
-	log.Debug("API token valid")
-
-	// Initialize IP retriever
-	ipRetriever := ip.NewRetriever(log)
-
-	// Execution mode
-	if cfg.OneShotMode || len(os.Args) > 1 && os.Args[1] == "--force-ping" {
-		runOneShot(log, apiClient, ipRetriever)
-	} else {
-		runContinuous(log, apiClient, ipRetriever, cfg.CheckInterval)
+	// In continuous mode, the agents are supervised by systemd when run as a service
+	var svc *service
+	var extra []client.Option
+	if !oneShot {
+		svc = newService(log)
+		extra = svc.options()
 	}
-}
-
-// runOneShot executes a single IP check and update
-func runOneShot(log *logger.Logger, apiClient *api.Client, ipRetriever *ip.Retriever) {
-	log.Info("Running in one-shot mode - sending immediate ping")
 
-	currentIP, err := ipRetriever.GetCurrentIP()
+	// Initialize one agent per uplink
+	agents, err := newAgents(cfg, logOutput, extra...)
 	if err != nil {
-		log.Error("Error retrieving IP address: %v", err)
-		os.Exit(1)
+		log.Error("Invalid configuration: %v", err)
+		os.Exit(ExitUsage)
 	}
 
-	log.Debug("Current IP address: %s", currentIP)
-
-	// In force-ping mode, never send a dummy request (always a real update)
-	if err := apiClient.SendIPUpdate(currentIP, false); err != nil {
-		log.Error("Error sending IP update: %v", err)
-		os.Exit(1)
+	// Execution mode
+	if oneShot {
+		os.Exit(exitCode(runOneShotAll(log, agents, opts.output == outputJSON)))
 	}
 
-	log.Info("IP update successful")
-}
-
-// runContinuous executes continuous monitoring with periodic updates
-func runContinuous(log *logger.Logger, apiClient *api.Client, ipRetriever *ip.Retriever, interval time.Duration) {
-	log.Info("Running in continuous mode")
-	log.Debug("Interval between checks: %s", interval)
-
 	// Signal handling for graceful termination
+	ctx, cancel := context.WithCancel(context.Background())
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		log.Info("Signal received: %v, shutting down...", sig)
+		systemd.Notify(systemd.Stopping)
+		cancel()
+	}()
+
+	// Metrics, on a socket passed by systemd or on PIERCEFLARE_METRICS_ADDR
+	listener, err := socketListener("metrics", cfg.MetricsAddr)
+	if err != nil {
+		log.Error("Unable to serve metrics: %v", err)
+		os.Exit(ExitUsage)
+	}
+	if listener != nil {
+		go serveMetrics(ctx, log, listener, agents)
+	}
 
-	// Channel for periodic checks
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	// Last sent IP
-	var lastSentIP string
-
-	// Initial check
-	processIPCheck(log, apiClient, ipRetriever, &lastSentIP)
-
-	// Main loop
-	for {
-		select {
-		case <-ticker.C:
-			// Periodic check
-			processIPCheck(log, apiClient, ipRetriever, &lastSentIP)
-		case sig := <-sigChan:
-			// Graceful termination
-			log.Info("Signal received: %v, shutting down...", sig)
-			return
+	// Control API, for the status, trigger, pause and resume commands
+	if cfg.ControlSocket != "" {
+		if listener, err := control.Listen(cfg.ControlSocket); err != nil {
+			log.Error("Control socket unavailable: %v", err)
+		} else {
+			go serveControl(ctx, log, listener, agents)
 		}
 	}
+
+	go svc.run(ctx, agents)
+
+	os.Exit(exitCode(runContinuous(ctx, log, agents)))
 }
 
-// processIPCheck checks the current IP and sends it if it has changed or if dummy updates are enabled
-func processIPCheck(log *logger.Logger, apiClient *api.Client, ipRetriever *ip.Retriever, lastSentIP *string) {
-	currentIP, err := ipRetriever.GetCurrentIP()
-	if err != nil {
-		log.Error("Error retrieving IP address: %v", err)
-		return
-	}
+// runOneShotAll runs the one-shot mode on every agent, printing the JSON report if requested.
+// It returns the first failure.
+func runOneShotAll(log *logger.Logger, agents []*client.Agent, jsonOutput bool) error {
+	log.Info("Running in one-shot mode - sending immediate ping")
 
-	log.Debug("IP check: current=%s, last=%s", currentIP, *lastSentIP)
+	var firstErr error
+	reports := make([]*report, 0, len(agents))
 
-	// Retrieve configuration to check DummyUpdates option
-	cfg, _ := config.New()
+	for _, agent := range agents {
+		rep := newReport()
+		rep.Uplink = agent.Name()
 
-	// If DummyUpdates is enabled, always send a dummy update
-	if cfg.DummyUpdates {
-		log.Info("Sending a test update (PIERCEFLARE_DUMMY_UPDATES mode enabled)")
+		err := runOneShot(agent, rep, jsonOutput)
+		rep.finish(err)
+		reports = append(reports, rep)
 
-		// Send a dummy (test) update
-		if err := apiClient.SendIPUpdate(currentIP, true); err != nil {
-			log.Error("Failed to send test update to server: %v", err)
-			return
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
+	}
 
-		log.Info("Test update successful")
-		return
+	if jsonOutput {
+		if err := writeReports(os.Stdout, reports); err != nil {
+			log.Error("Error writing report: %v", err)
+		}
 	}
 
-	// Check if the IP has changed
-	ipChanged := currentIP != *lastSentIP
+	return firstErr
+}
 
-	if ipChanged {
-		if *lastSentIP != "" {
-			log.Info("IP address changed: %s -> %s", *lastSentIP, currentIP)
-		} else {
-			log.Info("Initial IP detected: %s", currentIP)
+// runOneShot executes a single IP check and update, filling rep along the way.
+// When detectAll is set, the address of the other family is detected too, for reporting only.
+// The returned error is mapped to the exit code of the CLI by exitCode.
+func runOneShot(agent *client.Agent, rep *report, detectAll bool) error {
+	flare, err := agent.FlareOnce(context.Background())
+
+	rep.Domain = flare.Domain
+	rep.setCompatibility(flare.Compatibility)
+	if flare.Detection != nil {
+		rep.addDetection(client.FamilyAny, flare.Detection, nil, flare.DetectDuration)
+	} else if errors.Is(err, client.ErrNoIPFound) {
+		rep.addDetection(client.FamilyAny, nil, err, flare.DetectDuration)
+	}
+	if flare.Result != nil {
+		rep.Flare = &flareReport{
+			IP:         flare.Detection.Address,
+			Op:         flare.Result.Op,
+			ResolvedIP: flare.Result.ResolvedIP,
+			DurationMs: flare.FlareDuration.Milliseconds(),
+
+			Propagation: newPropagationReport(flare.Propagation, flare.PropagationErr),
 		}
+	}
 
-		// Send a real (not dummy) update
-		if err := apiClient.SendIPUpdate(currentIP, false); err != nil {
-			log.Error("Failed to update IP on server: %v", err)
-			return
+	for _, result := range flare.Backends {
+		backend := backendReport{Name: result.Name}
+		if result.Err != nil {
+			backend.Error = result.Err.Error()
 		}
+		rep.Backends = append(rep.Backends, backend)
+	}
 
-		*lastSentIP = currentIP
-		log.Info("IP update successful")
-	} else {
-		// Periodic log to indicate everything is working normally
-		log.LogSuccess("IP unchanged (%s) - Connection with PierceFlare server maintained", currentIP)
-		log.Debug("IP address unchanged (%s). No update needed.", currentIP)
+	if detectAll && flare.Detection != nil {
+		other := client.FamilyIPv6
+		if flare.Detection.Family == client.FamilyIPv6 {
+			other = client.FamilyIPv4
+		}
+		start := time.Now()
+		detection, detectErr := agent.Detect(context.Background(), other)
+		rep.addDetection(other, detection, detectErr, time.Since(start))
 	}
+
+	return err
+}
+
+// runContinuous runs every agent until ctx is canceled. It only returns an error if an agent could
+// not start (token refused, or bound to another domain), in which case the other agents are stopped.
+// An agent whose server is unavailable at startup keeps running, without stopping the others.
+func runContinuous(ctx context.Context, log *logger.Logger, agents []*client.Agent) error {
+	log.Info("Running in continuous mode")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(agents))
+	for i, agent := range agents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = agent.Run(ctx); errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
 }
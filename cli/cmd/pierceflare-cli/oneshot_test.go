@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+const (
+	testToken   = "test-token"
+	testDomain  = "home.example.com"
+	testAddress = "93.184.216.34"
+)
+
+// newTestAgent creates an agent talking to the fake server, detecting testAddress
+func newTestAgent(t *testing.T, srv *testserver.Server) *client.Agent {
+	t.Helper()
+
+	echo := testserver.NewEcho(testserver.EchoIP(testAddress))
+	t.Cleanup(echo.Close)
+
+	agent, err := client.NewAgent(
+		client.WithAPIKey(testToken),
+		client.WithServerURL(srv.URL),
+		client.WithSources(echo.Source()),
+		client.WithLogOutput(io.Discard),
+	)
+	if err != nil {
+		t.Fatalf("NewAgent: %v", err)
+	}
+	return agent
+}
+
+func TestOneShot(t *testing.T) {
+	tests := []struct {
+		name       string
+		behavior   testserver.Behavior
+		revoke     bool
+		wantExit   int
+		wantCode   string
+		wantStatus int
+		wantFlares int
+		resolvedIP string // Expected resolvedIp of the report, when the flare succeeds
+	}{
+		{
+			name:       "success",
+			wantExit:   ExitOK,
+			wantFlares: 1,
+			resolvedIP: testAddress,
+		},
+		{
+			name:       "resolved IP override",
+			behavior:   testserver.Behavior{Route: testserver.RouteFlare, ResolvedIP: "203.0.113.50"},
+			wantExit:   ExitOK,
+			wantFlares: 1,
+			resolvedIP: "203.0.113.50",
+		},
+		{
+			name:       "revoked token",
+			revoke:     true,
+			wantExit:   ExitAuth,
+			wantCode:   "AUTH",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "rate limited",
+			behavior:   testserver.Behavior{Route: testserver.RouteFlare, RateLimited: true, RateLimitReset: 30 * time.Second},
+			wantExit:   ExitRateLimited,
+			wantCode:   "RATE_LIMITED",
+			wantStatus: http.StatusTooManyRequests,
+			wantFlares: 1,
+		},
+		{
+			name:       "unresolvable",
+			behavior:   testserver.Behavior{Route: testserver.RouteFlare, Unresolvable: true},
+			wantExit:   ExitUnresolvable,
+			wantCode:   "UNRESOLVABLE",
+			wantStatus: http.StatusInternalServerError,
+			wantFlares: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testserver.New(map[string]string{testToken: testDomain})
+			defer srv.Close()
+			if tt.revoke {
+				srv.RevokeToken(testToken)
+			}
+			srv.Script(tt.behavior)
+
+			rep := newReport()
+			err := runOneShot(newTestAgent(t, srv), rep, false)
+			rep.finish(err)
+
+			if got := exitCode(err); got != tt.wantExit {
+				t.Fatalf("exit code = %d, want %d (err: %v)", got, tt.wantExit, err)
+			}
+			if got := len(srv.Flares()); got != tt.wantFlares {
+				t.Errorf("flares received = %d, want %d", got, tt.wantFlares)
+			}
+
+			if tt.wantExit != ExitOK {
+				if rep.Success || rep.Error == nil {
+					t.Fatalf("report success = %v, error = %v, want a failure", rep.Success, rep.Error)
+				}
+				if rep.Error.Code != tt.wantCode || rep.Error.StatusCode != tt.wantStatus {
+					t.Errorf("report error = %s (HTTP %d), want %s (HTTP %d)", rep.Error.Code, rep.Error.StatusCode, tt.wantCode, tt.wantStatus)
+				}
+				if rep.Flare != nil {
+					t.Errorf("report flare = %+v, want none", rep.Flare)
+				}
+				return
+			}
+
+			if !rep.Success || rep.Domain != testDomain {
+				t.Errorf("report success = %v, domain = %q, want true, %q", rep.Success, rep.Domain, testDomain)
+			}
+			if rep.Flare == nil {
+				t.Fatal("report has no flare")
+			}
+			if rep.Flare.IP != testAddress || rep.Flare.ResolvedIP != tt.resolvedIP {
+				t.Errorf("report flare ip = %s, resolvedIp = %s, want %s, %s", rep.Flare.IP, rep.Flare.ResolvedIP, testAddress, tt.resolvedIP)
+			}
+		})
+	}
+}
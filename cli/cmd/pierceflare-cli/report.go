@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+)
+
+// report is the machine-readable result of the one-shot mode and of the detect command (--output json)
+type report struct {
+	Uplink     string                `json:"uplink,omitempty"`
+	Success    bool                  `json:"success"`
+	Server     *serverReport         `json:"server,omitempty"`
+	Domain     string                `json:"domain,omitempty"`
+	Detected   map[string]*detection `json:"detected,omitempty"`
+	Flare      *flareReport          `json:"flare,omitempty"`
+	Backends   []backendReport       `json:"backends,omitempty"`
+	Error      *errorReport          `json:"error,omitempty"`
+	DurationMs int64                 `json:"durationMs"`
+
+	start time.Time
+}
+
+// detection describes the detection of the address of one family
+type detection struct {
+	Address    string          `json:"address,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	Topology   client.Topology `json:"topology,omitempty"`
+	Local      []localAddress  `json:"local,omitempty"`
+	DurationMs int64           `json:"durationMs"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// localAddress describes an address of the host compared to the detected one
+type localAddress struct {
+	Address string       `json:"address"`
+	Class   client.Class `json:"class"`
+}
+
+// serverReport describes the outcome of the API negotiation with the server
+type serverReport struct {
+	APIVersion       string `json:"apiVersion,omitempty"`
+	ClientAPIVersion string `json:"clientApiVersion"`
+	Compatible       bool   `json:"compatible"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+// flareReport describes the flare sent to the server
+type flareReport struct {
+	IP          string             `json:"ip"`
+	Op          string             `json:"op,omitempty"`
+	ResolvedIP  string             `json:"resolvedIp,omitempty"`
+	DurationMs  int64              `json:"durationMs"`
+	Propagation *propagationReport `json:"propagation,omitempty"`
+}
+
+// propagationReport describes whether the resolvers serve the flared address
+type propagationReport struct {
+	Converged  bool             `json:"converged"`
+	Proxied    bool             `json:"proxied,omitempty"`
+	DurationMs int64            `json:"durationMs"`
+	Resolvers  []resolverReport `json:"resolvers,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// resolverReport describes the answers of a resolver
+type resolverReport struct {
+	Name    string   `json:"name"`
+	Matched bool     `json:"matched"`
+	Proxied bool     `json:"proxied,omitempty"`
+	AfterMs int64    `json:"afterMs,omitempty"`
+	Answer  []string `json:"answer,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// backendReport describes the update of an additional backend
+type backendReport struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// newPropagationReport describes the outcome of the verification of the propagation, nil if none was done
+func newPropagationReport(result *client.PropagationResult, err error) *propagationReport {
+	if result == nil && err == nil {
+		return nil
+	}
+
+	p := &propagationReport{}
+	if err != nil {
+		p.Error = err.Error()
+	}
+	if result == nil {
+		return p
+	}
+
+	p.Converged = result.Converged
+	p.Proxied = result.Proxied
+	p.DurationMs = result.Duration.Milliseconds()
+	for _, r := range result.Resolvers {
+		resolver := resolverReport{Name: r.Name, Matched: r.Matched, Proxied: r.Proxied, AfterMs: r.After.Milliseconds()}
+		for _, addr := range r.Answer {
+			resolver.Answer = append(resolver.Answer, addr.String())
+		}
+		if r.Err != nil {
+			resolver.Error = r.Err.Error()
+		}
+		p.Resolvers = append(p.Resolvers, resolver)
+	}
+	return p
+}
+
+// errorReport describes the failure that ended the execution
+type errorReport struct {
+	Code       string `json:"code"`
+	ExitCode   int    `json:"exitCode"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"statusCode,omitempty"`
+}
+
+// newReport creates an empty report, timing starts now
+func newReport() *report {
+	return &report{
+		Detected: map[string]*detection{},
+		start:    time.Now(),
+	}
+}
+
+// setCompatibility records the outcome of the API negotiation, if any
+func (r *report) setCompatibility(compat *client.Compatibility) {
+	if compat == nil {
+		return
+	}
+	r.Server = &serverReport{
+		APIVersion:       compat.ServerVersion,
+		ClientAPIVersion: compat.ClientVersion,
+		Compatible:       compat.Compatible,
+		Reason:           compat.Reason,
+	}
+}
+
+// addDetection records the outcome of an IP lookup
+func (r *report) addDetection(family client.Family, result *client.Detection, err error, duration time.Duration) {
+	d := &detection{DurationMs: duration.Milliseconds()}
+	if err != nil {
+		d.Error = err.Error()
+	} else {
+		d.Address = result.Address
+		d.Source = result.Source
+		d.Topology = result.Topology
+		for _, local := range result.Local {
+			d.Local = append(d.Local, localAddress{Address: local.Address, Class: local.Class})
+		}
+		family = result.Family
+	}
+	r.Detected[family.String()] = d
+}
+
+// finish records the final outcome of the execution
+func (r *report) finish(err error) {
+	r.DurationMs = time.Since(r.start).Milliseconds()
+	r.Success = err == nil
+	if err == nil {
+		return
+	}
+
+	r.Error = &errorReport{
+		Code:     errorCode(err),
+		ExitCode: exitCode(err),
+		Message:  err.Error(),
+	}
+
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		r.Error.StatusCode = apiErr.StatusCode
+	}
+}
+
+// writeReports outputs the reports as a single JSON document: the report itself when a single link is
+// configured, or an object holding the report of every uplink otherwise
+func writeReports(w io.Writer, reports []*report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if len(reports) == 1 && reports[0].Uplink == "" {
+		return encoder.Encode(reports[0])
+	}
+
+	success := true
+	for _, r := range reports {
+		success = success && r.Success
+	}
+
+	return encoder.Encode(struct {
+		Success bool      `json:"success"`
+		Uplinks []*report `json:"uplinks"`
+	}{success, reports})
+}
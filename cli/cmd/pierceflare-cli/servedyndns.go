@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/config"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/relay"
+	"github.com/qalisa/pierceflare/cli/internal/systemd"
+	"github.com/qalisa/pierceflare/cli/internal/transport"
+)
+
+// runServeDynDNS relays the dyndns2 updates of routers to the server until interrupted. Routers give
+// their own API token as password, so only the server URL is required.
+func runServeDynDNS(opts *options) error {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		return &usageError{err}
+	}
+	if cfg.ServerURL == "" {
+		err := errors.New("PIERCEFLARE_SERVER_URL is not set")
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		return &usageError{err}
+	}
+
+	log := logger.New(cfg.LogTimestamp, cfg.LogLevel, cfg.SuccessPeriod)
+	if systemd.JournalStream() {
+		if journal, err := systemd.NewJournal(journalIdentifier); err == nil {
+			log = logger.NewWithSink(cfg.LogLevel, cfg.SuccessPeriod, journal)
+		}
+	}
+
+	httpClient, err := transport.NewClient(cfg.APITransport())
+	if err != nil {
+		log.Error("Invalid configuration: %v", err)
+		return &usageError{err}
+	}
+
+	handler := relay.New(cfg.ServerURL, httpClient, log)
+	if cfg.SignRequests {
+		handler.EnableSigning()
+	}
+
+	mux := http.NewServeMux()
+	for _, path := range relay.Paths {
+		mux.Handle("GET "+path, handler)
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	// A socket passed by systemd takes precedence over the listen address
+	addr := cfg.DynDNSListen
+	if opts.listen != "" {
+		addr = opts.listen
+	}
+	listener, err := socketListener("dyndns", addr)
+	if err != nil {
+		log.Error("Unable to listen: %v", err)
+		return &usageError{err}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Info("Shutting down...")
+		systemd.Notify(systemd.Stopping)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Info("Relaying dyndns2 updates from %s to %s", listener.Addr(), cfg.ServerURL)
+	systemd.Notify(systemd.Ready)
+
+	if cfg.DynDNSTLSCert != "" {
+		err = server.ServeTLS(listener, cfg.DynDNSTLSCert, cfg.DynDNSTLSKey)
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("dyndns2 relay stopped: %v", err)
+		return err
+	}
+	return nil
+}
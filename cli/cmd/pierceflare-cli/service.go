@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/metrics"
+	"github.com/qalisa/pierceflare/cli/internal/systemd"
+)
+
+// journalIdentifier tags the messages sent to journald (SYSLOG_IDENTIFIER)
+const journalIdentifier = "pierceflare-cli"
+
+// statusInterval is how often the status of the agents is reported to systemd
+const statusInterval = 10 * time.Second
+
+// service reports the state of the agents to systemd in continuous mode: readiness once every agent
+// passed its startup checks, a status line with the flared addresses and watchdog pings as long as
+// the loop of every agent is alive. Outside of systemd, notifications are dropped.
+type service struct {
+	log      *logger.Logger
+	watchdog time.Duration // WatchdogSec= of the unit, 0 if disabled
+	started  chan struct{} // Signaled when an agent passed its startup checks
+}
+
+func newService(log *logger.Logger) *service {
+	return &service{
+		log:      log,
+		watchdog: systemd.WatchdogInterval(),
+		started:  make(chan struct{}, 1),
+	}
+}
+
+// options returns the options the agents need to be supervised
+func (s *service) options() []client.Option {
+	opts := []client.Option{
+		client.OnStart(func() {
+			select {
+			case s.started <- struct{}{}:
+			default: // Already signaled
+			}
+		}),
+	}
+	if s.watchdog > 0 {
+		opts = append(opts, client.WithHeartbeat(s.watchdog/4))
+	}
+	return opts
+}
+
+// run notifies systemd until ctx is canceled
+func (s *service) run(ctx context.Context, agents []*client.Agent) {
+	interval := statusInterval
+	if s.watchdog > 0 && s.watchdog/2 < interval {
+		interval = s.watchdog / 2
+	}
+	if s.watchdog > 0 {
+		s.log.Debug("systemd watchdog enabled, pinging every %s", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ready := false
+	lastStatus := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.started:
+		case <-ticker.C:
+		}
+
+		stats := make([]client.Stats, len(agents))
+		for i, agent := range agents {
+			stats[i] = agent.Stats()
+		}
+
+		var states []string
+		if !ready && allRunning(stats) {
+			ready = true
+			states = append(states, systemd.Ready)
+		}
+		if status := describeStats(stats); status != lastStatus {
+			lastStatus = status
+			states = append(states, systemd.Status(status))
+		}
+		if s.watchdog > 0 && alive(stats, time.Now(), s.watchdog) {
+			states = append(states, systemd.Watchdog)
+		}
+
+		if len(states) > 0 {
+			if _, err := systemd.Notify(states...); err != nil {
+				s.log.Debug("Unable to notify systemd: %v", err)
+			}
+		}
+	}
+}
+
+// allRunning reports whether every agent passed its startup checks
+func allRunning(stats []client.Stats) bool {
+	for _, s := range stats {
+		if !s.Running {
+			return false
+		}
+	}
+	return true
+}
+
+// alive reports whether the loop of every running agent recorded a heartbeat recently. Agents still
+// starting are bounded by the timeouts of their startup checks.
+func alive(stats []client.Stats, now time.Time, within time.Duration) bool {
+	for _, s := range stats {
+		if s.Running && now.Sub(s.Heartbeat) > within {
+			return false
+		}
+	}
+	return true
+}
+
+// describeStats summarizes the state of the agents for `systemctl status`
+func describeStats(stats []client.Stats) string {
+	parts := make([]string, 0, len(stats))
+	for _, s := range stats {
+		state := describeState(s)
+		name := strings.TrimSpace(s.Name + " " + s.Domain)
+		if name != "" {
+			state = name + ": " + state
+		}
+		parts = append(parts, state)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// describeState summarizes the state of an agent
+func describeState(s client.Stats) string {
+	switch {
+	case !s.Running:
+		return "starting"
+	case s.Paused:
+		return "paused"
+	case !s.Healthy && s.Reason != "":
+		return "failing: " + s.Reason
+	case s.Fallback:
+		return "server unavailable, updating through Cloudflare"
+	case s.Queued != "":
+		return "server unavailable, flare of " + s.Queued + " queued"
+	case s.PropagationFailed:
+		return s.CurrentIP + ", not served by DNS"
+	case s.CurrentIP != "":
+		return s.CurrentIP
+	default:
+		return "checking"
+	}
+}
+
+// socketListener returns the socket passed by systemd with the given name (FileDescriptorName=) or the
+// single socket passed, a socket listening on addr otherwise, nil if there is neither
+func socketListener(name, addr string) (net.Listener, error) {
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if l := listeners[name]; len(l) > 0 {
+		return l[0], nil
+	}
+	if l := listeners["unknown"]; len(listeners) == 1 && len(l) == 1 {
+		return l[0], nil
+	}
+
+	if addr == "" {
+		return nil, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serveMetrics serves the metrics of the agents on /metrics until ctx is canceled
+func serveMetrics(ctx context.Context, log *logger.Logger, listener net.Listener, agents []*client.Agent) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler(func() []*metrics.Family {
+		return collectMetrics(agents)
+	}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Info("Serving metrics on %s/metrics", listener.Addr())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("Metrics server stopped: %v", err)
+	}
+}
+
+// collectMetrics describes the activity of the agents
+func collectMetrics(agents []*client.Agent) []*metrics.Family {
+	up := &metrics.Family{Name: "pierceflare_up", Type: metrics.Gauge,
+		Help: "Whether the last check of the uplink succeeded"}
+	running := &metrics.Family{Name: "pierceflare_running", Type: metrics.Gauge,
+		Help: "Whether the agent of the uplink passed its startup checks"}
+	checks := &metrics.Family{Name: "pierceflare_checks_total", Type: metrics.Counter,
+		Help: "Number of checks of the public IP address"}
+	flares := &metrics.Family{Name: "pierceflare_flares_total", Type: metrics.Counter,
+		Help: "Number of new IP addresses acknowledged by the server"}
+	skipped := &metrics.Family{Name: "pierceflare_flares_skipped_total", Type: metrics.Counter,
+		Help: "Number of flares skipped, the DNS record already serving the IP address"}
+	failures := &metrics.Family{Name: "pierceflare_failures_total", Type: metrics.Counter,
+		Help: "Number of failed checks (detection or flare)"}
+	lastCheck := &metrics.Family{Name: "pierceflare_last_check_timestamp_seconds", Type: metrics.Gauge,
+		Help: "Unix time of the last check"}
+	lastChange := &metrics.Family{Name: "pierceflare_last_change_timestamp_seconds", Type: metrics.Gauge,
+		Help: "Unix time the current IP address was acknowledged by the server"}
+	paused := &metrics.Family{Name: "pierceflare_paused", Type: metrics.Gauge,
+		Help: "Whether the checks of the uplink are suspended through the control socket"}
+	fallback := &metrics.Family{Name: "pierceflare_fallback", Type: metrics.Gauge,
+		Help: "Whether the record is updated directly through Cloudflare, the server being unavailable"}
+	address := &metrics.Family{Name: "pierceflare_ip_info", Type: metrics.Gauge,
+		Help: "IP address currently flared, as the ip label"}
+	budget := &metrics.Family{Name: "pierceflare_rate_limit_remaining", Type: metrics.Gauge,
+		Help: "Requests left in the rate limit window of the server, as of its last answer"}
+	queued := &metrics.Family{Name: "pierceflare_queued_since_timestamp_seconds", Type: metrics.Gauge,
+		Help: "Unix time the pending flare of the uplink was queued, the server being unavailable"}
+	propagation := &metrics.Family{Name: "pierceflare_propagation_seconds", Type: metrics.Gauge,
+		Help: "Time the current IP address took to be served by every resolver"}
+	propagationFailed := &metrics.Family{Name: "pierceflare_propagation_failed", Type: metrics.Gauge,
+		Help: "Whether resolvers did not serve the current IP address in time"}
+
+	sourceUp := &metrics.Family{Name: "pierceflare_source_up", Type: metrics.Gauge,
+		Help: "Whether the IP source is queried, as opposed to skipped after failing repeatedly"}
+	sourceSuccess := &metrics.Family{Name: "pierceflare_source_success_ratio", Type: metrics.Gauge,
+		Help: "Moving average of the successful queries of the IP source"}
+	sourceLatency := &metrics.Family{Name: "pierceflare_source_latency_seconds", Type: metrics.Gauge,
+		Help: "Moving average of the duration of the successful queries of the IP source"}
+	sourceQueries := &metrics.Family{Name: "pierceflare_source_queries_total", Type: metrics.Counter,
+		Help: "Number of queries of the IP source"}
+	sourceFailures := &metrics.Family{Name: "pierceflare_source_failures_total", Type: metrics.Counter,
+		Help: "Number of queries of the IP source whose answer was rejected"}
+
+	now := time.Now()
+	for _, agent := range agents {
+		s := agent.Stats()
+		labels := metrics.L("uplink", s.Name, "domain", s.Domain)
+
+		up.Add(boolValue(s.Healthy), labels)
+		running.Add(boolValue(s.Running), labels)
+		paused.Add(boolValue(s.Paused), labels)
+		fallback.Add(boolValue(s.Fallback), labels)
+		checks.Add(float64(s.Checks), labels)
+		flares.Add(float64(s.Flares), labels)
+		skipped.Add(float64(s.Skipped), labels)
+		failures.Add(float64(s.Failures), labels)
+		if !s.LastCheck.IsZero() {
+			lastCheck.Add(unixSeconds(s.LastCheck), labels)
+		}
+		if s.CurrentIP != "" {
+			lastChange.Add(unixSeconds(s.LastChange), labels)
+			address.Add(1, metrics.L("uplink", s.Name, "domain", s.Domain, "ip", s.CurrentIP))
+		}
+		if s.RateLimit != nil {
+			budget.Add(float64(s.RateLimit.Remaining), labels)
+		}
+		if s.Queued != "" {
+			queued.Add(unixSeconds(s.QueuedSince), labels)
+		}
+		if s.PropagationTime > 0 {
+			propagation.Add(s.PropagationTime.Seconds(), labels)
+		}
+		propagationFailed.Add(boolValue(s.PropagationFailed), labels)
+
+		for _, h := range s.Sources {
+			if h.Queries == 0 {
+				continue
+			}
+			labels := metrics.L("uplink", s.Name, "domain", s.Domain, "source", h.Source, "family", h.Family.String())
+			sourceUp.Add(boolValue(!now.Before(h.SkippedUntil)), labels)
+			sourceSuccess.Add(h.SuccessRate, labels)
+			if h.Latency > 0 {
+				sourceLatency.Add(h.Latency.Seconds(), labels)
+			}
+			sourceQueries.Add(float64(h.Queries), labels)
+			sourceFailures.Add(float64(h.Failures), labels)
+		}
+	}
+
+	return []*metrics.Family{up, running, paused, checks, flares, skipped, failures, lastCheck, lastChange, fallback, address,
+		budget, queued, propagation, propagationFailed, sourceUp, sourceSuccess, sourceLatency, sourceQueries, sourceFailures}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
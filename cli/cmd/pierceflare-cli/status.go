@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/client"
+	"github.com/qalisa/pierceflare/cli/internal/config"
+	"github.com/qalisa/pierceflare/cli/internal/control"
+)
+
+// statusReport is the machine-readable output of the status command
+type statusReport struct {
+	Running   bool           `json:"running"` // Whether the continuous mode answered on the control socket
+	Uplinks   []uplinkStatus `json:"uplinks"`
+	QueueFile string         `json:"queueFile,omitempty"`
+	Queued    []queueReport  `json:"queued"`
+}
+
+// uplinkStatus describes the state of an agent of the continuous mode, as served by the control API
+type uplinkStatus struct {
+	Uplink    string     `json:"uplink,omitempty"`
+	Domain    string     `json:"domain,omitempty"`
+	State     string     `json:"state"` // Summary, as shown by systemctl status
+	Running   bool       `json:"running"`
+	Paused    bool       `json:"paused"`
+	Healthy   bool       `json:"healthy"`
+	Reason    string     `json:"reason,omitempty"`
+	IPv4      string     `json:"ipv4,omitempty"`      // Last IPv4 address detected
+	IPv6      string     `json:"ipv6,omitempty"`      // Last IPv6 address detected
+	CurrentIP string     `json:"currentIp,omitempty"` // Address acknowledged by the server
+	LastCheck *time.Time `json:"lastCheck,omitempty"`
+	NextCheck *time.Time `json:"nextCheck,omitempty"`
+
+	LastFlare *lastFlareReport `json:"lastFlare,omitempty"`
+	RateLimit *rateLimitReport `json:"rateLimit,omitempty"`
+	Sources   []sourceReport   `json:"sources"`
+	Queued    string           `json:"queued,omitempty"` // Address waiting for the server
+}
+
+// lastFlareReport describes the last address sent to the server
+type lastFlareReport struct {
+	IP         string    `json:"ip"`
+	At         time.Time `json:"at"`
+	Op         string    `json:"op,omitempty"`
+	ResolvedIP string    `json:"resolvedIp,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// rateLimitReport describes the request budget left on the server
+type rateLimitReport struct {
+	Limit        int        `json:"limit,omitempty"`
+	Remaining    int        `json:"remaining"`
+	Reset        *time.Time `json:"reset,omitempty"`
+	BackoffUntil *time.Time `json:"backoffUntil,omitempty"` // Requests suspended until then, after HTTP 429
+}
+
+// sourceReport describes the health of an IP source
+type sourceReport struct {
+	Source              string     `json:"source"`
+	Family              string     `json:"family"`
+	Queries             uint64     `json:"queries"`
+	Failures            uint64     `json:"failures"`
+	SuccessRate         float64    `json:"successRate"`         // Moving average, recent queries weighing more
+	LatencyMs           int64      `json:"latencyMs,omitempty"` // Moving average of successful queries
+	ConsecutiveFailures int        `json:"consecutiveFailures,omitempty"`
+	SkippedUntil        *time.Time `json:"skippedUntil,omitempty"` // Circuit breaker open
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure         *time.Time `json:"lastFailure,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+}
+
+// queueReport describes a flare waiting for the server
+type queueReport struct {
+	Domain      string    `json:"domain"`
+	IP          string    `json:"ip"`
+	Queued      time.Time `json:"queued"`
+	Superseded  int       `json:"superseded,omitempty"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"lastAttempt"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// uplinkStatuses describes the state of the agents
+func uplinkStatuses(agents []*client.Agent) []uplinkStatus {
+	statuses := make([]uplinkStatus, len(agents))
+	for i, agent := range agents {
+		statuses[i] = newUplinkStatus(agent.Stats())
+	}
+	return statuses
+}
+
+func newUplinkStatus(s client.Stats) uplinkStatus {
+	u := uplinkStatus{
+		Uplink:    s.Name,
+		Domain:    s.Domain,
+		State:     describeState(s),
+		Running:   s.Running,
+		Paused:    s.Paused,
+		Healthy:   s.Healthy,
+		Reason:    s.Reason,
+		IPv4:      s.IPv4,
+		IPv6:      s.IPv6,
+		CurrentIP: s.CurrentIP,
+		LastCheck: timeOrNil(s.LastCheck),
+		NextCheck: timeOrNil(s.NextCheck),
+		Sources:   []sourceReport{},
+		Queued:    s.Queued,
+	}
+
+	if !s.LastFlare.IsZero() {
+		u.LastFlare = &lastFlareReport{IP: s.LastFlareIP, At: s.LastFlare, Error: s.LastFlareError}
+		if s.LastFlareResult != nil {
+			u.LastFlare.Op = s.LastFlareResult.Op
+			u.LastFlare.ResolvedIP = s.LastFlareResult.ResolvedIP
+		}
+	}
+
+	backoff := timeOrNil(s.BackoffUntil)
+	if backoff != nil && !backoff.After(time.Now()) {
+		backoff = nil
+	}
+	if s.RateLimit != nil || backoff != nil {
+		u.RateLimit = &rateLimitReport{BackoffUntil: backoff}
+		if s.RateLimit != nil {
+			u.RateLimit.Limit = s.RateLimit.Limit
+			u.RateLimit.Remaining = s.RateLimit.Remaining
+			u.RateLimit.Reset = timeOrNil(s.RateLimit.Reset)
+		}
+	}
+
+	for _, h := range s.Sources {
+		skipped := timeOrNil(h.SkippedUntil)
+		if skipped != nil && !skipped.After(time.Now()) {
+			skipped = nil
+		}
+		u.Sources = append(u.Sources, sourceReport{
+			Source:              h.Source,
+			Family:              h.Family.String(),
+			Queries:             h.Queries,
+			Failures:            h.Failures,
+			SuccessRate:         h.SuccessRate,
+			LatencyMs:           h.Latency.Milliseconds(),
+			ConsecutiveFailures: h.ConsecutiveFailures,
+			SkippedUntil:        skipped,
+			LastSuccess:         timeOrNil(h.LastSuccess),
+			LastFailure:         timeOrNil(h.LastFailure),
+			LastError:           h.LastError,
+		})
+	}
+	return u
+}
+
+// timeOrNil omits unset times from the JSON output
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// uplinkLabel names an agent in messages
+func uplinkLabel(s client.Stats) string {
+	return newUplinkStatus(s).label()
+}
+
+// label names the uplink in messages: its name and domain, if known
+func (u uplinkStatus) label() string {
+	switch {
+	case u.Uplink != "" && u.Domain != "":
+		return u.Uplink + " (" + u.Domain + ")"
+	case u.Uplink != "":
+		return u.Uplink
+	case u.Domain != "":
+		return u.Domain
+	default:
+		return "pierceflare"
+	}
+}
+
+// runStatus shows the state of the running continuous mode, asked through the control socket, and the
+// flares waiting for the server, as persisted to PIERCEFLARE_QUEUE_FILE
+func runStatus(opts *options) error {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		return &usageError{err}
+	}
+	if cfg.ControlSocket == "" && cfg.QueueFile == "" {
+		err := errors.New("neither the control socket (PIERCEFLARE_CONTROL_SOCKET) nor PIERCEFLARE_QUEUE_FILE is set")
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		return &usageError{err}
+	}
+
+	rep := statusReport{Uplinks: []uplinkStatus{}, QueueFile: cfg.QueueFile, Queued: []queueReport{}}
+
+	// Without the process, the queue file still tells what is pending
+	var notRunning error
+	if cfg.ControlSocket != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+		err := control.NewClient(cfg.ControlSocket, controlTimeout).
+			Call(ctx, http.MethodGet, control.RouteStatus, opts.uplink, &rep.Uplinks)
+		cancel()
+		switch {
+		case err == nil:
+			rep.Running = true
+		case control.IsNotRunning(err) && cfg.QueueFile != "":
+			notRunning = controlError(cfg.ControlSocket, err)
+		default:
+			err = controlError(cfg.ControlSocket, err)
+			fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+			return err
+		}
+	}
+
+	if cfg.QueueFile != "" {
+		queue, err := client.OpenQueue(cfg.QueueFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+			return err
+		}
+		for _, e := range queue.Entries() {
+			rep.Queued = append(rep.Queued, queueReport(e))
+		}
+	}
+
+	if opts.output == outputJSON {
+		return writeJSON(rep)
+	}
+
+	now := time.Now()
+	if notRunning != nil {
+		fmt.Println(notRunning)
+	}
+	for _, u := range rep.Uplinks {
+		printUplinkStatus(&u, now)
+	}
+
+	if cfg.QueueFile == "" {
+		return nil
+	}
+	if len(rep.Queued) == 0 {
+		fmt.Println("No pending flare")
+		return nil
+	}
+	for _, e := range rep.Queued {
+		fmt.Printf("%s: %s pending for %s, %d failed attempts, next in %s\n",
+			e.Domain, e.IP, now.Sub(e.Queued).Round(time.Second), e.Attempts, max(e.NextAttempt.Sub(now), 0).Round(time.Second))
+		if e.LastError != "" {
+			fmt.Printf("  last error: %s\n", e.LastError)
+		}
+	}
+	return nil
+}
+
+// printUplinkStatus prints the state of an uplink for humans
+func printUplinkStatus(u *uplinkStatus, now time.Time) {
+	ago := func(t time.Time) string { return now.Sub(t).Round(time.Second).String() + " ago" }
+
+	fmt.Printf("%s: %s\n", u.label(), u.State)
+
+	var addresses []string
+	if u.IPv4 != "" {
+		addresses = append(addresses, "IPv4 "+u.IPv4)
+	}
+	if u.IPv6 != "" {
+		addresses = append(addresses, "IPv6 "+u.IPv6)
+	}
+	if len(addresses) > 0 {
+		fmt.Printf("  detected:   %s\n", strings.Join(addresses, ", "))
+	}
+
+	if f := u.LastFlare; f != nil {
+		outcome := "acknowledged"
+		if f.Error != "" {
+			outcome = "failed: " + f.Error
+		} else if f.Op != "" {
+			outcome += " (" + f.Op + ")"
+		}
+		fmt.Printf("  last flare: %s %s, %s\n", f.IP, ago(f.At), outcome)
+	}
+
+	switch {
+	case u.Paused:
+		fmt.Println("  next check: paused, see pierceflare-cli resume")
+	case u.NextCheck != nil:
+		fmt.Printf("  next check: in %s\n", max(u.NextCheck.Sub(now), 0).Round(time.Second))
+	}
+
+	if r := u.RateLimit; r != nil {
+		switch {
+		case r.BackoffUntil != nil:
+			fmt.Printf("  rate limit: exceeded, requests suspended for %s\n", r.BackoffUntil.Sub(now).Round(time.Second))
+		case r.Reset != nil:
+			fmt.Printf("  rate limit: %d of %d requests left, window resets in %s\n",
+				r.Remaining, r.Limit, max(r.Reset.Sub(now), 0).Round(time.Second))
+		}
+	}
+
+	for _, s := range u.Sources {
+		var state string
+		switch {
+		case s.Queries == 0:
+			state = "not queried"
+		case s.SkippedUntil != nil:
+			state = fmt.Sprintf("skipped for %s after %d failures in a row: %s",
+				s.SkippedUntil.Sub(now).Round(time.Second), s.ConsecutiveFailures, s.LastError)
+		case s.LastError != "":
+			state = fmt.Sprintf("failed %s: %s", ago(*s.LastFailure), s.LastError)
+		default:
+			state = fmt.Sprintf("ok %s, %dms on average", ago(*s.LastSuccess), s.LatencyMs)
+		}
+		if s.Queries > 0 {
+			state += fmt.Sprintf(" (%.0f%% success)", s.SuccessRate*100)
+		}
+		if s.Family != client.FamilyAny.String() {
+			state = s.Family + " " + state
+		}
+		fmt.Printf("  source:     %s %s\n", s.Source, state)
+	}
+}
+
+// writeJSON prints v as indented JSON on stdout
+func writeJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/qalisa/pierceflare/cli/internal/config"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+)
+
+// runWhoami shows the domain the API token of every uplink is bound to, as reported by the server
+func runWhoami(opts *options) error {
+	cfg, err := config.New()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Error: %v\n", err)
+		return &usageError{err}
+	}
+
+	// Logs never mix with the command output
+	log := logger.New(cfg.LogTimestamp, cfg.LogLevel, cfg.SuccessPeriod)
+	log.SetOutput(os.Stderr)
+
+	agents, err := newAgents(cfg, os.Stderr)
+	if err != nil {
+		log.Error("Invalid configuration: %v", err)
+		return &usageError{err}
+	}
+
+	var firstErr error
+	reports := make([]*report, 0, len(agents))
+	for _, agent := range agents {
+		rep := newReport()
+		rep.Uplink = agent.Name()
+
+		domain, err := agent.Domain(context.Background())
+		rep.Domain = domain
+		rep.setCompatibility(agent.Compatibility())
+		rep.finish(err)
+		reports = append(reports, rep)
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if opts.output == outputJSON {
+		if err := writeReports(os.Stdout, reports); err != nil {
+			log.Error("Error writing report: %v", err)
+		}
+		return firstErr
+	}
+
+	for _, rep := range reports {
+		prefix := ""
+		if rep.Uplink != "" {
+			prefix = rep.Uplink + ": "
+		}
+
+		switch {
+		case rep.Error != nil:
+			fmt.Printf("%serror (%s)\n", prefix, rep.Error.Message)
+		case rep.Domain == "":
+			fmt.Printf("%sunknown (the server does not advertise GET /api/infos)\n", prefix)
+		default:
+			fmt.Printf("%s%s\n", prefix, rep.Domain)
+		}
+	}
+
+	return firstErr
+}
@@ -2,41 +2,61 @@ package api
 
 import (
 	"context"
-	"fmt"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
+	"github.com/qalisa/pierceflare/cli/internal/backend"
 	genapi "github.com/qalisa/pierceflare/cli/internal/gen/api"
 	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/signing"
 )
 
 // Client is a client for the PierceFlare API
 type Client struct {
-	apiKey string
-	client *genapi.ClientWithResponses
-	logger *logger.Logger
-	ctx    context.Context
+	apiKey     string
+	serverURL  string
+	httpClient *http.Client
+	client     *genapi.ClientWithResponses
+	logger     *logger.Logger
+	signer     *signing.Signer // Signs API requests when set
+	rateLimit  rateLimitTracker
 }
 
-// NewClient creates a new API client
-func NewClient(apiKey, serverURL string, logger *logger.Logger) *Client {
-	// Create the base context
-	ctx := context.Background()
+// DefaultTimeout is the timeout of each call to the PierceFlare server
+const DefaultTimeout = 10 * time.Second
 
+// NewClient creates a new API client, sending requests through httpClient
+// (nil for a default client with DefaultTimeout)
+func NewClient(apiKey, serverURL string, httpClient *http.Client, logger *logger.Logger) *Client {
 	// Make sure the server URL is properly formatted
 	serverURL = strings.TrimRight(serverURL, "/")
 
+	if httpClient == nil {
+		httpClient = &http.Client{
+			Timeout: DefaultTimeout,
+		}
+	}
+
+	c := &Client{
+		apiKey:     apiKey,
+		serverURL:  serverURL,
+		httpClient: httpClient,
+		logger:     logger,
+	}
+
 	// Create the generated client with authentication
 	client, err := genapi.NewClientWithResponses(
 		serverURL,
 		genapi.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
 			req.Header.Set("Authorization", "Bearer "+apiKey)
+			if c.signer != nil {
+				return c.signer.Sign(req)
+			}
 			return nil
 		}),
-		genapi.WithHTTPClient(&http.Client{
-			Timeout: 10 * time.Second,
-		}),
+		genapi.WithHTTPClient(httpClient),
 	)
 
 	if err != nil {
@@ -44,36 +64,75 @@ func NewClient(apiKey, serverURL string, logger *logger.Logger) *Client {
 		return nil
 	}
 
-	return &Client{
-		apiKey: apiKey,
-		client: client,
-		logger: logger,
-		ctx:    ctx,
-	}
+	c.client = client
+	return c
 }
 
-// CheckTokenValidity verifies the API token validity
-func (c *Client) CheckTokenValidity() error {
+// EnableSigning makes the client sign its API requests (HMAC of a timestamp, a nonce and the body
+// digest, keyed with a key derived from the API token), for servers verifying signatures
+func (c *Client) EnableSigning(signer *signing.Signer) {
+	c.signer = signer
+}
+
+// Name identifies the PierceFlare server as a backend
+func (c *Client) Name() string {
+	return "pierceflare " + c.serverURL
+}
+
+// Update flares addr, making the server update the record bound to the API token.
+// It implements backend.Backend; SendIPUpdate also returns the acknowledgement of the server.
+func (c *Client) Update(ctx context.Context, addr netip.Addr) error {
+	_, err := c.SendIPUpdate(ctx, addr.String(), false)
+	return err
+}
+
+var _ backend.Backend = (*Client)(nil)
+
+// FlareResult is the acknowledgement of a flare by the server
+type FlareResult struct {
+	Op         string // Operation queued by the server ("batch" or "dummy")
+	ResolvedIP string // IP address the server will use for the DNS record
+}
+
+// CheckTokenValidity verifies the API token validity.
+// Failures are reported as *Error, whose kind can be tested with errors.Is.
+func (c *Client) CheckTokenValidity(ctx context.Context) error {
 	c.logger.Debug("Checking token validity...")
 
-	resp, err := c.client.GetApiInfosWithResponse(c.ctx)
+	domain, err := c.GetBoundDomain(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Debug("Token valid, bound to %s.", domain)
+	return nil
+}
+
+// GetBoundDomain returns the domain the API token is associated with.
+// Failures are reported as *Error, whose kind can be tested with errors.Is.
+func (c *Client) GetBoundDomain(ctx context.Context) (string, error) {
+	resp, err := c.client.GetApiInfosWithResponse(ctx)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return "", networkError("token check", err)
 	}
+	c.rateLimit.observe(resp.HTTPResponse)
 
 	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
-		return fmt.Errorf("unable to validate token (HTTP %d)", resp.StatusCode())
+		return "", statusError("token check", resp.HTTPResponse)
 	}
 
-	c.logger.Debug("Token valid.")
-	return nil
+	return strings.TrimSpace(string(resp.Body)), nil
 }
 
-// SendIPUpdate sends an IP address update to the server
-func (c *Client) SendIPUpdate(ipAddress string, isDummy bool) error {
-	if isDummy {
+// SendIPUpdate sends an IP address update to the server. Without address, the server uses the
+// address the request comes from. Failures are reported as *Error, whose kind can be tested with errors.Is.
+func (c *Client) SendIPUpdate(ctx context.Context, ipAddress string, isDummy bool) (*FlareResult, error) {
+	switch {
+	case isDummy:
 		c.logger.Debug("Sending dummy IP update: %s", ipAddress)
-	} else {
+	case ipAddress == "":
+		c.logger.Debug("Sending IP update (address resolved by the server)")
+	default:
 		c.logger.Debug("Sending IP update: %s", ipAddress)
 	}
 
@@ -85,19 +144,27 @@ func (c *Client) SendIPUpdate(ipAddress string, isDummy bool) error {
 		Ip:    &ip,
 		Dummy: &dummy,
 	}
+	if ipAddress == "" {
+		reqBody.Ip = nil
+	}
 
 	// Send the request
-	resp, err := c.client.PutApiFlareWithResponse(c.ctx, reqBody)
+	resp, err := c.client.PutApiFlareWithResponse(ctx, reqBody)
 	if err != nil {
-		return fmt.Errorf("update failed: %w", err)
+		return nil, networkError("update", err)
 	}
+	c.rateLimit.observe(resp.HTTPResponse)
 
 	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		apiErr := statusError("update", resp.HTTPResponse)
 		if resp.JSON500 != nil {
-			return fmt.Errorf("update failed (HTTP %d): code=%s, message=%s",
-				resp.StatusCode(), resp.JSON500.ErrCode, resp.JSON500.Message)
+			apiErr.Code = string(resp.JSON500.ErrCode)
+			apiErr.Message = resp.JSON500.Message
+			if resp.JSON500.ErrCode == genapi.UNRESOLVABLE {
+				apiErr.Kind = ErrUnresolvable
+			}
 		}
-		return fmt.Errorf("update failed (HTTP %d)", resp.StatusCode())
+		return nil, apiErr
 	}
 
 	if isDummy {
@@ -106,5 +173,11 @@ func (c *Client) SendIPUpdate(ipAddress string, isDummy bool) error {
 		c.logger.Debug("Update successful (HTTP %d)", resp.StatusCode())
 	}
 
-	return nil
+	result := &FlareResult{}
+	if resp.JSON200 != nil {
+		result.Op = string(resp.JSON200.Op)
+		result.ResolvedIP = resp.JSON200.ResolvedIp
+	}
+
+	return result, nil
 }
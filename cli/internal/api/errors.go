@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error kinds returned by Client calls. Use errors.Is to test for them.
+var (
+	// ErrUnauthenticated is returned when the server did not accept the request credentials (HTTP 401)
+	ErrUnauthenticated = errors.New("missing or malformed API token")
+	// ErrAuthRevoked is returned when the server does not know the API token anymore (HTTP 403)
+	ErrAuthRevoked = errors.New("API token rejected by server (revoked or deleted)")
+	// ErrRateLimited is returned when the server asks the client to slow down (HTTP 429)
+	ErrRateLimited = errors.New("rate limited by server")
+	// ErrUnresolvable is returned when the server could not resolve the IP of the flare emitter
+	ErrUnresolvable = errors.New("server could not resolve emitter IP")
+	// ErrServer is returned when the server answered with an unexpected failure
+	ErrServer = errors.New("server error")
+	// ErrNetwork is returned when the server could not be reached
+	ErrNetwork = errors.New("network error")
+)
+
+// DefaultRetryAfter is the delay assumed when a rate limited response does not advise one
+const DefaultRetryAfter = 60 * time.Second
+
+// Error describes a failed call to the PierceFlare API
+type Error struct {
+	Kind       error         // One of the Err* values above
+	Op         string        // Operation that failed (e.g. "update", "token check")
+	StatusCode int           // HTTP status code, 0 if no response was received
+	Code       string        // Error code reported by the server, if any
+	Message    string        // Error message reported by the server, if any
+	RetryAfter time.Duration // Delay advised by the server before retrying, if any
+	Err        error         // Underlying transport error, if any
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("%s failed: %v: %v", e.Op, e.Kind, e.Err)
+	case e.Code != "":
+		return fmt.Sprintf("%s failed (HTTP %d): %v: code=%s, message=%s", e.Op, e.StatusCode, e.Kind, e.Code, e.Message)
+	case e.RetryAfter > 0:
+		return fmt.Sprintf("%s failed (HTTP %d): %v, retry after %s", e.Op, e.StatusCode, e.Kind, e.RetryAfter)
+	default:
+		return fmt.Sprintf("%s failed (HTTP %d): %v", e.Op, e.StatusCode, e.Kind)
+	}
+}
+
+// Unwrap exposes both the error kind and the underlying transport error to errors.Is / errors.As
+func (e *Error) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+// IsAuthError reports whether err means the server refused the API token
+func IsAuthError(err error) bool {
+	return errors.Is(err, ErrAuthRevoked) || errors.Is(err, ErrUnauthenticated)
+}
+
+// IsUnavailable reports whether err means the server could not be reached or failed (HTTP 5xx),
+// as opposed to refusing the request
+func IsUnavailable(err error) bool {
+	if errors.Is(err, ErrNetwork) {
+		return true
+	}
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Kind == ErrServer && apiErr.StatusCode >= 500
+}
+
+// RetryAfter returns the delay advised by the server before retrying, or 0 if err does not carry one
+func RetryAfter(err error) time.Duration {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+	return 0
+}
+
+// networkError wraps a transport failure
+func networkError(op string, err error) *Error {
+	return &Error{Kind: ErrNetwork, Op: op, Err: err}
+}
+
+// statusError maps an unsuccessful HTTP response to an *Error
+func statusError(op string, resp *http.Response) *Error {
+	e := &Error{Op: op, StatusCode: resp.StatusCode}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		e.Kind = ErrUnauthenticated
+	case resp.StatusCode == http.StatusForbidden:
+		e.Kind = ErrAuthRevoked
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Kind = ErrRateLimited
+		e.RetryAfter = parseRetryAfter(resp.Header)
+	default:
+		e.Kind = ErrServer
+	}
+
+	return e
+}
+
+// parseRetryAfter reads the delay advised by the server, from either the standard Retry-After header
+// or the RateLimit-Reset header (draft-6) sent by the PierceFlare server's rate limiter
+func parseRetryAfter(header http.Header) time.Duration {
+	for _, name := range []string{"Retry-After", "RateLimit-Reset"} {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+
+		if date, err := http.ParseTime(value); err == nil {
+			if delay := time.Until(date); delay > 0 {
+				return delay
+			}
+		}
+	}
+
+	return DefaultRetryAfter
+}
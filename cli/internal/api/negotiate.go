@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	genapi "github.com/qalisa/pierceflare/cli/internal/gen/api"
+)
+
+// DocPath is where the server publishes its OpenAPI document
+const DocPath = "/swagger/doc"
+
+// maxDocSize bounds the OpenAPI document read from the server
+const maxDocSize = 1 << 20
+
+// Capability is a feature of the PierceFlare API the client relies on
+type Capability string
+
+const (
+	// CapabilityInfos is GET /api/infos, used to check the token and get the bound domain
+	CapabilityInfos Capability = "infos"
+	// CapabilityFlare is PUT /api/flare, without which the client is useless
+	CapabilityFlare Capability = "flare"
+	// CapabilityDummy is the "dummy" flag of PUT /api/flare, used for test updates
+	CapabilityDummy Capability = "dummy"
+)
+
+// Compatibility is the outcome of the negotiation with the server
+type Compatibility struct {
+	ClientVersion string              // API version of the compiled-in spec
+	ServerVersion string              // API version published by the server, empty if unknown
+	Negotiated    bool                // Whether the document of the server could be read
+	Compatible    bool                // Whether the client can work with the server
+	Reason        string              // Why the server is incompatible, or why nothing could be negotiated
+	Capabilities  map[Capability]bool // Features advertised by the server
+}
+
+// Supports reports whether the server advertises a capability
+func (c *Compatibility) Supports(capability Capability) bool {
+	return c.Capabilities[capability]
+}
+
+// openAPIDoc is the subset of an OpenAPI document used for the negotiation
+type openAPIDoc struct {
+	Info struct {
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths map[string]map[string]struct {
+		RequestBody struct {
+			Content map[string]struct {
+				Schema struct {
+					Properties map[string]json.RawMessage `json:"properties"`
+				} `json:"schema"`
+			} `json:"content"`
+		} `json:"requestBody"`
+	} `json:"paths"`
+}
+
+// capabilities lists the features advertised by a document
+func (d *openAPIDoc) capabilities() map[Capability]bool {
+	_, infos := d.Paths["/api/infos"]["get"]
+	flare, hasFlare := d.Paths["/api/flare"]["put"]
+	_, dummy := flare.RequestBody.Content["application/json"].Schema.Properties["dummy"]
+
+	return map[Capability]bool{
+		CapabilityInfos: infos,
+		CapabilityFlare: hasFlare,
+		CapabilityDummy: hasFlare && dummy,
+	}
+}
+
+// compiledDoc returns the document the client was generated from
+func compiledDoc() *openAPIDoc {
+	doc := &openAPIDoc{}
+	if err := json.Unmarshal(genapi.Spec, doc); err != nil {
+		panic(fmt.Sprintf("invalid compiled-in OpenAPI document: %v", err))
+	}
+	return doc
+}
+
+// Negotiate fetches the OpenAPI document of the server and compares it with the compiled-in one.
+// If the document cannot be read (older server, proxy), the returned Compatibility assumes the
+// capabilities of the compiled-in spec and the error explains why.
+func (c *Client) Negotiate(ctx context.Context) (*Compatibility, error) {
+	local := compiledDoc()
+
+	compat := &Compatibility{
+		ClientVersion: local.Info.Version,
+		Compatible:    true,
+		Capabilities:  local.capabilities(),
+	}
+
+	remote, err := c.fetchDoc(ctx)
+	if err != nil {
+		compat.Reason = err.Error()
+		return compat, fmt.Errorf("unable to read the API document of the server: %w", err)
+	}
+
+	compat.Negotiated = true
+	compat.ServerVersion = remote.Info.Version
+	compat.Capabilities = remote.capabilities()
+
+	// Versions follow semver: only a change of major version breaks the API
+	serverMajor, clientMajor := majorVersion(compat.ServerVersion), majorVersion(compat.ClientVersion)
+
+	switch {
+	case !compat.Supports(CapabilityFlare):
+		compat.Compatible = false
+		compat.Reason = "the server does not advertise PUT /api/flare"
+	case serverMajor >= 0 && clientMajor >= 0 && serverMajor != clientMajor:
+		compat.Compatible = false
+		compat.Reason = fmt.Sprintf("server API version %s is not compatible with client API version %s",
+			compat.ServerVersion, compat.ClientVersion)
+	}
+
+	return compat, nil
+}
+
+// fetchDoc downloads and decodes the OpenAPI document of the server
+func (c *Client) fetchDoc(ctx context.Context) (*openAPIDoc, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.serverURL+DocPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered HTTP %d", DocPath, resp.StatusCode)
+	}
+
+	doc := &openAPIDoc{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocSize)).Decode(doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document at %s: %w", DocPath, err)
+	}
+
+	return doc, nil
+}
+
+// majorVersion returns the major component of a semantic version, -1 if it cannot be parsed
+// (e.g. "..." when the server was built without OPENAPI_VERSION)
+func majorVersion(version string) int {
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return -1
+	}
+	return n
+}
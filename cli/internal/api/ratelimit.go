@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is the request budget advertised by the server's rate limiter (draft-6 RateLimit-* headers)
+type RateLimit struct {
+	Limit     int       // Requests allowed per window
+	Remaining int       // Requests left in the current window
+	Reset     time.Time // When the current window ends
+	Observed  time.Time // When the server advertised this budget
+}
+
+// rateLimitTracker keeps the last budget advertised by the server
+type rateLimitTracker struct {
+	mu   sync.Mutex
+	last *RateLimit
+}
+
+// observe records the budget advertised by resp, if any
+func (t *rateLimitTracker) observe(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	limit, err := strconv.Atoi(resp.Header.Get("RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, _ := strconv.Atoi(resp.Header.Get("RateLimit-Remaining"))
+	reset, _ := strconv.Atoi(resp.Header.Get("RateLimit-Reset"))
+
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last = &RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     now.Add(time.Duration(reset) * time.Second),
+		Observed:  now,
+	}
+}
+
+// RateLimit returns the request budget advertised by the server in its last answer, nil if it did not
+// advertise any (e.g. rate limiting disabled) or if no request was sent yet
+func (c *Client) RateLimit() *RateLimit {
+	c.rateLimit.mu.Lock()
+	defer c.rateLimit.mu.Unlock()
+	if c.rateLimit.last == nil {
+		return nil
+	}
+	budget := *c.rateLimit.last
+	return &budget
+}
@@ -0,0 +1,17 @@
+// Package backend defines the sinks receiving the addresses detected by the agent. The PierceFlare
+// server is the main one (api.Client); others keep additional records in sync from the same detection
+// loop, such as internal authoritative servers (rfc2136) or dynamic DNS providers (dyndns2).
+package backend
+
+import (
+	"context"
+	"net/netip"
+)
+
+// Backend points the records it manages to a detected address
+type Backend interface {
+	// Name identifies the backend in logs and reports
+	Name() string
+	// Update points the records of the family of addr to addr
+	Update(ctx context.Context, addr netip.Addr) error
+}
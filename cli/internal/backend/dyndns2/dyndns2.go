@@ -0,0 +1,163 @@
+// Package dyndns2 updates records through the dyndns2 protocol (GET /nic/update), spoken by DynDNS,
+// No-IP, Google Domains, ddclient-compatible servers and many routers.
+package dyndns2
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// userAgent identifies the client, as required by the protocol
+const userAgent = "pierceflare-cli dyndns2"
+
+// maxAnswerSize bounds the answers read from the server
+const maxAnswerSize = 4096
+
+// Errors of updates. Use errors.Is to test for them.
+var (
+	// ErrFatal is returned when the server refused the update in a way retrying cannot fix (bad
+	// credentials, unknown host, abuse). The protocol forbids retrying: the backend stays disabled
+	// until the agent is restarted with a fixed configuration.
+	ErrFatal = errors.New("update refused by the dyndns2 server")
+	// ErrTemporary is returned when the server failed and asks to retry later
+	ErrTemporary = errors.New("dyndns2 server temporarily unavailable")
+)
+
+// answers explains the return codes of the protocol
+var answers = map[string]string{
+	"badauth":  "invalid username or password",
+	"!donator": "feature not available to this account",
+	"notfqdn":  "the hostname is not a fully qualified domain name",
+	"nohost":   "the hostname does not exist in this account",
+	"numhost":  "too many hosts in the update",
+	"abuse":    "the hostname is blocked for abuse",
+	"badagent": "the user agent was refused",
+	"dnserr":   "DNS error on the server",
+	"911":      "server failure",
+}
+
+// Options configures the updates
+type Options struct {
+	URL      string // Update endpoint, e.g. https://members.dyndns.org/nic/update
+	Username string
+	Password string
+	Hostname string // Host to update (comma-separated for several)
+}
+
+// Backend updates a host of a dyndns2 provider
+type Backend struct {
+	opts       Options
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	disabled error // Fatal answer, updates are not sent anymore
+}
+
+// New checks the options and creates a Backend sending requests through httpClient
+// (http.DefaultClient if nil)
+func New(opts Options, httpClient *http.Client) (*Backend, error) {
+	if opts.URL == "" || opts.Hostname == "" {
+		return nil, fmt.Errorf("URL and hostname are required")
+	}
+	endpoint, err := url.Parse(opts.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid dyndns2 URL: %s", opts.URL)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = "/nic/update"
+		opts.URL = endpoint.String()
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Backend{opts: opts, httpClient: httpClient}, nil
+}
+
+// Name identifies the backend
+func (b *Backend) Name() string {
+	endpoint, _ := url.Parse(b.opts.URL)
+	return "dyndns2 " + b.opts.Hostname + " @" + endpoint.Host
+}
+
+// Update points the host to addr
+func (b *Backend) Update(ctx context.Context, addr netip.Addr) error {
+	b.mu.Lock()
+	disabled := b.disabled
+	b.mu.Unlock()
+	if disabled != nil {
+		return fmt.Errorf("backend disabled after a fatal answer: %w", disabled)
+	}
+
+	query := url.Values{"hostname": {b.opts.Hostname}, "myip": {addr.Unmap().String()}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.opts.URL+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(b.opts.Username, b.opts.Password)
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTemporary, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return b.disable(fmt.Errorf("%w: badauth (%s)", ErrFatal, answers["badauth"]))
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%w: HTTP %d", ErrTemporary, resp.StatusCode)
+	}
+
+	// One answer line per host
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxAnswerSize))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		code, _, _ := strings.Cut(line, " ")
+		switch code {
+		case "good", "nochg":
+		case "dnserr", "911":
+			return fmt.Errorf("%w: %s (%s)", ErrTemporary, code, answers[code])
+		default:
+			reason, ok := answers[code]
+			if !ok {
+				return fmt.Errorf("%w: unexpected answer %q", ErrTemporary, truncate(line))
+			}
+			return b.disable(fmt.Errorf("%w: %s (%s)", ErrFatal, code, reason))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrTemporary, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: HTTP %d", ErrTemporary, resp.StatusCode)
+	}
+	return nil
+}
+
+// disable stops further updates after a fatal answer
+func (b *Backend) disable(err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.disabled = err
+	return err
+}
+
+// truncate shortens answers quoted in errors
+func truncate(s string) string {
+	if len(s) > 64 {
+		return s[:64] + "..."
+	}
+	return s
+}
@@ -0,0 +1,44 @@
+package rfc2136
+
+import (
+	"encoding/binary"
+
+	"github.com/qalisa/pierceflare/cli/internal/dnswire"
+)
+
+// updateMessage builds an update of zone replacing the RRset of name and rrType by a single record
+func updateMessage(id uint16, zone, name string, rrType uint16, ttl uint32, rdata []byte) ([]byte, error) {
+	msg := dnswire.AppendHeader(make([]byte, 0, 512), dnswire.Header{
+		ID:      id,
+		Flags:   dnswire.OpcodeUpdate << 11,
+		QDCount: 1, // ZOCOUNT
+		ANCount: 0, // PRCOUNT
+		NSCount: 2, // UPCOUNT
+	})
+
+	// Zone section
+	msg, err := dnswire.AppendName(msg, zone)
+	if err != nil {
+		return nil, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, dnswire.TypeSOA)
+	msg = binary.BigEndian.AppendUint16(msg, dnswire.ClassIN)
+
+	// Update section: delete the RRset, then add the new record
+	if msg, err = dnswire.AppendRR(msg, name, rrType, dnswire.ClassANY, 0, nil); err != nil {
+		return nil, err
+	}
+	return dnswire.AppendRR(msg, name, rrType, dnswire.ClassIN, ttl, rdata)
+}
+
+// lastAdditional returns the last record of the message, where a TSIG record must be, if any
+func lastAdditional(msg []byte) (*dnswire.RR, error) {
+	_, sections, err := dnswire.ParseSections(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(sections.Additional) == 0 {
+		return nil, nil
+	}
+	return &sections.Additional[len(sections.Additional)-1], nil
+}
@@ -0,0 +1,134 @@
+// Package rfc2136 updates records of an authoritative DNS server (e.g. BIND) with dynamic updates
+// (RFC 2136), signed with a TSIG key (RFC 8945).
+package rfc2136
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/dnswire"
+)
+
+// Defaults of Options
+const (
+	DefaultTTL     = 300 * time.Second
+	DefaultTimeout = 5 * time.Second
+)
+
+// Options configures the updates
+type Options struct {
+	Server       string        // Primary server of the zone, host or host:port (port 53 by default)
+	Zone         string        // Zone of the record
+	Record       string        // Name of the record, within Zone
+	TTL          time.Duration // TTL of the record (DefaultTTL if 0)
+	KeyName      string        // Name of the TSIG key, unsigned updates if empty
+	KeySecret    string        // Base64 secret of the TSIG key
+	KeyAlgorithm string        // TSIG algorithm (DefaultAlgorithm if empty)
+	Timeout      time.Duration // Timeout of an update (DefaultTimeout if 0)
+}
+
+// ErrRefused is returned when the server answered the update with an error code
+var ErrRefused = errors.New("update refused by the DNS server")
+
+// Backend replaces the A or AAAA record of a name by the detected address
+type Backend struct {
+	opts Options
+	key  *key
+	now  func() time.Time
+}
+
+// New checks the options and creates a Backend
+func New(opts Options) (*Backend, error) {
+	if opts.Server == "" || opts.Zone == "" || opts.Record == "" {
+		return nil, fmt.Errorf("server, zone and record are required")
+	}
+	if _, _, err := net.SplitHostPort(opts.Server); err != nil {
+		opts.Server = net.JoinHostPort(opts.Server, "53")
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	// Names are checked once, rather than at every update
+	for _, name := range []string{opts.Zone, opts.Record} {
+		if _, err := dnswire.AppendName(nil, name); err != nil {
+			return nil, err
+		}
+	}
+
+	b := &Backend{opts: opts, now: time.Now}
+	if opts.KeyName != "" {
+		k, err := newKey(opts.KeyName, opts.KeyAlgorithm, opts.KeySecret)
+		if err != nil {
+			return nil, err
+		}
+		b.key = k
+	}
+	return b, nil
+}
+
+// Name identifies the backend
+func (b *Backend) Name() string {
+	return "rfc2136 " + dnswire.FQDN(b.opts.Record) + " @" + b.opts.Server
+}
+
+// Update replaces the record of the family of addr by addr
+func (b *Backend) Update(ctx context.Context, addr netip.Addr) error {
+	rrType := uint16(dnswire.TypeA)
+	rdata := addr.AsSlice()
+	if addr.Is6() && !addr.Is4In6() {
+		rrType = dnswire.TypeAAAA
+	} else {
+		rdata = addr.Unmap().AsSlice()
+	}
+
+	id, err := dnswire.NewID()
+	if err != nil {
+		return err
+	}
+
+	msg, err := updateMessage(id, b.opts.Zone, b.opts.Record, rrType, uint32(b.opts.TTL/time.Second), rdata)
+	if err != nil {
+		return err
+	}
+
+	var requestMAC []byte
+	if b.key != nil {
+		if msg, requestMAC, err = b.key.sign(msg, b.now()); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.opts.Timeout)
+	defer cancel()
+
+	resp, err := dnswire.Exchange(ctx, b.opts.Server, msg)
+	if err != nil {
+		return fmt.Errorf("update of %s failed: %w", dnswire.FQDN(b.opts.Record), err)
+	}
+
+	h, err := dnswire.ParseHeader(resp)
+	if err != nil {
+		return err
+	}
+	if b.key != nil {
+		if err := b.key.verify(resp, requestMAC, b.now()); err != nil {
+			// An error answer still tells why the update failed, even if it cannot be trusted
+			if h.Rcode() != 0 {
+				return fmt.Errorf("%w: %s (%v)", ErrRefused, dnswire.RcodeName(h.Rcode()), err)
+			}
+			return err
+		}
+	}
+	if h.Rcode() != 0 {
+		return fmt.Errorf("%w: %s", ErrRefused, dnswire.RcodeName(h.Rcode()))
+	}
+	return nil
+}
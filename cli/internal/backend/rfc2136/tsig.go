@@ -0,0 +1,212 @@
+package rfc2136
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/dnswire"
+)
+
+// DefaultAlgorithm is the TSIG algorithm used when none is configured
+const DefaultAlgorithm = "hmac-sha256"
+
+// fudge is the clock difference tolerated between the client and the server
+const fudge = 300
+
+// algorithms are the TSIG algorithms supported, by name (RFC 8945)
+var algorithms = map[string]func() hash.Hash{
+	"hmac-sha1":   sha1.New,
+	"hmac-sha224": sha256.New224,
+	"hmac-sha256": sha256.New,
+	"hmac-sha384": sha512.New384,
+	"hmac-sha512": sha512.New,
+}
+
+// Errors of TSIG signed exchanges
+var (
+	ErrUnsignedResponse = errors.New("response is not signed")
+	ErrBadResponseMAC   = errors.New("invalid TSIG signature of the response")
+)
+
+// key is a TSIG key
+type key struct {
+	name      string // Absolute, lowercase
+	algorithm string // Absolute, lowercase
+	secret    []byte
+	hash      func() hash.Hash
+}
+
+// newKey checks and decodes a TSIG key, as written in BIND configuration files
+func newKey(name, algorithm, secret string) (*key, error) {
+	if algorithm == "" {
+		algorithm = DefaultAlgorithm
+	}
+	algorithm = strings.ToLower(strings.TrimSuffix(algorithm, "."))
+
+	h, ok := algorithms[algorithm]
+	if !ok {
+		names := make([]string, 0, len(algorithms))
+		for name := range algorithms {
+			names = append(names, name)
+		}
+		return nil, fmt.Errorf("unsupported TSIG algorithm '%s' (supported: %s)", algorithm, strings.Join(names, ", "))
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSIG secret (base64 expected): %w", err)
+	}
+
+	return &key{
+		name:      strings.ToLower(dnswire.FQDN(name)),
+		algorithm: algorithm + ".",
+		secret:    decoded,
+		hash:      h,
+	}, nil
+}
+
+// tsigVariables encodes the TSIG variables covered by the MAC (RFC 8945, section 4.3.3)
+func (k *key) tsigVariables(timeSigned uint64, tsigError uint16, other []byte) []byte {
+	b, _ := dnswire.AppendName(nil, k.name)
+	b = binary.BigEndian.AppendUint16(b, dnswire.ClassANY)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b, _ = dnswire.AppendName(b, k.algorithm)
+	b = appendUint48(b, timeSigned)
+	b = binary.BigEndian.AppendUint16(b, fudge)
+	b = binary.BigEndian.AppendUint16(b, tsigError)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(other)))
+	return append(b, other...)
+}
+
+// sign appends a TSIG record to msg, returning the signed message and its MAC
+func (k *key) sign(msg []byte, now time.Time) ([]byte, []byte, error) {
+	timeSigned := uint64(now.Unix())
+
+	mac := hmac.New(k.hash, k.secret)
+	mac.Write(msg)
+	mac.Write(k.tsigVariables(timeSigned, 0, nil))
+	sum := mac.Sum(nil)
+
+	rdata, err := dnswire.AppendName(nil, k.algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	rdata = appendUint48(rdata, timeSigned)
+	rdata = binary.BigEndian.AppendUint16(rdata, fudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[0:2]...)              // Original ID
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // Error
+	rdata = binary.BigEndian.AppendUint16(rdata, 0) // Other length
+
+	signed := append([]byte(nil), msg...)
+	if signed, err = dnswire.AppendRR(signed, k.name, dnswire.TypeTSIG, dnswire.ClassANY, 0, rdata); err != nil {
+		return nil, nil, err
+	}
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+	return signed, sum, nil
+}
+
+// tsigRecord is the data of a TSIG record
+type tsigRecord struct {
+	algorithm  string
+	timeSigned uint64
+	fudge      uint16
+	mac        []byte
+	originalID uint16
+	err        uint16
+	other      []byte
+}
+
+func parseTSIG(msg []byte, record *dnswire.RR) (*tsigRecord, error) {
+	algorithm, off, err := dnswire.ReadName(msg, record.DataOff)
+	if err != nil {
+		return nil, err
+	}
+	end := record.DataOff + len(record.Data)
+	if off+10 > end {
+		return nil, dnswire.ErrMalformed
+	}
+
+	t := &tsigRecord{algorithm: strings.ToLower(algorithm)}
+	t.timeSigned = uint64(binary.BigEndian.Uint16(msg[off:]))<<32 | uint64(binary.BigEndian.Uint32(msg[off+2:]))
+	t.fudge = binary.BigEndian.Uint16(msg[off+6:])
+	macSize := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+macSize+6 > end {
+		return nil, dnswire.ErrMalformed
+	}
+	t.mac = msg[off : off+macSize]
+	off += macSize
+	t.originalID = binary.BigEndian.Uint16(msg[off:])
+	t.err = binary.BigEndian.Uint16(msg[off+2:])
+	otherSize := int(binary.BigEndian.Uint16(msg[off+4:]))
+	off += 6
+	if off+otherSize > end {
+		return nil, dnswire.ErrMalformed
+	}
+	t.other = msg[off : off+otherSize]
+	return t, nil
+}
+
+// verify checks the TSIG record of a response to a request signed with requestMAC
+// (RFC 8945, section 5.3). TSIG errors of the server are returned as such.
+func (k *key) verify(resp, requestMAC []byte, now time.Time) error {
+	record, err := lastAdditional(resp)
+	if err != nil {
+		return err
+	}
+	if record == nil || record.Type != dnswire.TypeTSIG {
+		return ErrUnsignedResponse
+	}
+
+	t, err := parseTSIG(resp, record)
+	if err != nil {
+		return err
+	}
+	if t.err != 0 {
+		return fmt.Errorf("server refused the TSIG signature: %s", dnswire.RcodeName(int(t.err)))
+	}
+	if !strings.EqualFold(record.Name, k.name) || t.algorithm != k.algorithm {
+		return fmt.Errorf("%w: signed with another key", ErrBadResponseMAC)
+	}
+
+	// The MAC covers the request MAC, then the response without its TSIG record and with its original ID
+	unsigned := append([]byte(nil), resp[:record.Start]...)
+	binary.BigEndian.PutUint16(unsigned[0:], t.originalID)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+
+	mac := hmac.New(k.hash, k.secret)
+	mac.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC))))
+	mac.Write(requestMAC)
+	mac.Write(unsigned)
+	variables := k.tsigVariables(t.timeSigned, t.err, t.other)
+	// The fudge of the response may differ from ours
+	binary.BigEndian.PutUint16(variables[len(variables)-6-len(t.other):], t.fudge)
+	mac.Write(variables)
+
+	if !hmac.Equal(mac.Sum(nil), t.mac) {
+		return ErrBadResponseMAC
+	}
+
+	signedAt := time.Unix(int64(t.timeSigned), 0)
+	if delta := now.Sub(signedAt).Abs(); delta > time.Duration(t.fudge)*time.Second {
+		return fmt.Errorf("%w: signed %s away from the local clock", ErrBadResponseMAC, delta.Round(time.Second))
+	}
+	return nil
+}
+
+// appendUint48 encodes the 48-bit time of TSIG records
+func appendUint48(b []byte, v uint64) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(v>>32))
+	return binary.BigEndian.AppendUint32(b, uint32(v))
+}
@@ -0,0 +1,277 @@
+package rfc2136
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/dnswire"
+)
+
+// Known answers, packed and signed by github.com/miekg/dns (used by CoreDNS), with the hmac-sha256 key
+// update-key. and the secret "secret-key-for-tests"
+const (
+	testKeyName = "update-key"
+	testSecret  = "c2VjcmV0LWtleS1mb3ItdGVzdHM="
+
+	// Update of example.com. replacing home.example.com. A by 203.0.113.7 (TTL 300), ID 0x1234
+	updateHex = "123428000001000000020000076578616d706c6503636f6d0000060001" +
+		"04686f6d65076578616d706c6503636f6d00000100ff0000000000" +
+		"0004686f6d65076578616d706c6503636f6d00000100010000012c0004cb007107"
+
+	// The update signed at 1700000000 (fudge 300)
+	signedHex = "123428000001000000020001076578616d706c6503636f6d0000060001" +
+		"04686f6d65076578616d706c6503636f6d00000100ff0000000000" +
+		"0004686f6d65076578616d706c6503636f6d00000100010000012c0004cb007107" +
+		"0a7570646174652d6b65790000fa00ff00000000003d0b686d61632d7368613235360000006553f100012c0020" +
+		"504e252e3093d415000c9c09a1feb2c7f77924179c36910e3b7d8023d164cc89123400000000"
+	requestMACHex = "504e252e3093d415000c9c09a1feb2c7f77924179c36910e3b7d8023d164cc89"
+
+	// NOERROR response signed at 1700000001 (fudge 300), its MAC chaining the request MAC
+	responseHex = "1234a8000001000000000001076578616d706c6503636f6d0000060001" +
+		"0a7570646174652d6b65790000fa00ff00000000003d0b686d61632d7368613235360000006553f101012c0020" +
+		"fef3cff206906a72a121fbbee534af9e365481c0dd856759b3c7c6030e11fdd0123400000000"
+
+	// REFUSED response signed at 1700000002 with a fudge of 60
+	refusedHex = "1234a8050001000000000001076578616d706c6503636f6d0000060001" +
+		"0a7570646174652d6b65790000fa00ff00000000003d0b686d61632d7368613235360000006553f102003c0020" +
+		"b7b09a4f1e438e628af3234365eede7866e194a1dfbdeca6e649e77b9c17706a123400000000"
+)
+
+// signedAt is the time the request was signed at
+var signedAt = time.Unix(1700000000, 0)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func testKey(t *testing.T, secret string) *key {
+	t.Helper()
+	k, err := newKey(testKeyName, "HMAC-SHA256.", secret)
+	if err != nil {
+		t.Fatalf("newKey: %v", err)
+	}
+	return k
+}
+
+func TestUpdateMessage(t *testing.T) {
+	addr := netip.MustParseAddr("203.0.113.7")
+	msg, err := updateMessage(0x1234, "example.com", "home.example.com", dnswire.TypeA, 300, addr.AsSlice())
+	if err != nil {
+		t.Fatalf("updateMessage: %v", err)
+	}
+	if want := mustHex(t, updateHex); !bytes.Equal(msg, want) {
+		t.Errorf("updateMessage =\n%x\nwant\n%x", msg, want)
+	}
+}
+
+func TestSign(t *testing.T) {
+	signed, mac, err := testKey(t, testSecret).sign(mustHex(t, updateHex), signedAt)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if want := mustHex(t, signedHex); !bytes.Equal(signed, want) {
+		t.Errorf("signed message =\n%x\nwant\n%x", signed, want)
+	}
+	if want := mustHex(t, requestMACHex); !bytes.Equal(mac, want) {
+		t.Errorf("request MAC = %x, want %x", mac, want)
+	}
+
+	record, err := lastAdditional(signed)
+	if err != nil || record == nil || record.Type != dnswire.TypeTSIG || record.Name != testKeyName+"." {
+		t.Errorf("last additional record = %+v, %v, want the TSIG record of %s.", record, err, testKeyName)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	requestMAC := mustHex(t, requestMACHex)
+
+	tests := []struct {
+		name       string
+		response   string
+		modify     func(resp []byte) []byte // Alters the response, if set
+		secret     string                   // testSecret if empty
+		requestMAC []byte                   // MAC of the request, requestMAC if nil
+		now        time.Time
+		want       error  // Expected error, nil for a valid response
+		wantText   string // Expected in the error message
+	}{
+		{
+			name:     "valid",
+			response: responseHex,
+			now:      signedAt.Add(time.Second),
+		},
+		{
+			name:     "valid with the fudge of the server",
+			response: refusedHex,
+			now:      signedAt.Add(61 * time.Second),
+		},
+		{
+			name:       "MAC of another request",
+			response:   responseHex,
+			requestMAC: bytes.Repeat([]byte{0x42}, 32),
+			now:        signedAt,
+			want:       ErrBadResponseMAC,
+		},
+		{
+			name:     "another secret",
+			response: responseHex,
+			secret:   "b3RoZXItc2VjcmV0",
+			now:      signedAt,
+			want:     ErrBadResponseMAC,
+		},
+		{
+			name:     "altered response",
+			response: responseHex,
+			modify:   func(resp []byte) []byte { resp[3] = 0x05; return resp }, // NOERROR -> REFUSED
+			now:      signedAt,
+			want:     ErrBadResponseMAC,
+		},
+		{
+			name:     "valid at the edge of the fudge",
+			response: responseHex,
+			now:      signedAt.Add(301 * time.Second),
+		},
+		{
+			name:     "local clock behind",
+			response: responseHex,
+			now:      signedAt.Add(-300 * time.Second),
+			want:     ErrBadResponseMAC,
+			wantText: "away from the local clock",
+		},
+		{
+			name:     "local clock ahead",
+			response: responseHex,
+			now:      signedAt.Add(302 * time.Second),
+			want:     ErrBadResponseMAC,
+			wantText: "away from the local clock",
+		},
+		{
+			name:     "beyond the fudge of the server",
+			response: refusedHex,
+			now:      signedAt.Add(63 * time.Second),
+			want:     ErrBadResponseMAC,
+			wantText: "away from the local clock",
+		},
+		{
+			name:     "signed with another key",
+			response: responseHex,
+			modify:   func(resp []byte) []byte { return bytes.Replace(resp, []byte("update-key"), []byte("other--key"), 1) },
+			now:      signedAt,
+			want:     ErrBadResponseMAC,
+			wantText: "another key",
+		},
+		{
+			name:     "unsigned",
+			response: responseHex,
+			modify: func(resp []byte) []byte {
+				record, _ := lastAdditional(resp)
+				resp = resp[:record.Start]
+				binary.BigEndian.PutUint16(resp[10:], 0)
+				return resp
+			},
+			now:  signedAt,
+			want: ErrUnsignedResponse,
+		},
+		{
+			name:     "TSIG error of the server",
+			response: responseHex,
+			modify: func(resp []byte) []byte {
+				binary.BigEndian.PutUint16(resp[len(resp)-4:], 16)
+				return resp
+			},
+			now:      signedAt,
+			wantText: "BADSIG",
+		},
+		{
+			name:     "MAC past the end of the record",
+			response: responseHex,
+			modify: func(resp []byte) []byte {
+				binary.BigEndian.PutUint16(resp[len(resp)-6-32-2:], 0x00ff)
+				return resp
+			},
+			now:  signedAt,
+			want: dnswire.ErrMalformed,
+		},
+		{
+			name:     "other data past the end of the record",
+			response: responseHex,
+			modify: func(resp []byte) []byte {
+				binary.BigEndian.PutUint16(resp[len(resp)-2:], 6)
+				return resp
+			},
+			now:  signedAt,
+			want: dnswire.ErrMalformed,
+		},
+		{
+			name:     "truncated record",
+			response: responseHex,
+			modify:   func(resp []byte) []byte { return resp[:len(resp)-1] },
+			now:      signedAt,
+			want:     dnswire.ErrMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := mustHex(t, tt.response)
+			if tt.modify != nil {
+				resp = tt.modify(resp)
+			}
+			secret := tt.secret
+			if secret == "" {
+				secret = testSecret
+			}
+			mac := tt.requestMAC
+			if mac == nil {
+				mac = requestMAC
+			}
+
+			err := testKey(t, secret).verify(resp, mac, tt.now)
+
+			switch {
+			case tt.want == nil && tt.wantText == "":
+				if err != nil {
+					t.Fatalf("verify = %v, want a valid response", err)
+				}
+			case tt.want != nil && !errors.Is(err, tt.want):
+				t.Fatalf("verify = %v, want %v", err, tt.want)
+			case err == nil || !strings.Contains(err.Error(), tt.wantText):
+				t.Fatalf("verify = %v, want an error mentioning %q", err, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	tests := []struct {
+		algorithm, secret string
+		valid             bool
+	}{
+		{algorithm: "", secret: testSecret, valid: true},
+		{algorithm: "hmac-sha512.", secret: testSecret, valid: true},
+		{algorithm: "hmac-md5", secret: testSecret},
+		{algorithm: "hmac-sha256", secret: "not base64!"},
+	}
+
+	for _, tt := range tests {
+		k, err := newKey("Update-Key", tt.algorithm, tt.secret)
+		if (err == nil) != tt.valid {
+			t.Errorf("newKey(%q, %q) = %v, want valid = %v", tt.algorithm, tt.secret, err, tt.valid)
+			continue
+		}
+		if tt.valid && (k.name != "update-key." || !strings.HasSuffix(k.algorithm, ".")) {
+			t.Errorf("newKey(%q) = %s %s, want absolute lowercase names", tt.algorithm, k.name, k.algorithm)
+		}
+	}
+}
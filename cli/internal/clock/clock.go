@@ -0,0 +1,127 @@
+// Package clock abstracts time, so that time-dependent code can be tested deterministically with Fake.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock gives the current time and creates tickers
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals, like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// Real is the clock of the system
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t *realTicker) C() <-chan time.Time   { return t.ticker.C }
+func (t *realTicker) Reset(d time.Duration) { t.ticker.Reset(d) }
+func (t *realTicker) Stop()                 { t.ticker.Stop() }
+
+// Fake is a clock only moving forward when told to
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFake creates a fake clock set at start
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now returns the current fake time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// NewTicker creates a ticker firing when the fake time is advanced past its deadlines
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTicker{
+		clock:  f,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   f.now.Add(d),
+	}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// Advance moves the fake time forward, firing the tickers whose deadlines are reached, in order.
+// Like time.Ticker, ticks are dropped when the receiver is not keeping up.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	target := f.now.Add(d)
+
+	for {
+		// Fire the earliest deadline first
+		sort.Slice(f.tickers, func(i, j int) bool { return f.tickers[i].next.Before(f.tickers[j].next) })
+		if len(f.tickers) == 0 || f.tickers[0].next.After(target) {
+			break
+		}
+
+		t := f.tickers[0]
+		f.now = t.next
+		t.next = t.next.Add(t.period)
+		select {
+		case t.c <- f.now:
+		default:
+		}
+	}
+
+	f.now = target
+	f.mu.Unlock()
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.period = d
+	t.next = t.clock.now.Add(d)
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, other := range t.clock.tickers {
+		if other == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package cloudflare updates DNS records directly through the Cloudflare API (v4), bypassing the
+// PierceFlare server. It is used as a fallback while the server cannot be reached.
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DefaultAPIURL is the base URL of the Cloudflare API
+const DefaultAPIURL = "https://api.cloudflare.com/client/v4"
+
+// maxResponseSize bounds the answers read from the API
+const maxResponseSize = 1 << 20
+
+// ErrNoZone is returned when no zone of the account matches the record name
+var ErrNoZone = errors.New("no Cloudflare zone found for the record")
+
+// Options configures the access to the Cloudflare API
+type Options struct {
+	Token  string // API token, scoped to Zone.DNS:Edit (and Zone.Zone:Read if ZoneID is empty)
+	ZoneID string // Zone of the record, looked up from the record name if empty
+	Record string // Name of the record, the domain bound to the PierceFlare API token if empty
+	APIURL string // Base URL of the API (DefaultAPIURL if empty), e.g. a local stand-in for tests
+}
+
+// Error is a failure reported by the Cloudflare API
+type Error struct {
+	Op         string // Operation that failed (e.g. "zone lookup")
+	StatusCode int    // HTTP status code
+	Messages   []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("Cloudflare %s failed (HTTP %d)", e.Op, e.StatusCode)
+	}
+	return fmt.Sprintf("Cloudflare %s failed (HTTP %d): %s", e.Op, e.StatusCode, strings.Join(e.Messages, "; "))
+}
+
+// Record is a DNS record, as described by the API
+type Record struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+	Proxied *bool  `json:"proxied,omitempty"`
+}
+
+// Client updates records through the Cloudflare API
+type Client struct {
+	opts       Options
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	zones map[string]string // Record name -> zone ID, looked up once
+}
+
+// NewClient creates a Client sending requests through httpClient (http.DefaultClient if nil)
+func NewClient(opts Options, httpClient *http.Client) *Client {
+	if opts.APIURL == "" {
+		opts.APIURL = DefaultAPIURL
+	}
+	opts.APIURL = strings.TrimRight(opts.APIURL, "/")
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{opts: opts, httpClient: httpClient, zones: map[string]string{}}
+}
+
+// RecordName returns the name of the record to update: the configured one, or domain
+func (c *Client) RecordName(domain string) string {
+	if c.opts.Record != "" {
+		return c.opts.Record
+	}
+	return domain
+}
+
+// Update points the record name of the family of addr (A or AAAA) to addr, creating it if needed.
+// It reports whether the record changed.
+func (c *Client) Update(ctx context.Context, name string, addr netip.Addr) (bool, error) {
+	name = strings.TrimSuffix(name, ".")
+	recordType := "A"
+	if addr.Is6() {
+		recordType = "AAAA"
+	}
+
+	zoneID, err := c.zoneID(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	var records []Record
+	query := url.Values{"type": {recordType}, "name": {name}}
+	if err := c.do(ctx, "record lookup", http.MethodGet, "/zones/"+zoneID+"/dns_records", query, nil, &records); err != nil {
+		return false, err
+	}
+
+	if len(records) == 0 {
+		// Proxying an address flared by a dynamic DNS client is never intended
+		proxied := false
+		record := Record{Type: recordType, Name: name, Content: addr.String(), TTL: 1, Proxied: &proxied}
+		return true, c.do(ctx, "record creation", http.MethodPost, "/zones/"+zoneID+"/dns_records", nil, record, nil)
+	}
+
+	record := records[0]
+	if record.Content == addr.String() {
+		return false, nil
+	}
+
+	patch := map[string]string{"content": addr.String()}
+	return true, c.do(ctx, "record update", http.MethodPatch, "/zones/"+zoneID+"/dns_records/"+record.ID, nil, patch, nil)
+}
+
+// zoneID returns the zone of a record: the configured one, or the closest enclosing zone of the account
+func (c *Client) zoneID(ctx context.Context, name string) (string, error) {
+	if c.opts.ZoneID != "" {
+		return c.opts.ZoneID, nil
+	}
+
+	c.mu.Lock()
+	id, ok := c.zones[name]
+	c.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	// home.example.com may be a zone itself, or belong to example.com
+	for candidate := name; strings.Contains(candidate, "."); _, candidate, _ = strings.Cut(candidate, ".") {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := c.do(ctx, "zone lookup", http.MethodGet, "/zones", url.Values{"name": {candidate}}, nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			c.mu.Lock()
+			c.zones[name] = zones[0].ID
+			c.mu.Unlock()
+			return zones[0].ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrNoZone, name)
+}
+
+// envelope is the wrapper of every answer of the API
+type envelope struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// do calls the API, decoding the result of the answer into result (if not nil)
+func (c *Client) do(ctx context.Context, op, method, path string, query url.Values, body, result any) error {
+	endpoint := c.opts.APIURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cloudflare %s failed: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	var env envelope
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&env)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || decodeErr != nil || !env.Success {
+		apiErr := &Error{Op: op, StatusCode: resp.StatusCode}
+		for _, e := range env.Errors {
+			apiErr.Messages = append(apiErr.Messages, fmt.Sprintf("%s (code %d)", e.Message, e.Code))
+		}
+		if decodeErr != nil {
+			apiErr.Messages = append(apiErr.Messages, "invalid answer: "+decodeErr.Error())
+		}
+		return apiErr
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(env.Result, result); err != nil {
+			return &Error{Op: op, StatusCode: resp.StatusCode, Messages: []string{"invalid result: " + err.Error()}}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package cloudflare_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/qalisa/pierceflare/cli/internal/cloudflare"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+const testToken = "cf-token"
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name         string
+		zoneID       bool   // Configure the zone ID instead of looking it up
+		existing     string // Content of the A record before the update, none if empty
+		addr         string
+		wantType     string
+		wantChanged  bool
+		wantRequests int // Zone lookups (home.example.com, then example.com), record lookup and change
+	}{
+		{name: "create", addr: "93.184.216.34", wantType: "A", wantChanged: true, wantRequests: 4},
+		{name: "update", existing: "93.184.216.35", addr: "93.184.216.34", wantType: "A", wantChanged: true, wantRequests: 4},
+		{name: "unchanged", existing: "93.184.216.34", addr: "93.184.216.34", wantType: "A", wantRequests: 3},
+		{name: "IPv6", existing: "93.184.216.34", addr: "2606:2800:220:1::248", wantType: "AAAA", wantChanged: true, wantRequests: 4},
+		{name: "configured zone", zoneID: true, addr: "93.184.216.34", wantType: "A", wantChanged: true, wantRequests: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := testserver.NewCloudflare(testToken, "example.com")
+			defer cf.Close()
+			if tt.existing != "" {
+				cf.SetRecord("A", "home.example.com", tt.existing)
+			}
+
+			opts := cloudflare.Options{Token: testToken, APIURL: cf.APIURL()}
+			if tt.zoneID {
+				opts.ZoneID = cf.ZoneID("example.com")
+			}
+			client := cloudflare.NewClient(opts, nil)
+
+			changed, err := client.Update(context.Background(), "home.example.com.", netip.MustParseAddr(tt.addr))
+			if err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if got := cf.Requests(); got != tt.wantRequests {
+				t.Errorf("%d requests, want %d", got, tt.wantRequests)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if content, _ := cf.Record(tt.wantType, "home.example.com"); content != tt.addr {
+				t.Errorf("%s record = %q, want %s", tt.wantType, content, tt.addr)
+			}
+			if tt.wantType == "AAAA" {
+				if content, _ := cf.Record("A", "home.example.com"); content != tt.existing {
+					t.Errorf("A record = %q, want %s left alone", content, tt.existing)
+				}
+			}
+
+			// The zone is looked up once
+			requests := cf.Requests()
+			if _, err := client.Update(context.Background(), "home.example.com", netip.MustParseAddr(tt.addr)); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if got := cf.Requests() - requests; got != 1 {
+				t.Errorf("%d requests for an unchanged record with the zone known, want 1", got)
+			}
+		})
+	}
+}
+
+func TestUpdateErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		record     string
+		status     int // Status of every answer
+		wantStatus int // Status of the *cloudflare.Error, 0 for ErrNoZone
+	}{
+		{name: "no zone", token: testToken, record: "home.example.net"},
+		{name: "invalid token", token: "other", record: "home.example.com", wantStatus: http.StatusForbidden},
+		{name: "server error", token: testToken, record: "home.example.com", status: http.StatusInternalServerError, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf := testserver.NewCloudflare(testToken, "example.com")
+			defer cf.Close()
+			cf.SetStatus(tt.status)
+
+			client := cloudflare.NewClient(cloudflare.Options{Token: tt.token, APIURL: cf.APIURL()}, nil)
+			_, err := client.Update(context.Background(), tt.record, netip.MustParseAddr("93.184.216.34"))
+
+			var apiErr *cloudflare.Error
+			switch {
+			case tt.wantStatus == 0:
+				if !errors.Is(err, cloudflare.ErrNoZone) {
+					t.Errorf("Update = %v, want ErrNoZone", err)
+				}
+			case !errors.As(err, &apiErr):
+				t.Errorf("Update = %v, want a *cloudflare.Error", err)
+			case apiErr.StatusCode != tt.wantStatus || len(apiErr.Messages) == 0:
+				t.Errorf("error = HTTP %d %q, want HTTP %d with the messages of the API", apiErr.StatusCode, apiErr.Messages, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRecordName(t *testing.T) {
+	client := cloudflare.NewClient(cloudflare.Options{}, nil)
+	if got := client.RecordName("home.example.com"); got != "home.example.com" {
+		t.Errorf("RecordName = %q, want the domain bound to the token", got)
+	}
+	client = cloudflare.NewClient(cloudflare.Options{Record: "vpn.example.com"}, nil)
+	if got := client.RecordName("home.example.com"); got != "vpn.example.com" {
+		t.Errorf("RecordName = %q, want the configured record", got)
+	}
+}
@@ -1,13 +1,17 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/schedule"
+	"github.com/qalisa/pierceflare/cli/internal/transport"
 )
 
 const (
@@ -15,40 +19,181 @@ const (
 	MinCheckInterval = 10
 	// DefaultCheckInterval est l'intervalle par défaut de vérification en secondes
 	DefaultCheckInterval = 300 // 5 minutes
+	// DefaultAPITimeout est le délai maximal par défaut d'un appel au serveur PierceFlare, en secondes
+	DefaultAPITimeout = 10
+	// DefaultDetectTimeout est le délai maximal par défaut d'un appel à un service de détection d'IP, en secondes
+	DefaultDetectTimeout = 5
+	// DefaultFallbackAfter est le nombre par défaut de flares échoués d'affilée (serveur indisponible)
+	// avant la mise à jour directe via Cloudflare
+	DefaultFallbackAfter = 3
+	// DefaultRFC2136TTL est le TTL par défaut des enregistrements mis à jour par RFC 2136, en secondes
+	DefaultRFC2136TTL = 300
+	// DefaultVerifyTimeout est le délai par défaut laissé à l'enregistrement DNS pour servir l'adresse envoyée, en secondes
+	DefaultVerifyTimeout = 600 // 10 minutes
+	// DefaultDynDNSListen est l'adresse d'écoute par défaut du relais dyndns2 (commande serve-dyndns)
+	DefaultDynDNSListen = ":8245"
+	// DefaultControlSocketName est le nom du socket de contrôle, dans le répertoire d'exécution
+	DefaultControlSocketName = "control.sock"
+	// DefaultRevalidateInterval est l'intervalle par défaut de revalidation d'un jeton refusé, en secondes
+	DefaultRevalidateInterval = 900 // 15 minutes
 )
 
+// ErrMissingAPIKey indique qu'aucun jeton API n'est configuré
+var ErrMissingAPIKey = errors.New("jeton API manquant")
+
 // Config contient la configuration de l'application
 type Config struct {
-	APIKey        string
-	ServerURL     string
-	CheckInterval time.Duration
-	OneShotMode   bool
-	LogTimestamp  bool
-	LogLevel      logger.LogLevel
-	SuccessPeriod int  // Nombre d'exécutions réussies entre chaque log de succès (0 = log chaque succès)
-	DummyUpdates  bool // Envoyer des mises à jour même si l'IP n'a pas changé
+	APIKey         string
+	ServerURL      string
+	ExpectedDomain string // Domaine auquel le jeton doit être associé (vide = pas de vérification)
+	CheckInterval  time.Duration
+	OneShotMode    bool
+	LogTimestamp   bool
+	LogLevel       logger.LogLevel
+	SuccessPeriod  int  // Nombre d'exécutions réussies entre chaque log de succès (0 = log chaque succès)
+	DummyUpdates   bool // Envoyer des mises à jour même si l'IP n'a pas changé
+	RefuseCGNAT    bool // Refuser les mises à jour lorsque l'hôte est derrière un CGNAT
+	SignRequests   bool // Signer les requêtes (HMAC) pour les serveurs vérifiant les signatures
+
+	Schedule   string        // Expression cron donnant l'heure des vérifications (vide = toutes les CheckInterval)
+	StartDelay time.Duration // Délai aléatoire maximal avant la première vérification (0 = immédiate)
+	Jitter     time.Duration // Délai aléatoire maximal ajouté à chaque vérification (0 = aucun)
+	QuietHours string        // Plages horaires quotidiennes sans vérification, ex. "22:00-06:00,12:00-13:00"
+
+	RevalidateInterval time.Duration // Intervalle de revalidation du jeton lorsque le serveur le refuse
+	NotifyURL          string        // Webhook recevant les notifications (vide = désactivé)
+	HealthFile         string        // Fichier présent uniquement lorsque le client est en bonne santé (vide = désactivé)
+	MetricsAddr        string        // Adresse d'écoute des métriques Prometheus (vide = désactivé, sauf socket systemd)
+	QueueFile          string        // Fichier conservant les flares en attente du serveur entre deux redémarrages (vide = en mémoire)
+	ControlSocket      string        // Socket Unix de l'API de contrôle du mode continu (vide = désactivé)
+
+	VerifyPropagation bool          // Vérifier que les résolveurs servent l'adresse envoyée
+	VerifyResolvers   []string      // Résolveurs interrogés (vide = serveurs faisant autorité, 1.1.1.1 et DoH Cloudflare)
+	VerifyTimeout     time.Duration // Délai laissé à l'enregistrement pour converger avant l'alerte
+
+	DNSPrecheck         bool   // Ne pas envoyer l'adresse si l'enregistrement DNS la sert déjà (redémarrages)
+	DNSPrecheckResolver string // Résolveur consulté (vide = serveurs faisant autorité)
+
+	Proxy         string        // Proxy HTTP(S) ou SOCKS5 utilisé pour toutes les requêtes
+	SourceAddress string        // Adresse IP locale à laquelle lier les connexions sortantes
+	Interface     string        // Interface réseau à laquelle lier les connexions sortantes (Linux uniquement)
+	CAFile        string        // Autorités de certification supplémentaires (PEM)
+	ClientCert    string        // Certificat client pour le mTLS vers le serveur PierceFlare (PEM)
+	ClientKey     string        // Clé privée du certificat client (PEM)
+	APITimeout    time.Duration // Délai maximal d'un appel au serveur PierceFlare
+	DetectTimeout time.Duration // Délai maximal d'un appel à un service de détection d'IP
+	HedgeDelay    time.Duration // Attente avant d'interroger aussi le service de détection suivant (0 = un service à la fois)
+
+	CloudflareToken  string // Jeton Cloudflare (Zone.DNS:Edit) de la mise à jour directe lorsque le serveur est indisponible (vide = désactivé)
+	CloudflareZoneID string // Zone de l'enregistrement (vide = recherchée à partir du nom, nécessite Zone.Zone:Read)
+	CloudflareRecord string // Enregistrement mis à jour (vide = domaine associé au jeton PierceFlare)
+	CloudflareAPIURL string // URL de l'API Cloudflare (vide = API publique)
+	FallbackAfter    int    // Nombre de flares échoués d'affilée avant la mise à jour directe
+
+	RFC2136Server       string        // Serveur primaire recevant les mises à jour dynamiques RFC 2136 (vide = désactivé)
+	RFC2136Zone         string        // Zone de l'enregistrement
+	RFC2136Record       string        // Enregistrement mis à jour
+	RFC2136TTL          time.Duration // TTL de l'enregistrement
+	RFC2136KeyName      string        // Nom de la clé TSIG (vide = mises à jour non signées)
+	RFC2136KeySecret    string        // Secret de la clé TSIG (base64)
+	RFC2136KeyAlgorithm string        // Algorithme de la clé TSIG (par défaut: hmac-sha256)
+
+	DynDNS2URL      string // Point d'entrée dyndns2 (/nic/update) (vide = désactivé)
+	DynDNS2Username string
+	DynDNS2Password string
+	DynDNS2Hostname string // Hôte mis à jour
+
+	DynDNSListen  string // Adresse d'écoute du relais dyndns2 pour les routeurs (commande serve-dyndns)
+	DynDNSTLSCert string // Certificat TLS du relais (PEM, vide = HTTP en clair)
+	DynDNSTLSKey  string // Clé privée du certificat TLS du relais (PEM)
+
+	Uplinks []Uplink // Liens Internet suivis indépendamment (vide = un seul lien, configuré globalement)
 }
 
 // New crée une nouvelle configuration à partir des variables d'environnement
 func New() (*Config, error) {
-	cfg := &Config{
-		APIKey:       os.Getenv("PIERCEFLARE_API_KEY"),
-		ServerURL:    os.Getenv("PIERCEFLARE_SERVER_URL"),
-		LogTimestamp: true,
-		OneShotMode:  os.Getenv("PIERCEFLARE_ONE_SHOT") == "true",
-		LogLevel:     logger.LogLevelInfo,                              // Par défaut, niveau INFO
-		DummyUpdates: os.Getenv("PIERCEFLARE_DUMMY_UPDATES") == "true", // Par défaut désactivé
+	cfg, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// En mode multi-WAN, chaque uplink porte son propre jeton
+	if len(cfg.Uplinks) > 0 {
+		// Les backends supplémentaires ne désignent qu'un enregistrement, que les uplinks se disputeraient
+		if cfg.RFC2136Server != "" || cfg.DynDNS2URL != "" {
+			return nil, fmt.Errorf("les backends RFC 2136 et dyndns2 ne sont pas pris en charge avec PIERCEFLARE_UPLINKS")
+		}
+
+		if err := validateUplinks(cfg); err != nil {
+			return nil, err
+		}
+		return cfg, nil
 	}
 
 	// Vérification des variables obligatoires
 	if cfg.APIKey == "" {
-		return nil, fmt.Errorf("la variable d'environnement PIERCEFLARE_API_KEY n'est pas définie")
+		return nil, fmt.Errorf("%w: la variable d'environnement PIERCEFLARE_API_KEY n'est pas définie", ErrMissingAPIKey)
 	}
 
 	if cfg.ServerURL == "" {
 		return nil, fmt.Errorf("la variable d'environnement PIERCEFLARE_SERVER_URL n'est pas définie")
 	}
 
+	return cfg, nil
+}
+
+// Load lit la configuration à partir des variables d'environnement, sans exiger les variables
+// nécessaires à la communication avec le serveur (utilisé par les commandes locales comme `detect`)
+func Load() (*Config, error) {
+	cfg := &Config{
+		APIKey:         os.Getenv("PIERCEFLARE_API_KEY"),
+		ServerURL:      os.Getenv("PIERCEFLARE_SERVER_URL"),
+		ExpectedDomain: os.Getenv("PIERCEFLARE_EXPECTED_DOMAIN"),
+		LogTimestamp:   true,
+		OneShotMode:    os.Getenv("PIERCEFLARE_ONE_SHOT") == "true",
+		LogLevel:       logger.LogLevelInfo,                              // Par défaut, niveau INFO
+		DummyUpdates:   os.Getenv("PIERCEFLARE_DUMMY_UPDATES") == "true", // Par défaut désactivé
+		RefuseCGNAT:    os.Getenv("PIERCEFLARE_REFUSE_CGNAT") == "true",  // Par défaut, simple avertissement
+		NotifyURL:      os.Getenv("PIERCEFLARE_NOTIFY_URL"),
+		HealthFile:     os.Getenv("PIERCEFLARE_HEALTH_FILE"),
+		MetricsAddr:    os.Getenv("PIERCEFLARE_METRICS_ADDR"),
+		QueueFile:      os.Getenv("PIERCEFLARE_QUEUE_FILE"),
+		ControlSocket:  os.Getenv("PIERCEFLARE_CONTROL_SOCKET"),
+
+		VerifyPropagation: os.Getenv("PIERCEFLARE_VERIFY_PROPAGATION") == "true",
+
+		DNSPrecheck:         os.Getenv("PIERCEFLARE_DNS_PRECHECK") == "true",
+		DNSPrecheckResolver: os.Getenv("PIERCEFLARE_DNS_PRECHECK_RESOLVER"),
+
+		CloudflareToken:  os.Getenv("PIERCEFLARE_CLOUDFLARE_TOKEN"),
+		CloudflareZoneID: os.Getenv("PIERCEFLARE_CLOUDFLARE_ZONE_ID"),
+		CloudflareRecord: os.Getenv("PIERCEFLARE_CLOUDFLARE_RECORD"),
+		CloudflareAPIURL: os.Getenv("PIERCEFLARE_CLOUDFLARE_API_URL"),
+
+		RFC2136Server:       os.Getenv("PIERCEFLARE_RFC2136_SERVER"),
+		RFC2136Zone:         os.Getenv("PIERCEFLARE_RFC2136_ZONE"),
+		RFC2136Record:       os.Getenv("PIERCEFLARE_RFC2136_RECORD"),
+		RFC2136KeyName:      os.Getenv("PIERCEFLARE_RFC2136_KEY_NAME"),
+		RFC2136KeySecret:    os.Getenv("PIERCEFLARE_RFC2136_KEY_SECRET"),
+		RFC2136KeyAlgorithm: os.Getenv("PIERCEFLARE_RFC2136_KEY_ALGORITHM"),
+
+		DynDNS2URL:      os.Getenv("PIERCEFLARE_DYNDNS2_URL"),
+		DynDNS2Username: os.Getenv("PIERCEFLARE_DYNDNS2_USERNAME"),
+		DynDNS2Password: os.Getenv("PIERCEFLARE_DYNDNS2_PASSWORD"),
+		DynDNS2Hostname: os.Getenv("PIERCEFLARE_DYNDNS2_HOSTNAME"),
+
+		DynDNSListen:  os.Getenv("PIERCEFLARE_DYNDNS_LISTEN"),
+		DynDNSTLSCert: os.Getenv("PIERCEFLARE_DYNDNS_TLS_CERT"),
+		DynDNSTLSKey:  os.Getenv("PIERCEFLARE_DYNDNS_TLS_KEY"),
+
+		Proxy:         os.Getenv("PIERCEFLARE_PROXY"),
+		SourceAddress: os.Getenv("PIERCEFLARE_SOURCE_ADDRESS"),
+		Interface:     os.Getenv("PIERCEFLARE_INTERFACE"),
+		CAFile:        os.Getenv("PIERCEFLARE_CA_FILE"),
+		ClientCert:    os.Getenv("PIERCEFLARE_CLIENT_CERT"),
+		ClientKey:     os.Getenv("PIERCEFLARE_CLIENT_KEY"),
+	}
+
 	// Lecture de l'intervalle de vérification
 	checkIntervalStr := os.Getenv("PIERCEFLARE_CHECK_INTERVAL")
 	if checkIntervalStr == "" {
@@ -62,13 +207,30 @@ func New() (*Config, error) {
 
 	// Vérification que l'intervalle n'est pas inférieur au minimum requis
 	if checkIntervalSec < MinCheckInterval {
-		fmt.Printf("[PierceFlare CLI] - Avertissement: Intervalle de vérification (%d s) inférieur au minimum recommandé. Utilisation de %d secondes.\n",
+		fmt.Fprintf(os.Stderr, "[PierceFlare CLI] - Avertissement: Intervalle de vérification (%d s) inférieur au minimum recommandé. Utilisation de %d secondes.\n",
 			checkIntervalSec, MinCheckInterval)
 		checkIntervalSec = MinCheckInterval
 	}
 
 	cfg.CheckInterval = time.Duration(checkIntervalSec) * time.Second
 
+	// Planification des vérifications: expression cron, délais aléatoires et plages silencieuses
+	if cfg.Schedule = strings.TrimSpace(os.Getenv("PIERCEFLARE_SCHEDULE")); cfg.Schedule != "" {
+		if _, err := schedule.ParseCron(cfg.Schedule); err != nil {
+			return nil, fmt.Errorf("valeur invalide pour PIERCEFLARE_SCHEDULE: %w", err)
+		}
+	}
+	if cfg.StartDelay, err = readDelay("PIERCEFLARE_START_DELAY"); err != nil {
+		return nil, err
+	}
+	if cfg.Jitter, err = readDelay("PIERCEFLARE_JITTER"); err != nil {
+		return nil, err
+	}
+	cfg.QuietHours = os.Getenv("PIERCEFLARE_QUIET_HOURS")
+	if _, err := schedule.New(cfg.ScheduleOptions()); err != nil {
+		return nil, scheduleError(err)
+	}
+
 	// Configuration du niveau de log
 	logLevelStr := strings.ToLower(os.Getenv("PIERCEFLARE_LOG_LEVEL"))
 	switch logLevelStr {
@@ -96,5 +258,190 @@ func New() (*Config, error) {
 		cfg.SuccessPeriod = successPeriod
 	}
 
+	// Lecture de l'intervalle de revalidation du jeton
+	revalidateStr := os.Getenv("PIERCEFLARE_REVALIDATE_INTERVAL")
+	if revalidateStr == "" {
+		revalidateStr = strconv.Itoa(DefaultRevalidateInterval)
+	}
+
+	revalidateSec, err := strconv.Atoi(revalidateStr)
+	if err != nil {
+		return nil, fmt.Errorf("intervalle de revalidation invalide: %w", err)
+	}
+
+	// La revalidation ne doit pas solliciter le serveur plus souvent que les vérifications
+	if revalidateSec < MinCheckInterval {
+		revalidateSec = MinCheckInterval
+	}
+
+	cfg.RevalidateInterval = time.Duration(revalidateSec) * time.Second
+
+	// Lecture des délais maximaux des appels HTTP
+	if cfg.APITimeout, err = readSeconds("PIERCEFLARE_API_TIMEOUT", DefaultAPITimeout); err != nil {
+		return nil, err
+	}
+
+	if cfg.DetectTimeout, err = readSeconds("PIERCEFLARE_DETECT_TIMEOUT", DefaultDetectTimeout); err != nil {
+		return nil, err
+	}
+
+	// Requêtes concurrentes aux services de détection, désactivées par défaut
+	if hedgeStr := os.Getenv("PIERCEFLARE_DETECT_HEDGE_DELAY"); hedgeStr != "" {
+		hedge, err := strconv.Atoi(hedgeStr)
+		if err != nil || hedge < 0 {
+			return nil, fmt.Errorf("valeur invalide pour PIERCEFLARE_DETECT_HEDGE_DELAY: %s (nombre de millisecondes attendu)", hedgeStr)
+		}
+		cfg.HedgeDelay = time.Duration(hedge) * time.Millisecond
+	}
+
+	// Lecture du seuil de bascule vers Cloudflare
+	cfg.FallbackAfter = DefaultFallbackAfter
+	if fallbackAfterStr := os.Getenv("PIERCEFLARE_FALLBACK_AFTER"); fallbackAfterStr != "" {
+		cfg.FallbackAfter, err = strconv.Atoi(fallbackAfterStr)
+		if err != nil || cfg.FallbackAfter <= 0 {
+			return nil, fmt.Errorf("valeur invalide pour PIERCEFLARE_FALLBACK_AFTER: %s (nombre de flares attendu)", fallbackAfterStr)
+		}
+	}
+
+	// Lecture des résolveurs et du délai de la vérification de propagation
+	for _, resolver := range strings.Split(os.Getenv("PIERCEFLARE_VERIFY_RESOLVERS"), ",") {
+		if resolver = strings.TrimSpace(resolver); resolver != "" {
+			cfg.VerifyResolvers = append(cfg.VerifyResolvers, resolver)
+		}
+	}
+
+	if cfg.VerifyTimeout, err = readSeconds("PIERCEFLARE_VERIFY_TIMEOUT", DefaultVerifyTimeout); err != nil {
+		return nil, err
+	}
+
+	// Lecture du TTL des mises à jour RFC 2136
+	if cfg.RFC2136TTL, err = readSeconds("PIERCEFLARE_RFC2136_TTL", DefaultRFC2136TTL); err != nil {
+		return nil, err
+	}
+
+	// Socket de contrôle, désactivé avec "off"
+	switch cfg.ControlSocket {
+	case "":
+		cfg.ControlSocket = defaultControlSocket()
+	case "off":
+		cfg.ControlSocket = ""
+	}
+
+	// Adresse d'écoute du relais dyndns2
+	if cfg.DynDNSListen == "" {
+		cfg.DynDNSListen = DefaultDynDNSListen
+	}
+	if (cfg.DynDNSTLSCert == "") != (cfg.DynDNSTLSKey == "") {
+		return nil, fmt.Errorf("PIERCEFLARE_DYNDNS_TLS_CERT et PIERCEFLARE_DYNDNS_TLS_KEY doivent être définies ensemble")
+	}
+
+	// Lecture des uplinks (multi-WAN)
+	if err := loadUplinks(cfg); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
+
+// APITransport retourne les options de transport des appels au serveur PierceFlare
+func (c *Config) APITransport() transport.Options {
+	return transport.Options{
+		Proxy:         c.Proxy,
+		SourceAddress: c.SourceAddress,
+		Interface:     c.Interface,
+		CAFile:        c.CAFile,
+		CertFile:      c.ClientCert,
+		KeyFile:       c.ClientKey,
+		Timeout:       c.APITimeout,
+	}
+}
+
+// DetectTransport retourne les options de transport des appels aux services de détection d'IP
+// (le certificat client n'est présenté qu'au serveur PierceFlare)
+func (c *Config) DetectTransport() transport.Options {
+	return transport.Options{
+		Proxy:         c.Proxy,
+		SourceAddress: c.SourceAddress,
+		Interface:     c.Interface,
+		CAFile:        c.CAFile,
+		Timeout:       c.DetectTimeout,
+	}
+}
+
+// defaultControlSocket retourne l'emplacement par défaut du socket de contrôle: dans le répertoire
+// d'exécution donné par systemd (RuntimeDirectory=), dans /run/pierceflare pour root, dans celui de la
+// session de l'utilisateur sinon
+func defaultControlSocket() string {
+	if dir := os.Getenv("RUNTIME_DIRECTORY"); dir != "" {
+		dir, _, _ = strings.Cut(dir, ":") // Premier répertoire s'il y en a plusieurs
+		return filepath.Join(dir, DefaultControlSocketName)
+	}
+	if os.Geteuid() == 0 {
+		return filepath.Join("/run/pierceflare", DefaultControlSocketName)
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "pierceflare", DefaultControlSocketName)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("pierceflare-%d", os.Geteuid()), DefaultControlSocketName)
+}
+
+// ScheduleOptions retourne la planification des vérifications du mode continu
+func (c *Config) ScheduleOptions() schedule.Options {
+	return schedule.Options{
+		Interval:   c.CheckInterval,
+		Cron:       c.Schedule,
+		Jitter:     c.Jitter,
+		StartDelay: c.StartDelay,
+		QuietHours: c.QuietHours,
+	}
+}
+
+// scheduleVariables associe les options de la planification aux variables qui les définissent
+var scheduleVariables = map[string]string{
+	"Interval":   "PIERCEFLARE_CHECK_INTERVAL",
+	"Cron":       "PIERCEFLARE_SCHEDULE",
+	"Jitter":     "PIERCEFLARE_JITTER",
+	"StartDelay": "PIERCEFLARE_START_DELAY",
+	"QuietHours": "PIERCEFLARE_QUIET_HOURS",
+}
+
+// scheduleError attribue une erreur de la planification à la variable de l'option invalide
+func scheduleError(err error) error {
+	var optErr *schedule.OptionError
+	if errors.As(err, &optErr) {
+		if name, ok := scheduleVariables[optErr.Option]; ok {
+			return fmt.Errorf("valeur invalide pour %s: %w", name, optErr.Err)
+		}
+	}
+	return fmt.Errorf("planification invalide: %w", err)
+}
+
+// readDelay lit un délai positif ou nul exprimé en secondes, nul par défaut
+func readDelay(name string) (time.Duration, error) {
+	valueStr := os.Getenv(name)
+	if valueStr == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("valeur invalide pour %s: %s (nombre de secondes attendu)", name, valueStr)
+	}
+
+	return time.Duration(value) * time.Second, nil
+}
+
+// readSeconds lit une durée strictement positive exprimée en secondes
+func readSeconds(name string, defaultValue int) (time.Duration, error) {
+	valueStr := os.Getenv(name)
+	if valueStr == "" {
+		return time.Duration(defaultValue) * time.Second, nil
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("valeur invalide pour %s: %s (nombre de secondes attendu)", name, valueStr)
+	}
+
+	return time.Duration(value) * time.Second, nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/qalisa/pierceflare/cli/internal/transport"
+)
+
+// uplinkNamePattern restreint les noms d'uplinks à ce qui peut figurer dans un nom de variable d'environnement
+var uplinkNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Uplink décrit un lien Internet suivi indépendamment (routeurs multi-WAN) : la détection d'IP et
+// les flares passent par ce lien, et mettent à jour le domaine associé à son propre jeton
+type Uplink struct {
+	Name           string
+	APIKey         string
+	ServerURL      string
+	SourceAddress  string // Adresse IP locale du lien
+	Interface      string // Interface réseau du lien (Linux uniquement)
+	ExpectedDomain string // Domaine auquel le jeton doit être associé (vide = pas de vérification)
+
+	CloudflareRecord string // Enregistrement mis à jour directement via Cloudflare (vide = domaine associé au jeton)
+}
+
+// loadUplinks lit les uplinks déclarés dans PIERCEFLARE_UPLINKS (ex: "wan1,wan2"), chacun étant
+// configuré par les variables PIERCEFLARE_UPLINK_<NOM>_* (API_KEY, SERVER_URL, INTERFACE, SOURCE_ADDRESS,
+// EXPECTED_DOMAIN, CLOUDFLARE_RECORD)
+func loadUplinks(cfg *Config) error {
+	namesStr := strings.TrimSpace(os.Getenv("PIERCEFLARE_UPLINKS"))
+	if namesStr == "" {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, name := range strings.Split(namesStr, ",") {
+		name = strings.TrimSpace(name)
+		if !uplinkNamePattern.MatchString(name) {
+			return fmt.Errorf("nom d'uplink invalide: '%s' (caractères autorisés: lettres, chiffres, _ et -)", name)
+		}
+
+		prefix := uplinkEnvPrefix(name)
+		if seen[prefix] {
+			return fmt.Errorf("uplink déclaré plusieurs fois: %s", name)
+		}
+		seen[prefix] = true
+
+		uplink := Uplink{
+			Name:           name,
+			APIKey:         os.Getenv(prefix + "API_KEY"),
+			ServerURL:      os.Getenv(prefix + "SERVER_URL"),
+			SourceAddress:  os.Getenv(prefix + "SOURCE_ADDRESS"),
+			Interface:      os.Getenv(prefix + "INTERFACE"),
+			ExpectedDomain: os.Getenv(prefix + "EXPECTED_DOMAIN"),
+
+			CloudflareRecord: os.Getenv(prefix + "CLOUDFLARE_RECORD"),
+		}
+
+		// Par défaut, tous les uplinks utilisent le même serveur
+		if uplink.ServerURL == "" {
+			uplink.ServerURL = cfg.ServerURL
+		}
+
+		if uplink.SourceAddress == "" && uplink.Interface == "" {
+			return fmt.Errorf("l'uplink %s doit définir %sINTERFACE ou %sSOURCE_ADDRESS", name, prefix, prefix)
+		}
+
+		cfg.Uplinks = append(cfg.Uplinks, uplink)
+	}
+
+	return nil
+}
+
+// uplinkEnvPrefix retourne le préfixe des variables d'environnement configurant l'uplink
+func uplinkEnvPrefix(name string) string {
+	return "PIERCEFLARE_UPLINK_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
+
+// validateUplinks vérifie que chaque uplink peut communiquer avec le serveur
+func validateUplinks(cfg *Config) error {
+	for _, uplink := range cfg.Uplinks {
+		prefix := uplinkEnvPrefix(uplink.Name)
+
+		if uplink.APIKey == "" {
+			return fmt.Errorf("%w: la variable d'environnement %sAPI_KEY n'est pas définie", ErrMissingAPIKey, prefix)
+		}
+
+		if uplink.ServerURL == "" {
+			return fmt.Errorf("ni %sSERVER_URL ni PIERCEFLARE_SERVER_URL ne sont définies", prefix)
+		}
+	}
+
+	return nil
+}
+
+// Transport retourne les options de transport base, liées au lien de l'uplink
+func (u *Uplink) Transport(base transport.Options) transport.Options {
+	base.SourceAddress = u.SourceAddress
+	base.Interface = u.Interface
+	return base
+}
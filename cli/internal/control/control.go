@@ -0,0 +1,139 @@
+// Package control carries the control API of the continuous mode: a local HTTP API served on a Unix
+// domain socket, reporting the state of the agents and accepting commands (trigger, pause, resume).
+//
+// The API is not authenticated: only the owner of the socket (and root) can connect to it.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Routes of the control API. Commands apply to every uplink, or to the one given as uplink parameter.
+const (
+	RouteStatus  = "/v1/status"  // GET: state of the agents
+	RouteTrigger = "/v1/trigger" // POST: check the address now, answering once the check is done
+	RoutePause   = "/v1/pause"   // POST: suspend the checks
+	RouteResume  = "/v1/resume"  // POST: resume the checks
+)
+
+// UplinkParam is the query parameter restricting a request to an uplink
+const UplinkParam = "uplink"
+
+// ErrInUse is returned by Listen when another process serves the socket
+var ErrInUse = errors.New("control socket in use by another process")
+
+// Listen listens on the Unix socket at path, only accessible to the current user from its creation.
+// A socket left by a process that did not exit cleanly is replaced.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", path, ErrInUse)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return listenPrivate(path)
+}
+
+// ErrorResponse is the body of failed requests
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// WriteJSON answers v with the given status
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// WriteError answers err with the given status
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+// Error is a request refused by the control API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Client calls the control API of a running process
+type Client struct {
+	http *http.Client
+}
+
+// NewClient creates a client of the control API served on the socket at path
+func NewClient(path string, timeout time.Duration) *Client {
+	dialer := &net.Dialer{}
+	return &Client{http: &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+	}}
+}
+
+// Call sends a request to route, restricted to uplink if not empty, and decodes the answer into out
+func (c *Client) Call(ctx context.Context, method, route, uplink string, out any) error {
+	target := "http://pierceflare" + route
+	if uplink != "" {
+		target += "?" + url.Values{UplinkParam: {uplink}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var answer ErrorResponse
+		if json.Unmarshal(body, &answer) != nil || answer.Error == "" {
+			answer.Error = http.StatusText(resp.StatusCode)
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: answer.Error}
+	}
+	return json.Unmarshal(body, out)
+}
+
+// IsNotRunning reports whether err means no process serves the socket
+func IsNotRunning(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,89 @@
+//go:build unix
+
+package control
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run")
+	path := filepath.Join(dir, "control.sock")
+
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Type() != fs.ModeSocket || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %s, want a socket with permissions 0600", info.Mode())
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("directory of the socket = %v (%v), want created with permissions 0700", info.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d entries next to the socket, want the private directory it was bound in removed", len(entries))
+	}
+	if got := listener.Addr().String(); got != path {
+		t.Errorf("Addr = %s, want %s", got, path)
+	}
+
+	// The socket accepts connections once moved to its path
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+
+	// Served by another listener
+	if _, err := Listen(path); !errors.Is(err, ErrInUse) {
+		t.Errorf("Listen on a served socket = %v, want ErrInUse", err)
+	}
+
+	if err := listener.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("socket left after Close: %v", err)
+	}
+}
+
+func TestListenStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	// Left by a process that did not exit cleanly
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen over a stale socket: %v", err)
+	}
+	listener.Close()
+
+	// Never replaces anything but a socket
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path); err == nil {
+		t.Error("Listen replaced a regular file")
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package control
+
+import (
+	"net"
+	"os"
+)
+
+// listenPrivate listens on the Unix socket at path, restricted to the current user once created
+func listenPrivate(path string) (net.Listener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
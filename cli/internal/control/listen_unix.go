@@ -0,0 +1,56 @@
+//go:build unix
+
+package control
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// listenPrivate listens on the Unix socket at path with permissions 0600. The socket is bound in a
+// private directory (0700), where no other user can reach it before its mode is set, then moved to path.
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".control-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	bound := filepath.Join(dir, "socket")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: bound, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// The socket is no longer at the bound name once moved, privateListener removes it on close
+	listener.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(bound, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(bound, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &privateListener{UnixListener: listener, path: path}, nil
+}
+
+// privateListener removes the socket it was moved to when closed
+type privateListener struct {
+	*net.UnixListener
+	path string
+	once sync.Once
+}
+
+func (l *privateListener) Close() error {
+	err := l.UnixListener.Close()
+	l.once.Do(func() { os.Remove(l.path) })
+	return err
+}
+
+// Addr returns the address the socket was moved to
+func (l *privateListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
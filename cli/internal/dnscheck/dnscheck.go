@@ -0,0 +1,230 @@
+// Package dnscheck verifies that a flared address is actually served: the server only queues flares,
+// so the record of the domain is queried on several resolvers, over DNS and DNS over HTTPS, until all
+// of them answer the address.
+package dnscheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/dnswire"
+)
+
+// Defaults of Options
+const (
+	DefaultInterval     = 10 * time.Second
+	DefaultTimeout      = 10 * time.Minute
+	DefaultQueryTimeout = 5 * time.Second
+)
+
+// DefaultResolvers are queried when Options.Resolvers is empty: the authoritative servers, which
+// are updated first, and a public resolver, which is what visitors see once its cache expires
+var DefaultResolvers = []string{Authoritative, "1.1.1.1", "https://cloudflare-dns.com/dns-query"}
+
+// ErrNotConverged is returned when a resolver still does not answer the address at the deadline
+var ErrNotConverged = errors.New("DNS record did not converge")
+
+// Options configures the verification
+type Options struct {
+	Resolvers    []string      // host[:port] (DNS), https:// URL (DoH) or Authoritative; DefaultResolvers if empty
+	Interval     time.Duration // Delay between two rounds of queries (DefaultInterval if 0)
+	Timeout      time.Duration // Time given to the record to converge (DefaultTimeout if 0)
+	QueryTimeout time.Duration // Timeout of a single query (DefaultQueryTimeout if 0)
+}
+
+// Verifier checks the propagation of flared addresses
+type Verifier struct {
+	opts          Options
+	resolvers     []resolver
+	authoritative bool
+	now           func() time.Time
+}
+
+// New checks the options and creates a Verifier, whose DoH queries go through httpClient
+func New(opts Options, httpClient *http.Client) (*Verifier, error) {
+	if len(opts.Resolvers) == 0 {
+		opts.Resolvers = DefaultResolvers
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.QueryTimeout <= 0 {
+		opts.QueryTimeout = DefaultQueryTimeout
+	}
+
+	v := &Verifier{opts: opts, now: time.Now}
+	for _, spec := range opts.Resolvers {
+		spec = strings.TrimSpace(spec)
+		if strings.EqualFold(spec, Authoritative) {
+			v.authoritative = true
+			continue
+		}
+		r, err := newResolver(spec, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		v.resolvers = append(v.resolvers, r)
+	}
+	return v, nil
+}
+
+// Result describes the propagation of an address
+type Result struct {
+	Domain    string
+	Address   netip.Addr
+	Converged bool             // Every resolver answers the address (or a Cloudflare edge address)
+	Proxied   bool             // Every resolver answers Cloudflare edge addresses: the record is proxied
+	Duration  time.Duration    // Time until the last resolver answered the address
+	Resolvers []ResolverResult // Outcome of each resolver
+}
+
+// ResolverResult describes the answers of a resolver
+type ResolverResult struct {
+	Name    string
+	Matched bool          // The resolver answered the address
+	Proxied bool          // The resolver answered Cloudflare edge addresses, the record being proxied
+	After   time.Duration // Time until the resolver answered the address (or edge addresses)
+	Answer  []netip.Addr  // Last addresses answered
+	Err     error         // Last failure of the resolver, nil if it answered
+}
+
+// Pending returns the names of the resolvers that did not answer the address
+func (r *Result) Pending() []string {
+	var names []string
+	for _, resolver := range r.Resolvers {
+		if !resolver.Matched && !resolver.Proxied {
+			names = append(names, resolver.Name)
+		}
+	}
+	return names
+}
+
+// Verify queries the resolvers until each of them answers addr for domain, or until the timeout of
+// the options or the cancellation of ctx. Resolvers answering Cloudflare edge addresses are not
+// queried again: the record is proxied, visitors never see the address. ErrNotConverged is returned
+// with the result when some resolvers did not answer the address in time.
+func (v *Verifier) Verify(ctx context.Context, domain string, addr netip.Addr) (*Result, error) {
+	addr = addr.Unmap()
+	start := v.now()
+	result := &Result{Domain: domain, Address: addr}
+
+	ctx, cancel := context.WithTimeout(ctx, v.opts.Timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	resolvers := slices.Clone(v.resolvers)
+	if v.authoritative {
+		servers, err := authoritativeResolvers(ctx, domain)
+		if err != nil {
+			return result, fmt.Errorf("unable to query the authoritative servers: %w", err)
+		}
+		resolvers = append(servers, resolvers...)
+	}
+	for _, r := range resolvers {
+		result.Resolvers = append(result.Resolvers, ResolverResult{Name: r.name()})
+	}
+
+	rrType := recordType(addr)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return result, v.notConverged(ctx, result)
+		case <-timer.C:
+		}
+
+		pending := false
+		for i, r := range resolvers {
+			rr := &result.Resolvers[i]
+			if rr.Matched || rr.Proxied {
+				continue
+			}
+
+			queryCtx, cancelQuery := context.WithTimeout(ctx, v.opts.QueryTimeout)
+			answer, err := r.lookup(queryCtx, domain, rrType)
+			cancelQuery()
+			if err != nil && (ctx.Err() != nil || !time.Now().Before(deadline)) {
+				// Cut by the end of the verification: the last complete answer describes the resolver
+				pending = true
+				break
+			}
+			rr.Answer, rr.Err = nil, err
+			if err == nil {
+				rr.Answer = answer.addrs
+			}
+
+			switch {
+			case rr.Err != nil:
+				pending = true
+			case slices.Contains(rr.Answer, addr):
+				rr.Matched = true
+				rr.After = v.now().Sub(start)
+			case cloudflareEdge(rr.Answer):
+				rr.Proxied = true
+				rr.After = v.now().Sub(start)
+			default:
+				pending = true
+			}
+		}
+
+		if !pending {
+			result.Converged = true
+			result.Proxied = len(result.Resolvers) > 0
+			for _, rr := range result.Resolvers {
+				result.Duration = max(result.Duration, rr.After)
+				result.Proxied = result.Proxied && rr.Proxied
+			}
+			return result, nil
+		}
+
+		timer.Reset(v.opts.Interval)
+	}
+}
+
+// notConverged describes the resolvers that did not answer the address
+func (v *Verifier) notConverged(ctx context.Context, result *Result) error {
+	if ctx.Err() != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ctx.Err()
+	}
+
+	var details []string
+	for _, rr := range result.Resolvers {
+		switch {
+		case rr.Matched || rr.Proxied:
+		case rr.Err != nil:
+			details = append(details, fmt.Sprintf("%s: %v", rr.Name, rr.Err))
+		case len(rr.Answer) == 0:
+			details = append(details, fmt.Sprintf("%s: no record", rr.Name))
+		default:
+			details = append(details, fmt.Sprintf("%s: %s", rr.Name, joinAddrs(rr.Answer)))
+		}
+	}
+	return fmt.Errorf("%w within %s (%s)", ErrNotConverged, v.opts.Timeout, strings.Join(details, ", "))
+}
+
+// recordType returns the type of the records holding addresses of the family of addr
+func recordType(addr netip.Addr) uint16 {
+	if addr.Is6() {
+		return dnswire.TypeAAAA
+	}
+	return dnswire.TypeA
+}
+
+func joinAddrs(addrs []netip.Addr) string {
+	s := make([]string, len(addrs))
+	for i, addr := range addrs {
+		s[i] = addr.String()
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,196 @@
+package dnscheck
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/dnswire"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+const (
+	testDomain  = "home.example.com"
+	testAddress = "93.184.216.34"
+	staleAddr   = "93.184.216.35"
+	edgeAddr    = "104.16.132.229"
+)
+
+// newDNS starts a fake resolver closed at the end of the test
+func newDNS(t *testing.T, answers ...testserver.DNSAnswer) *testserver.DNS {
+	t.Helper()
+
+	s := testserver.NewDNS(answers...)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// resolverOf returns the spec of the fake resolver for Options.Resolvers, over UDP or DNS over HTTPS
+func resolverOf(s *testserver.DNS, doh bool) string {
+	if doh {
+		return s.DoHURL()
+	}
+	return s.Addr()
+}
+
+// newTestVerifier creates a verifier querying the stubs, quickly
+func newTestVerifier(t *testing.T, doh bool, stubs ...*testserver.DNS) *Verifier {
+	t.Helper()
+
+	opts := Options{Interval: 5 * time.Millisecond, Timeout: 300 * time.Millisecond, QueryTimeout: 50 * time.Millisecond}
+	for _, s := range stubs {
+		opts.Resolvers = append(opts.Resolvers, resolverOf(s, doh))
+	}
+	v, err := New(opts, stubs[0].DoHClient())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return v
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name        string
+		addr        string
+		answers     []testserver.DNSAnswer
+		wantQueries int    // Queries until the verification ends, 0 to skip the check
+		wantErr     string // Part of the description of the resolver, if the record does not converge
+		wantProxied bool
+		wantTimeout bool // The resolver never answers
+	}{
+		{name: "match", addr: testAddress, answers: []testserver.DNSAnswer{{Addrs: []string{testAddress}}}, wantQueries: 1},
+		{name: "match among others", addr: testAddress, answers: []testserver.DNSAnswer{{Addrs: []string{staleAddr, testAddress}}}, wantQueries: 1},
+		{
+			name: "stale then match", addr: testAddress,
+			answers:     []testserver.DNSAnswer{{Addrs: []string{staleAddr}}, {Addrs: []string{staleAddr}}, {Addrs: []string{testAddress}}},
+			wantQueries: 3,
+		},
+		{
+			name: "NXDOMAIN then match", addr: testAddress,
+			answers:     []testserver.DNSAnswer{{Rcode: dnswire.RcodeNXDomain}, {Addrs: []string{testAddress}}},
+			wantQueries: 2,
+		},
+		{name: "stale", addr: testAddress, answers: []testserver.DNSAnswer{{Addrs: []string{staleAddr}}}, wantErr: staleAddr},
+		{name: "NXDOMAIN", addr: testAddress, answers: []testserver.DNSAnswer{{Rcode: dnswire.RcodeNXDomain}}, wantErr: "no record"},
+		{name: "other family only", addr: testAddress, answers: []testserver.DNSAnswer{{Addrs: []string{"2606:2800:220:1::248"}}}, wantErr: "no record"},
+		{name: "SERVFAIL", addr: testAddress, answers: []testserver.DNSAnswer{{Rcode: 2}}, wantErr: "SERVFAIL"},
+		{name: "timeout", addr: testAddress, answers: []testserver.DNSAnswer{{Drop: true}}, wantTimeout: true},
+		{name: "timeout then match", addr: testAddress, answers: []testserver.DNSAnswer{{Drop: true}, {Addrs: []string{testAddress}}}, wantQueries: 2},
+		{name: "proxied", addr: testAddress, answers: []testserver.DNSAnswer{{Addrs: []string{edgeAddr, "104.16.133.229"}}}, wantQueries: 1, wantProxied: true},
+		{name: "proxied IPv6", addr: "2606:2800:220:1::248", answers: []testserver.DNSAnswer{{Addrs: []string{"2606:4700::6810:84e5"}}}, wantQueries: 1, wantProxied: true},
+		{name: "edge and other address", addr: testAddress, answers: []testserver.DNSAnswer{{Addrs: []string{edgeAddr, staleAddr}}}, wantErr: edgeAddr + "," + staleAddr},
+		{name: "IPv6", addr: "2606:2800:220:1::248", answers: []testserver.DNSAnswer{{Addrs: []string{testAddress, "2606:2800:220:1::248"}}}, wantQueries: 1},
+	}
+
+	for _, tt := range tests {
+		for _, doh := range []bool{false, true} {
+			name := tt.name + " (DNS)"
+			if doh {
+				name = tt.name + " (DoH)"
+			}
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				s := newDNS(t, tt.answers...)
+				v := newTestVerifier(t, doh, s)
+
+				result, err := v.Verify(context.Background(), testDomain, netip.MustParseAddr(tt.addr))
+				if tt.wantTimeout {
+					if rr := result.Resolvers[0]; !errors.Is(err, ErrNotConverged) || !errors.Is(rr.Err, context.DeadlineExceeded) && !errors.Is(rr.Err, os.ErrDeadlineExceeded) {
+						t.Fatalf("Verify = %v, resolver error = %v, want ErrNotConverged after query timeouts", err, rr.Err)
+					}
+					return
+				}
+				if tt.wantErr != "" {
+					if !errors.Is(err, ErrNotConverged) || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("Verify = %v, want ErrNotConverged mentioning %q", err, tt.wantErr)
+					}
+					if result.Converged || !slices.Equal(result.Pending(), []string{resolverOf(s, doh)}) {
+						t.Errorf("converged = %v, pending = %v, want the resolver pending", result.Converged, result.Pending())
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if !result.Converged || result.Proxied != tt.wantProxied || len(result.Pending()) != 0 {
+					t.Errorf("converged = %v, proxied = %v, pending = %v, want converged, proxied = %v",
+						result.Converged, result.Proxied, result.Pending(), tt.wantProxied)
+				}
+				if rr := result.Resolvers[0]; rr.Matched == tt.wantProxied || rr.Proxied != tt.wantProxied || rr.Err != nil {
+					t.Errorf("resolver result = %+v, want matched = %v", rr, !tt.wantProxied)
+				}
+				if got := s.Queries(); got != tt.wantQueries {
+					t.Errorf("%d queries, want %d", got, tt.wantQueries)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyResolvers(t *testing.T) {
+	served := newDNS(t, testserver.DNSAnswer{Addrs: []string{testAddress}})
+	stale := newDNS(t, testserver.DNSAnswer{Addrs: []string{staleAddr}})
+	v := newTestVerifier(t, false, served, stale)
+
+	result, err := v.Verify(context.Background(), testDomain, netip.MustParseAddr(testAddress))
+	if !errors.Is(err, ErrNotConverged) {
+		t.Fatalf("Verify = %v, want ErrNotConverged", err)
+	}
+	if got := result.Pending(); !slices.Equal(got, []string{stale.Addr()}) {
+		t.Errorf("pending = %v, want only the stale resolver", got)
+	}
+
+	// A resolver that answered is not queried again
+	if got := served.Queries(); got != 1 {
+		t.Errorf("%d queries to the resolver serving the address, want 1", got)
+	}
+	if got := stale.Queries(); got < 2 {
+		t.Errorf("%d queries to the stale resolver, want it queried until the timeout", got)
+	}
+}
+
+func TestVerifyCanceled(t *testing.T) {
+	s := newDNS(t, testserver.DNSAnswer{Addrs: []string{staleAddr}})
+	v := newTestVerifier(t, false, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := v.Verify(ctx, testDomain, netip.MustParseAddr(testAddress)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Verify = %v, want context.Canceled", err)
+	}
+}
+
+func TestCloudflareEdge(t *testing.T) {
+	tests := []struct {
+		addrs []string
+		want  bool
+	}{
+		{[]string{"104.16.132.229"}, true},
+		{[]string{"172.67.1.1", "104.21.2.2"}, true},
+		{[]string{"173.245.48.1"}, true},
+		{[]string{"::ffff:104.16.132.229"}, true},
+		{[]string{"2606:4700::6810:84e5"}, true},
+		{[]string{"2a06:98c1::1"}, true},
+		{[]string{"104.16.132.229", testAddress}, false},
+		{[]string{"104.32.0.1"}, false}, // Past 104.24.0.0/14
+		{[]string{testAddress}, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		addrs := make([]netip.Addr, len(tt.addrs))
+		for i, a := range tt.addrs {
+			addrs[i] = netip.MustParseAddr(a)
+		}
+		if got := cloudflareEdge(addrs); got != tt.want {
+			t.Errorf("cloudflareEdge(%v) = %v, want %v", tt.addrs, got, tt.want)
+		}
+	}
+}
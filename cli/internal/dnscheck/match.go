@@ -0,0 +1,126 @@
+package dnscheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCacheTTL bounds the time an answer is trusted, whatever its TTL
+const maxCacheTTL = time.Hour
+
+// Matcher tells whether the record of a domain already serves an address, from the answer of a single
+// resolver cached for its TTL
+type Matcher struct {
+	resolver     resolver // nil for the authoritative servers of the domain
+	queryTimeout time.Duration
+	now          func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedAnswer // Domain and record type -> answer
+}
+
+// cachedAnswer is an answer of the resolver, until it expires
+type cachedAnswer struct {
+	addrs   []netip.Addr
+	expires time.Time
+}
+
+// NewMatcher creates a Matcher querying spec (see Options.Resolvers, Authoritative if empty), whose DoH
+// queries go through httpClient
+func NewMatcher(spec string, httpClient *http.Client, queryTimeout time.Duration) (*Matcher, error) {
+	if queryTimeout <= 0 {
+		queryTimeout = DefaultQueryTimeout
+	}
+	m := &Matcher{
+		queryTimeout: queryTimeout,
+		now:          time.Now,
+		cache:        map[string]cachedAnswer{},
+	}
+
+	spec = strings.TrimSpace(spec)
+	if spec != "" && !strings.EqualFold(spec, Authoritative) {
+		r, err := newResolver(spec, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		m.resolver = r
+	}
+	return m, nil
+}
+
+// Name describes the resolver queried
+func (m *Matcher) Name() string {
+	if m.resolver == nil {
+		return Authoritative
+	}
+	return m.resolver.name()
+}
+
+// Serves reports whether the record of domain serves addr, and the addresses it serves. Proxied
+// records never serve the address of the origin.
+func (m *Matcher) Serves(ctx context.Context, domain string, addr netip.Addr) (bool, []netip.Addr, error) {
+	addr = addr.Unmap()
+	rrType := recordType(addr)
+	key := strings.ToLower(strings.TrimSuffix(domain, ".")) + fmt.Sprintf("/%d", rrType)
+
+	m.mu.Lock()
+	cached, ok := m.cache[key]
+	m.mu.Unlock()
+	if ok && m.now().Before(cached.expires) {
+		return slices.Contains(cached.addrs, addr), cached.addrs, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.queryTimeout)
+	defer cancel()
+
+	answer, err := m.lookup(ctx, domain, rrType)
+	if err != nil {
+		return false, nil, err
+	}
+
+	m.mu.Lock()
+	m.cache[key] = cachedAnswer{addrs: answer.addrs, expires: m.now().Add(min(answer.ttl, maxCacheTTL))}
+	m.mu.Unlock()
+	return slices.Contains(answer.addrs, addr), answer.addrs, nil
+}
+
+// Forget drops the cached answers for domain, e.g. once its record has been updated
+func (m *Matcher) Forget(domain string) {
+	prefix := strings.ToLower(strings.TrimSuffix(domain, ".")) + "/"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.cache {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.cache, key)
+		}
+	}
+}
+
+// lookup queries the resolver, or the authoritative servers of domain one after the other
+func (m *Matcher) lookup(ctx context.Context, domain string, rrType uint16) (*answer, error) {
+	if m.resolver != nil {
+		return m.resolver.lookup(ctx, domain, rrType)
+	}
+
+	servers, err := authoritativeResolvers(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, server := range servers {
+		answer, err := server.lookup(ctx, domain, rrType)
+		if err == nil {
+			return answer, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server.name(), err))
+	}
+	return nil, errors.Join(errs...)
+}
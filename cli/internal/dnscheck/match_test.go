@@ -0,0 +1,145 @@
+package dnscheck
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+func TestMatcher(t *testing.T) {
+	type step struct {
+		after       time.Duration // Time since the previous step
+		addr        string
+		wantServes  bool
+		wantErr     bool
+		wantQueries int // Queries received so far
+	}
+	tests := []struct {
+		name    string
+		answers []testserver.DNSAnswer
+		steps   []step
+	}{
+		{
+			name:    "hit within the TTL",
+			answers: []testserver.DNSAnswer{{Addrs: []string{testAddress}, TTL: 300}, {Addrs: []string{staleAddr}, TTL: 300}},
+			steps: []step{
+				{addr: testAddress, wantServes: true, wantQueries: 1},
+				{after: time.Minute, addr: testAddress, wantServes: true, wantQueries: 1},
+				{after: 4*time.Minute - time.Second, addr: staleAddr, wantQueries: 1}, // Still cached
+				// Expired: looked up again
+				{after: time.Second, addr: staleAddr, wantServes: true, wantQueries: 2},
+			},
+		},
+		{
+			name:    "lowest TTL",
+			answers: []testserver.DNSAnswer{{Addrs: []string{testAddress, staleAddr}, TTL: 60}},
+			steps: []step{
+				{addr: testAddress, wantServes: true, wantQueries: 1},
+				{after: 59 * time.Second, addr: staleAddr, wantServes: true, wantQueries: 1},
+				{after: time.Second, addr: testAddress, wantServes: true, wantQueries: 2},
+			},
+		},
+		{
+			name:    "TTL of 0",
+			answers: []testserver.DNSAnswer{{Addrs: []string{testAddress}}},
+			steps: []step{
+				{addr: testAddress, wantServes: true, wantQueries: 1},
+				{addr: testAddress, wantServes: true, wantQueries: 2},
+			},
+		},
+		{
+			name:    "TTL capped",
+			answers: []testserver.DNSAnswer{{Addrs: []string{testAddress}, TTL: 86400}},
+			steps: []step{
+				{addr: testAddress, wantServes: true, wantQueries: 1},
+				{after: maxCacheTTL - time.Second, addr: testAddress, wantServes: true, wantQueries: 1},
+				{after: time.Second, addr: testAddress, wantServes: true, wantQueries: 2},
+			},
+		},
+		{
+			name:    "NXDOMAIN",
+			answers: []testserver.DNSAnswer{{Rcode: 3}},
+			steps:   []step{{addr: testAddress, wantQueries: 1}},
+		},
+		{
+			name:    "lookup error",
+			answers: []testserver.DNSAnswer{{Rcode: 2}, {Addrs: []string{testAddress}, TTL: 300}},
+			steps: []step{
+				{addr: testAddress, wantErr: true, wantQueries: 1},
+				// Errors are not cached
+				{addr: testAddress, wantServes: true, wantQueries: 2},
+			},
+		},
+		{
+			name:    "timeout",
+			answers: []testserver.DNSAnswer{{Drop: true}, {Addrs: []string{testAddress}, TTL: 300}},
+			steps: []step{
+				{addr: testAddress, wantErr: true, wantQueries: 1},
+				{addr: testAddress, wantServes: true, wantQueries: 2},
+			},
+		},
+		{
+			name:    "families cached apart",
+			answers: []testserver.DNSAnswer{{Addrs: []string{testAddress, "2606:2800:220:1::248"}, TTL: 300}},
+			steps: []step{
+				{addr: testAddress, wantServes: true, wantQueries: 1},
+				{addr: "2606:2800:220:1::248", wantServes: true, wantQueries: 2},
+				{addr: "2606:2800:220:1::249", wantQueries: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newDNS(t, tt.answers...)
+			m, err := NewMatcher(s.Addr(), nil, 50*time.Millisecond)
+			if err != nil {
+				t.Fatalf("NewMatcher: %v", err)
+			}
+			now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+			m.now = func() time.Time { return now }
+
+			for i, step := range tt.steps {
+				now = now.Add(step.after)
+				serves, addrs, err := m.Serves(context.Background(), testDomain, netip.MustParseAddr(step.addr))
+				if (err != nil) != step.wantErr {
+					t.Fatalf("step %d: Serves error = %v, want error = %v", i, err, step.wantErr)
+				}
+				if serves != step.wantServes {
+					t.Errorf("step %d: Serves(%s) = %v (answer %v), want %v", i, step.addr, serves, addrs, step.wantServes)
+				}
+				if serves && !slices.Contains(addrs, netip.MustParseAddr(step.addr)) {
+					t.Errorf("step %d: answer %v does not hold %s", i, addrs, step.addr)
+				}
+				if got := s.Queries(); got != step.wantQueries {
+					t.Errorf("step %d: %d queries, want %d", i, got, step.wantQueries)
+				}
+			}
+		})
+	}
+}
+
+func TestMatcherForget(t *testing.T) {
+	s := newDNS(t, testserver.DNSAnswer{Addrs: []string{staleAddr}, TTL: 300}, testserver.DNSAnswer{Addrs: []string{testAddress}, TTL: 300})
+	m, err := NewMatcher(s.DoHURL(), s.DoHClient(), 0)
+	if err != nil {
+		t.Fatalf("NewMatcher: %v", err)
+	}
+
+	if serves, _, err := m.Serves(context.Background(), testDomain, netip.MustParseAddr(testAddress)); serves || err != nil {
+		t.Fatalf("Serves = %v, %v, want the stale answer", serves, err)
+	}
+
+	// Flared: the cached answer of the domain no longer holds
+	m.Forget("Home.Example.com.")
+	if serves, _, err := m.Serves(context.Background(), testDomain, netip.MustParseAddr(testAddress)); !serves || err != nil {
+		t.Fatalf("Serves = %v, %v after Forget, want the address looked up again", serves, err)
+	}
+	if got := s.Queries(); got != 2 {
+		t.Errorf("%d queries, want 2", got)
+	}
+}
@@ -0,0 +1,184 @@
+package dnscheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/dnswire"
+)
+
+// Authoritative designates the authoritative servers of the zone of the domain, looked up at each
+// verification, in the list of resolvers
+const Authoritative = "authoritative"
+
+// resolver answers the addresses a name resolves to
+type resolver interface {
+	name() string
+	lookup(ctx context.Context, domain string, rrType uint16) (*answer, error)
+}
+
+// answer holds the addresses a name resolves to
+type answer struct {
+	addrs []netip.Addr
+	ttl   time.Duration // Time the answer may be cached, the lowest TTL of its records
+}
+
+// newResolver parses a resolver of Options.Resolvers: a DoH URL, or a host with an optional port
+func newResolver(spec string, httpClient *http.Client) (resolver, error) {
+	if strings.HasPrefix(spec, "https://") {
+		return &dohResolver{url: spec, httpClient: httpClient}, nil
+	}
+	if strings.Contains(spec, "://") {
+		return nil, fmt.Errorf("unsupported resolver '%s' (expected host[:port], https:// URL or %s)", spec, Authoritative)
+	}
+	return &dnsResolver{server: serverAddress(spec), recursive: true}, nil
+}
+
+// serverAddress adds the DNS port to a host without port
+func serverAddress(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "53")
+}
+
+// dnsResolver queries a server over UDP, then TCP if the answer is truncated
+type dnsResolver struct {
+	server    string
+	recursive bool
+	label     string // Name of the server in reports, server if empty
+}
+
+func (r *dnsResolver) name() string {
+	if r.label != "" {
+		return r.label
+	}
+	return r.server
+}
+
+func (r *dnsResolver) lookup(ctx context.Context, domain string, rrType uint16) (*answer, error) {
+	id, err := dnswire.NewID()
+	if err != nil {
+		return nil, err
+	}
+	query, err := dnswire.Query(id, domain, rrType, r.recursive)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dnswire.Exchange(ctx, r.server, query)
+	if err != nil {
+		return nil, err
+	}
+	return parseAnswer(resp, rrType)
+}
+
+// dohResolver queries a DNS over HTTPS endpoint (RFC 8484)
+type dohResolver struct {
+	url        string
+	httpClient *http.Client
+}
+
+func (r *dohResolver) name() string {
+	return r.url
+}
+
+func (r *dohResolver) lookup(ctx context.Context, domain string, rrType uint16) (*answer, error) {
+	// ID 0 makes the answers cacheable by HTTP caches
+	query, err := dnswire.Query(0, domain, rrType, true)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH query failed: HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dnswire.MaxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dnswire.CheckResponse(body, 0); err != nil {
+		return nil, err
+	}
+	return parseAnswer(body, rrType)
+}
+
+// parseAnswer returns the addresses of the given type in the answer of a response. A name that does not
+// exist has no address: it may not have propagated yet.
+func parseAnswer(resp []byte, rrType uint16) (*answer, error) {
+	h, sections, err := dnswire.ParseSections(resp)
+	if err != nil {
+		return nil, err
+	}
+	switch h.Rcode() {
+	case dnswire.RcodeSuccess:
+	case dnswire.RcodeNXDomain:
+		return &answer{}, nil
+	default:
+		return nil, fmt.Errorf("query failed: %s", dnswire.RcodeName(h.Rcode()))
+	}
+
+	// Recursive resolvers follow CNAMEs: every address of the answer is one of the name
+	a := &answer{}
+	for i, record := range sections.Answer {
+		ttl := time.Duration(record.TTL) * time.Second
+		if i == 0 || ttl < a.ttl {
+			a.ttl = ttl
+		}
+		if record.Type != rrType || record.Class != dnswire.ClassIN {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(record.Data)
+		if !ok {
+			return nil, dnswire.ErrMalformed
+		}
+		a.addrs = append(a.addrs, addr)
+	}
+	return a, nil
+}
+
+// errNoNameServer is returned when the authoritative servers of a domain cannot be found
+var errNoNameServer = errors.New("no authoritative server found")
+
+// authoritativeResolvers looks up the authoritative servers of the zone of domain with the system resolver,
+// walking up the labels of the domain until a zone apex is found
+func authoritativeResolvers(ctx context.Context, domain string) ([]resolver, error) {
+	name := strings.TrimSuffix(domain, ".")
+	for {
+		servers, err := net.DefaultResolver.LookupNS(ctx, name)
+		if err == nil && len(servers) > 0 {
+			resolvers := make([]resolver, 0, len(servers))
+			for _, ns := range servers {
+				host := strings.TrimSuffix(ns.Host, ".")
+				resolvers = append(resolvers, &dnsResolver{server: serverAddress(host), label: host})
+			}
+			return resolvers, nil
+		}
+
+		_, parent, found := strings.Cut(name, ".")
+		if !found || !strings.Contains(parent, ".") {
+			return nil, fmt.Errorf("%w for %s", errNoNameServer, domain)
+		}
+		name = parent
+	}
+}
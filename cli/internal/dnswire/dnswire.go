@@ -0,0 +1,275 @@
+// Package dnswire encodes and decodes the DNS messages exchanged by the client (RFC 1035): queries
+// checking records and dynamic updates.
+package dnswire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Record types and classes
+const (
+	TypeA     = 1
+	TypeNS    = 2
+	TypeCNAME = 5
+	TypeSOA   = 6
+	TypeAAAA  = 28
+	TypeTSIG  = 250
+
+	ClassIN  = 1
+	ClassANY = 255
+)
+
+// Header flags and opcodes
+const (
+	HeaderSize = 12
+
+	FlagRD = 1 << 8  // Recursion desired
+	FlagTC = 1 << 9  // Truncated
+	FlagAA = 1 << 10 // Authoritative answer
+	FlagQR = 1 << 15 // Response
+
+	OpcodeQuery  = 0
+	OpcodeUpdate = 5
+)
+
+// Response codes
+const (
+	RcodeSuccess  = 0
+	RcodeNXDomain = 3
+)
+
+// MaxMessageSize bounds the messages read from servers
+const MaxMessageSize = 65535
+
+// rcodeNames describes the response codes of queries and updates
+var rcodeNames = map[int]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+	16: "BADSIG",
+	17: "BADKEY",
+	18: "BADTIME",
+}
+
+// RcodeName returns the mnemonic of a response code
+func RcodeName(rcode int) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// ErrMalformed is returned for messages that cannot be parsed
+var ErrMalformed = errors.New("malformed DNS response")
+
+// FQDN makes a name absolute
+func FQDN(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// AppendName encodes an absolute domain name, without compression
+func AppendName(b []byte, name string) ([]byte, error) {
+	name = FQDN(name)
+	if len(name) > 255 {
+		return nil, fmt.Errorf("domain name too long: %s", name)
+	}
+	if name == "." {
+		return append(b, 0), nil
+	}
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("invalid domain name: %s", name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// AppendRR encodes the fixed part of a resource record, followed by its data
+func AppendRR(b []byte, name string, rrType, class uint16, ttl uint32, rdata []byte) ([]byte, error) {
+	b, err := AppendName(b, name)
+	if err != nil {
+		return nil, err
+	}
+	b = binary.BigEndian.AppendUint16(b, rrType)
+	b = binary.BigEndian.AppendUint16(b, class)
+	b = binary.BigEndian.AppendUint32(b, ttl)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...), nil
+}
+
+// AppendHeader encodes the fixed part of a message
+func AppendHeader(b []byte, h Header) []byte {
+	b = binary.BigEndian.AppendUint16(b, h.ID)
+	b = binary.BigEndian.AppendUint16(b, h.Flags)
+	b = binary.BigEndian.AppendUint16(b, h.QDCount)
+	b = binary.BigEndian.AppendUint16(b, h.ANCount)
+	b = binary.BigEndian.AppendUint16(b, h.NSCount)
+	return binary.BigEndian.AppendUint16(b, h.ARCount)
+}
+
+// Query builds a query for the records of a name, asking for recursion if recursive is set
+func Query(id uint16, name string, rrType uint16, recursive bool) ([]byte, error) {
+	h := Header{ID: id, QDCount: 1}
+	if recursive {
+		h.Flags |= FlagRD
+	}
+
+	msg, err := AppendName(AppendHeader(make([]byte, 0, 512), h), name)
+	if err != nil {
+		return nil, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, rrType)
+	return binary.BigEndian.AppendUint16(msg, ClassIN), nil
+}
+
+// Header is the fixed part of a DNS message
+type Header struct {
+	ID                                 uint16
+	Flags                              uint16
+	QDCount, ANCount, NSCount, ARCount uint16
+}
+
+// ParseHeader decodes the fixed part of a message
+func ParseHeader(msg []byte) (Header, error) {
+	if len(msg) < HeaderSize {
+		return Header{}, ErrMalformed
+	}
+	return Header{
+		ID:      binary.BigEndian.Uint16(msg[0:]),
+		Flags:   binary.BigEndian.Uint16(msg[2:]),
+		QDCount: binary.BigEndian.Uint16(msg[4:]),
+		ANCount: binary.BigEndian.Uint16(msg[6:]),
+		NSCount: binary.BigEndian.Uint16(msg[8:]),
+		ARCount: binary.BigEndian.Uint16(msg[10:]),
+	}, nil
+}
+
+// Rcode returns the response code of the message
+func (h Header) Rcode() int {
+	return int(h.Flags & 0xF)
+}
+
+// ReadName decodes the name at off, following compression pointers, and returns it with the offset
+// of what follows it in the message
+func ReadName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, ErrMalformed
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 16 {
+				return "", 0, ErrMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, ErrMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// RR is a parsed resource record
+type RR struct {
+	Start   int // Offset of the record in the message
+	Name    string
+	Type    uint16
+	Class   uint16
+	TTL     uint32
+	Data    []byte
+	DataOff int // Offset of Data in the message
+}
+
+// ReadRR decodes the resource record at off
+func ReadRR(msg []byte, off int) (RR, int, error) {
+	record := RR{Start: off}
+	name, off, err := ReadName(msg, off)
+	if err != nil {
+		return RR{}, 0, err
+	}
+	if off+10 > len(msg) {
+		return RR{}, 0, ErrMalformed
+	}
+	record.Name = name
+	record.Type = binary.BigEndian.Uint16(msg[off:])
+	record.Class = binary.BigEndian.Uint16(msg[off+2:])
+	record.TTL = binary.BigEndian.Uint32(msg[off+4:])
+	length := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+length > len(msg) {
+		return RR{}, 0, ErrMalformed
+	}
+	record.DataOff = off
+	record.Data = msg[off : off+length]
+	return record, off + length, nil
+}
+
+// Sections holds the records of a message
+type Sections struct {
+	Answer     []RR
+	Authority  []RR
+	Additional []RR
+}
+
+// ParseSections decodes the records of a message, skipping the questions
+func ParseSections(msg []byte) (Header, *Sections, error) {
+	h, err := ParseHeader(msg)
+	if err != nil {
+		return Header{}, nil, err
+	}
+
+	off := HeaderSize
+	for i := 0; i < int(h.QDCount); i++ {
+		if _, off, err = ReadName(msg, off); err != nil {
+			return Header{}, nil, err
+		}
+		off += 4
+	}
+
+	s := &Sections{}
+	for _, section := range []struct {
+		records *[]RR
+		count   uint16
+	}{{&s.Answer, h.ANCount}, {&s.Authority, h.NSCount}, {&s.Additional, h.ARCount}} {
+		for i := 0; i < int(section.count); i++ {
+			var record RR
+			if record, off, err = ReadRR(msg, off); err != nil {
+				return Header{}, nil, err
+			}
+			*section.records = append(*section.records, record)
+		}
+	}
+	return h, s, nil
+}
@@ -0,0 +1,152 @@
+package dnswire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// Known answers, packed by github.com/miekg/dns (used by CoreDNS), which compresses names like BIND
+const (
+	// Recursive query for www.example.com. A, ID 0xbeef
+	queryHex = "beef0100000100000000000003777777076578616d706c6503636f6d0000010001"
+
+	// Its response: www.example.com. CNAME home.example.com., home.example.com. A 93.184.216.34, every
+	// name compressed (the CNAME target points to the middle of the question name)
+	compressedHex = "beef8100000100020000000003777777076578616d706c6503636f6d0000010001" +
+		"c00c000500010000003c000704686f6d65c010" +
+		"c02d000100010000003c00045db8d822"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestQuery(t *testing.T) {
+	msg, err := Query(0xbeef, "www.example.com", TypeA, true)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if want := mustHex(t, queryHex); !bytes.Equal(msg, want) {
+		t.Errorf("Query =\n%x\nwant\n%x", msg, want)
+	}
+}
+
+func TestAppendName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string // Hex encoding, empty if the name is invalid
+		invalid bool
+	}{
+		{name: ".", want: "00"},
+		{name: "example.com", want: "076578616d706c6503636f6d00"},
+		{name: "example.com.", want: "076578616d706c6503636f6d00"},
+		{name: "example..com", invalid: true},
+		{name: string(bytes.Repeat([]byte("a"), 64)) + ".com", invalid: true},
+		{name: string(bytes.Repeat([]byte("abcdefg."), 32)), invalid: true},
+	}
+
+	for _, tt := range tests {
+		got, err := AppendName(nil, tt.name)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("AppendName(%q) = %x, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || hex.EncodeToString(got) != tt.want {
+			t.Errorf("AppendName(%q) = %x, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseSectionsCompressed(t *testing.T) {
+	msg := mustHex(t, compressedHex)
+
+	h, sections, err := ParseSections(msg)
+	if err != nil {
+		t.Fatalf("ParseSections: %v", err)
+	}
+	if h.ID != 0xbeef || h.Flags&FlagQR == 0 || h.Rcode() != RcodeSuccess {
+		t.Errorf("header = %+v, want a successful response to 0xbeef", h)
+	}
+	if len(sections.Answer) != 2 || len(sections.Authority) != 0 || len(sections.Additional) != 0 {
+		t.Fatalf("sections = %+v, want 2 answers", sections)
+	}
+
+	cname, a := sections.Answer[0], sections.Answer[1]
+	if cname.Name != "www.example.com." || cname.Type != TypeCNAME || cname.Class != ClassIN || cname.TTL != 60 {
+		t.Errorf("first answer = %+v, want the CNAME of www.example.com.", cname)
+	}
+	target, end, err := ReadName(msg, cname.DataOff)
+	if err != nil || target != "home.example.com." || end != cname.DataOff+len(cname.Data) {
+		t.Errorf("CNAME target = %q ending at %d, %v, want home.example.com. ending at %d", target, end, err, cname.DataOff+len(cname.Data))
+	}
+
+	if a.Name != "home.example.com." || a.Type != TypeA || !bytes.Equal(a.Data, []byte{93, 184, 216, 34}) {
+		t.Errorf("second answer = %+v, want home.example.com. A 93.184.216.34", a)
+	}
+}
+
+func TestReadNameMalformed(t *testing.T) {
+	header := make([]byte, HeaderSize)
+	tests := []struct {
+		name string
+		hex  string // Name at offset 12
+	}{
+		{name: "empty", hex: ""},
+		{name: "label past the end", hex: "076578616d70"},
+		{name: "missing root label", hex: "076578616d706c65"},
+		{name: "truncated pointer", hex: "c0"},
+		{name: "pointer past the end", hex: "c0ff"},
+		{name: "pointer to itself", hex: "c00c"},
+		{name: "pointer loop", hex: "03777777c00c"},
+	}
+
+	for _, tt := range tests {
+		msg := append(header, mustHex(t, tt.hex)...)
+		if name, _, err := ReadName(msg, HeaderSize); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%s: ReadName = %q, %v, want ErrMalformed", tt.name, name, err)
+		}
+	}
+}
+
+func TestParseSectionsTruncated(t *testing.T) {
+	msg := mustHex(t, compressedHex)
+
+	// Every truncation of the message lands in the middle of a field
+	for n := range len(msg) {
+		if _, _, err := ParseSections(msg[:n]); !errors.Is(err, ErrMalformed) {
+			t.Errorf("ParseSections of the first %d bytes = %v, want ErrMalformed", n, err)
+		}
+	}
+}
+
+func TestReadRRDataPastTheEnd(t *testing.T) {
+	msg := mustHex(t, compressedHex)
+	msg[len(msg)-5]++ // RDLENGTH of the A record
+
+	if _, _, err := ParseSections(msg); !errors.Is(err, ErrMalformed) {
+		t.Errorf("ParseSections = %v, want ErrMalformed", err)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	query, response := mustHex(t, queryHex), mustHex(t, compressedHex)
+
+	if _, err := CheckResponse(response, 0xbeef); err != nil {
+		t.Errorf("CheckResponse of the response = %v", err)
+	}
+	if _, err := CheckResponse(response, 0xbeee); !errors.Is(err, ErrMalformed) {
+		t.Errorf("CheckResponse with another ID = %v, want ErrMalformed", err)
+	}
+	if _, err := CheckResponse(query, 0xbeef); !errors.Is(err, ErrMalformed) {
+		t.Errorf("CheckResponse of the query = %v, want ErrMalformed", err)
+	}
+}
@@ -0,0 +1,94 @@
+package dnswire
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// NewID returns the ID of a new message
+func NewID() (uint16, error) {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b[:]), nil
+}
+
+// Exchange sends msg to server (host:port) over UDP, then over TCP if the response is truncated,
+// and returns the response
+func Exchange(ctx context.Context, server string, msg []byte) ([]byte, error) {
+	resp, err := exchange(ctx, "udp", server, msg)
+	if err != nil {
+		return nil, err
+	}
+	if h, _ := ParseHeader(resp); h.Flags&FlagTC != 0 {
+		return exchange(ctx, "tcp", server, msg)
+	}
+	return resp, nil
+}
+
+// exchange sends msg over network and returns the response with the same ID
+func exchange(ctx context.Context, network, server string, msg []byte) ([]byte, error) {
+	h, err := ParseHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+		if _, err := conn.Write(append(framed, msg...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return nil, err
+		}
+		return CheckResponse(resp, h.ID)
+	}
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, MaxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore stray datagrams
+		if resp, err := CheckResponse(buf[:n], h.ID); err == nil {
+			return resp, nil
+		}
+	}
+}
+
+// CheckResponse checks that a message is the response to the message with the given ID
+func CheckResponse(resp []byte, id uint16) ([]byte, error) {
+	h, err := ParseHeader(resp)
+	if err != nil {
+		return nil, err
+	}
+	if h.ID != id || h.Flags&FlagQR == 0 {
+		return nil, fmt.Errorf("%w: unexpected message", ErrMalformed)
+	}
+	return resp, nil
+}
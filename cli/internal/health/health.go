@@ -0,0 +1,70 @@
+package health
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Status tracks whether the client is currently able to keep its DNS record up to date.
+// When a file path is given, the file exists only while the client is healthy, so that
+// container orchestrators can probe it (e.g. `test -f`).
+type Status struct {
+	mu      sync.Mutex
+	file    string
+	healthy bool
+	reason  string
+	since   time.Time
+}
+
+// New creates a new Status, initially healthy
+func New(file string) *Status {
+	s := &Status{file: file}
+	s.SetHealthy()
+	return s
+}
+
+// SetHealthy marks the client as healthy. It returns true if the status changed.
+func (s *Status) SetHealthy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := !s.healthy
+	s.healthy = true
+	s.reason = ""
+	if changed {
+		s.since = time.Now()
+	}
+
+	if s.file != "" {
+		_ = os.WriteFile(s.file, []byte(time.Now().Format(time.RFC3339)+"\n"), 0o644)
+	}
+
+	return changed
+}
+
+// SetUnhealthy marks the client as unhealthy for the given reason. It returns true if the status changed.
+func (s *Status) SetUnhealthy(reason string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := s.healthy
+	s.healthy = false
+	s.reason = reason
+	if changed {
+		s.since = time.Now()
+	}
+
+	if s.file != "" {
+		_ = os.Remove(s.file)
+	}
+
+	return changed
+}
+
+// Get returns the current health, the reason of the failure if unhealthy, and since when it holds
+func (s *Status) Get() (healthy bool, reason string, since time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.healthy, s.reason, s.since
+}
@@ -0,0 +1,139 @@
+package ip
+
+import (
+	"net/netip"
+)
+
+// Class is the kind of range an IP address belongs to
+type Class string
+
+// Address classes, as used in machine-readable output
+const (
+	ClassPublic        Class = "public"        // Globally routable
+	ClassPrivate       Class = "private"       // RFC 1918
+	ClassShared        Class = "shared"        // RFC 6598 shared address space, used by carrier-grade NAT
+	ClassUniqueLocal   Class = "unique-local"  // RFC 4193 IPv6 unique local addresses
+	ClassDocumentation Class = "documentation" // RFC 5737, RFC 3849, RFC 9637
+	ClassLoopback      Class = "loopback"
+	ClassLinkLocal     Class = "link-local"
+	ClassReserved      Class = "reserved" // Any other special-purpose range
+)
+
+// reservedRange is a special-purpose range (RFC 6890 registries) that can never be the public address of a host
+type reservedRange struct {
+	prefix netip.Prefix
+	class  Class
+	reason string
+}
+
+// reservedRanges lists the special-purpose ranges, an address outside all of them is public
+var reservedRanges = []reservedRange{
+	// IPv4
+	{netip.MustParsePrefix("0.0.0.0/8"), ClassReserved, "unspecified (RFC 1122)"},
+	{netip.MustParsePrefix("10.0.0.0/8"), ClassPrivate, "private (RFC 1918)"},
+	{netip.MustParsePrefix("100.64.0.0/10"), ClassShared, "shared address space of carrier-grade NAT (RFC 6598)"},
+	{netip.MustParsePrefix("127.0.0.0/8"), ClassLoopback, "loopback (RFC 1122)"},
+	{netip.MustParsePrefix("169.254.0.0/16"), ClassLinkLocal, "link-local (RFC 3927)"},
+	{netip.MustParsePrefix("172.16.0.0/12"), ClassPrivate, "private (RFC 1918)"},
+	{netip.MustParsePrefix("192.0.0.0/24"), ClassReserved, "IETF protocol assignments (RFC 6890)"},
+	{netip.MustParsePrefix("192.0.2.0/24"), ClassDocumentation, "documentation (RFC 5737)"},
+	{netip.MustParsePrefix("192.168.0.0/16"), ClassPrivate, "private (RFC 1918)"},
+	{netip.MustParsePrefix("198.18.0.0/15"), ClassReserved, "benchmarking (RFC 2544)"},
+	{netip.MustParsePrefix("198.51.100.0/24"), ClassDocumentation, "documentation (RFC 5737)"},
+	{netip.MustParsePrefix("203.0.113.0/24"), ClassDocumentation, "documentation (RFC 5737)"},
+	{netip.MustParsePrefix("224.0.0.0/4"), ClassReserved, "multicast (RFC 5771)"},
+	{netip.MustParsePrefix("240.0.0.0/4"), ClassReserved, "reserved (RFC 1112)"},
+
+	// IPv6
+	{netip.MustParsePrefix("::/128"), ClassReserved, "unspecified (RFC 4291)"},
+	{netip.MustParsePrefix("::1/128"), ClassLoopback, "loopback (RFC 4291)"},
+	{netip.MustParsePrefix("64:ff9b:1::/48"), ClassReserved, "local-use NAT64 (RFC 8215)"},
+	{netip.MustParsePrefix("100::/64"), ClassReserved, "discard-only (RFC 6666)"},
+	{netip.MustParsePrefix("2001:db8::/32"), ClassDocumentation, "documentation (RFC 3849)"},
+	{netip.MustParsePrefix("3fff::/20"), ClassDocumentation, "documentation (RFC 9637)"},
+	{netip.MustParsePrefix("fc00::/7"), ClassUniqueLocal, "unique local (RFC 4193)"},
+	{netip.MustParsePrefix("fe80::/10"), ClassLinkLocal, "link-local (RFC 4291)"},
+	{netip.MustParsePrefix("ff00::/8"), ClassReserved, "multicast (RFC 4291)"},
+}
+
+// reservedRangeOf returns the special-purpose range holding addr, nil if addr is public
+func reservedRangeOf(addr netip.Addr) *reservedRange {
+	addr = addr.Unmap()
+	for i := range reservedRanges {
+		if reservedRanges[i].prefix.Contains(addr) {
+			return &reservedRanges[i]
+		}
+	}
+	return nil
+}
+
+// Classify returns the class of an IP address
+func Classify(addr netip.Addr) Class {
+	if r := reservedRangeOf(addr); r != nil {
+		return r.class
+	}
+	return ClassPublic
+}
+
+// familyOfAddr returns the family of a valid IP address
+func familyOfAddr(addr netip.Addr) Family {
+	if addr.Unmap().Is4() {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+// Topology describes how the host reaches the Internet, deduced from its local addresses
+type Topology string
+
+// Network topologies, as used in machine-readable output
+const (
+	// TopologyUnknown is used when the host has no usable local address of the family, or reaches
+	// the sources through a proxy
+	TopologyUnknown Topology = ""
+	// TopologyDirect means the public address is assigned to the host: the DNS record reaches it
+	TopologyDirect Topology = "direct"
+	// TopologyNAT means the host has private addresses only, behind a router translating them.
+	// The router may itself be behind another NAT, which cannot be told from the host.
+	TopologyNAT Topology = "nat"
+	// TopologyCGNAT means the host has an address of the shared range of carrier-grade NAT (RFC 6598):
+	// the public address is shared with other customers of the carrier and does not reach the host
+	TopologyCGNAT Topology = "cgnat"
+)
+
+// LocalAddress is an address assigned to the host, on the path of the detection
+type LocalAddress struct {
+	Address string
+	Class   Class
+}
+
+// ClassifyTopology deduces the topology of the network from the public address and the local
+// addresses the detection went out from. Local addresses of another family, loopback and link-local
+// ones are ignored.
+func ClassifyTopology(public netip.Addr, local []netip.Addr) Topology {
+	public = public.Unmap()
+	topology := TopologyUnknown
+
+	for _, addr := range local {
+		addr = addr.Unmap()
+		if familyOfAddr(addr) != familyOfAddr(public) {
+			continue
+		}
+
+		switch Classify(addr) {
+		case ClassLoopback, ClassLinkLocal:
+			continue
+		case ClassShared:
+			topology = TopologyCGNAT
+		}
+
+		if addr == public {
+			return TopologyDirect
+		}
+		if topology == TopologyUnknown {
+			topology = TopologyNAT
+		}
+	}
+
+	return topology
+}
@@ -0,0 +1,114 @@
+package ip_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/qalisa/pierceflare/cli/internal/ip"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+	"github.com/qalisa/pierceflare/cli/internal/transport"
+)
+
+func TestClassifyTopology(t *testing.T) {
+	tests := []struct {
+		name   string
+		public string
+		local  []string
+		want   ip.Topology
+	}{
+		{"public address assigned", "93.184.216.34", []string{"93.184.216.34"}, ip.TopologyDirect},
+		{"RFC 1918 10/8", "93.184.216.34", []string{"10.0.0.2"}, ip.TopologyNAT},
+		{"RFC 1918 172.16/12", "93.184.216.34", []string{"172.20.1.2"}, ip.TopologyNAT},
+		{"RFC 1918 192.168/16", "93.184.216.34", []string{"192.168.1.10"}, ip.TopologyNAT},
+		{"CGNAT 100.64/10", "93.184.216.34", []string{"100.64.0.1"}, ip.TopologyCGNAT},
+		{"CGNAT upper bound", "93.184.216.34", []string{"100.127.255.254"}, ip.TopologyCGNAT},
+		{"past CGNAT", "93.184.216.34", []string{"100.128.0.1"}, ip.TopologyNAT},
+		{"CGNAT besides private", "93.184.216.34", []string{"192.168.1.10", "100.72.3.4"}, ip.TopologyCGNAT},
+		{"public besides private", "93.184.216.34", []string{"192.168.1.10", "93.184.216.34"}, ip.TopologyDirect},
+		{"IPv6 assigned", "2606:2800:220:1::248", []string{"2606:2800:220:1::248"}, ip.TopologyDirect},
+		{"IPv6 ULA", "2606:2800:220:1::248", []string{"fd12:3456:789a::1"}, ip.TopologyNAT},
+		{"IPv6 link-local only", "2606:2800:220:1::248", []string{"fe80::1"}, ip.TopologyUnknown},
+		{"IPv6 link-local besides assigned", "2606:2800:220:1::248", []string{"fe80::1", "2606:2800:220:1::248"}, ip.TopologyDirect},
+		{"IPv4 link-local", "93.184.216.34", []string{"169.254.10.1"}, ip.TopologyUnknown},
+		{"loopback", "93.184.216.34", []string{"127.0.0.1", "::1"}, ip.TopologyUnknown},
+		{"other family", "93.184.216.34", []string{"2606:2800:220:1::248", "fd12:3456:789a::1"}, ip.TopologyUnknown},
+		{"IPv4-mapped", "93.184.216.34", []string{"::ffff:93.184.216.34"}, ip.TopologyDirect},
+		{"no local address", "93.184.216.34", nil, ip.TopologyUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := make([]netip.Addr, len(tt.local))
+			for i, addr := range tt.local {
+				local[i] = netip.MustParseAddr(addr)
+			}
+			if got := ip.ClassifyTopology(netip.MustParseAddr(tt.public), local); got != tt.want {
+				t.Errorf("ClassifyTopology(%s, %v) = %q, want %q", tt.public, tt.local, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopologyThroughProxy(t *testing.T) {
+	echo := testserver.NewEcho(testserver.EchoIP(testAddress))
+	defer echo.Close()
+
+	// Forwards nothing: answers for the echo service, like a proxy would relay it
+	var proxied int
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, testAddress)
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		name    string
+		proxy   string
+		wantLog string
+	}{
+		{name: "direct", wantLog: "Network topology:"},
+		{name: "proxy", proxy: proxy.URL, wantLog: "reached through a proxy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log := logger.New(false, logger.LogLevelDebug, 0)
+			log.SetOutput(&logs)
+
+			// The egress path would be found direct, if it were looked at
+			r, err := ip.NewRetriever(log, transport.Options{Proxy: tt.proxy},
+				ip.WithSources(echo.Source()),
+				ip.WithLocalAddresses(func() ([]netip.Addr, error) {
+					return []netip.Addr{netip.MustParseAddr(testAddress)}, nil
+				}),
+			)
+			if err != nil {
+				t.Fatalf("NewRetriever: %v", err)
+			}
+
+			result, err := r.Lookup(context.Background(), ip.FamilyIPv4)
+			if err != nil {
+				t.Fatalf("Lookup: %v", err)
+			}
+			if result.Topology != ip.TopologyUnknown || len(result.Local) != 0 {
+				t.Errorf("topology = %q, local = %+v, want unknown without local address", result.Topology, result.Local)
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("logs do not mention %q:\n%s", tt.wantLog, logs.String())
+			}
+		})
+	}
+
+	if proxied != 1 || echo.Hits() != 1 {
+		t.Errorf("%d requests through the proxy, %d direct, want 1 each", proxied, echo.Hits())
+	}
+}
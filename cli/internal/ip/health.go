@@ -0,0 +1,209 @@
+package ip
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Parameters of the health tracking of sources
+const (
+	// healthWeight is the weight of the last query in the moving averages of a source
+	healthWeight = 0.3
+	// breakerThreshold is the number of failures in a row after which a source is skipped
+	breakerThreshold = 3
+	// breakerCooldown is how long a source is first skipped, doubled each time it fails again after it
+	breakerCooldown    = time.Minute
+	maxBreakerCooldown = 30 * time.Minute
+	// unknownLatency is the latency assumed for a source never queried: it is tried after the sources
+	// known to answer faster, before the ones known to be slower or failing
+	unknownLatency = time.Second
+	// rankResolution groups sources of similar performance, which keep their configured order
+	rankResolution = 100 * time.Millisecond
+)
+
+// SourceHealth describes the recent queries of a source, for a family
+type SourceHealth struct {
+	Source string // Name of the source
+	Family Family // Family of the lookups
+
+	Queries             uint64        // Number of queries
+	Failures            uint64        // Number of rejected answers
+	SuccessRate         float64       // Moving average of the successes, 1 before the first query
+	Latency             time.Duration // Moving average of the duration of successful queries, 0 before the first success
+	ConsecutiveFailures int           // Failures since the last success
+	SkippedUntil        time.Time     // The source is not queried before this time (circuit breaker), zero if it is
+
+	LastSuccess time.Time // When the source last gave a valid address, zero if never
+	LastFailure time.Time // When the answer of the source was last rejected, zero if never
+	LastError   string    // Why the answer was rejected, if the last query failed
+}
+
+// cost is the expected duration of a query of the source: its latency when it answers, the timeout
+// when it fails
+func (h *SourceHealth) cost(timeout time.Duration) time.Duration {
+	if h.Queries == 0 {
+		return unknownLatency
+	}
+	latency := h.Latency
+	if h.LastSuccess.IsZero() {
+		latency = timeout
+	}
+	cost := h.SuccessRate*float64(latency) + (1-h.SuccessRate)*float64(timeout)
+	return time.Duration(cost).Round(rankResolution)
+}
+
+// healthKey identifies the health of a source for a family: a source may only serve one of them
+type healthKey struct {
+	source string
+	family Family
+}
+
+// sourceState is the health of a source, with the state of its circuit breaker
+type sourceState struct {
+	SourceHealth
+	trips int // Times the circuit breaker opened since the last success
+}
+
+// healthTracker records the outcome of the queries of each source
+type healthTracker struct {
+	mu      sync.Mutex
+	timeout time.Duration // Duration of a failed query, at worst
+	sources map[healthKey]*sourceState
+	order   map[Family][]string // Order of the last lookup of each family
+}
+
+// state returns the health of source for family, creating it
+func (t *healthTracker) state(source string, family Family) *sourceState {
+	if t.sources == nil {
+		t.sources = map[healthKey]*sourceState{}
+	}
+	key := healthKey{source, family}
+	s, ok := t.sources[key]
+	if !ok {
+		s = &sourceState{SourceHealth: SourceHealth{Source: source, Family: family, SuccessRate: 1}}
+		t.sources[key] = s
+	}
+	return s
+}
+
+// breakerEvent is a change of the circuit breaker of a source
+type breakerEvent int
+
+const (
+	breakerUnchanged breakerEvent = iota
+	breakerOpened                 // The source failed too many times in a row, it is skipped for a while
+	breakerClosed                 // The source answered again after being skipped
+)
+
+// record stores the outcome of a query of source, err being nil if it gave a valid address.
+// It returns the change of the circuit breaker of the source, and how long it is skipped if opened.
+func (t *healthTracker) record(source string, family Family, at time.Time, latency time.Duration, err error) (breakerEvent, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.state(source, family)
+	s.Queries++
+
+	if err == nil {
+		s.SuccessRate += healthWeight * (1 - s.SuccessRate)
+		if s.LastSuccess.IsZero() {
+			s.Latency = latency
+		} else {
+			s.Latency += time.Duration(healthWeight * float64(latency-s.Latency))
+		}
+		s.LastSuccess = at
+		s.LastError = ""
+		s.ConsecutiveFailures = 0
+		s.SkippedUntil = time.Time{}
+
+		tripped := s.trips > 0
+		s.trips = 0
+		if tripped {
+			return breakerClosed, 0
+		}
+		return breakerUnchanged, 0
+	}
+
+	s.Failures++
+	s.SuccessRate -= healthWeight * s.SuccessRate
+	s.LastFailure = at
+	s.LastError = err.Error()
+	s.ConsecutiveFailures++
+	if s.ConsecutiveFailures < breakerThreshold {
+		return breakerUnchanged, 0
+	}
+
+	cooldown := breakerCooldown
+	for i := 0; i < s.trips && cooldown < maxBreakerCooldown; i++ {
+		cooldown *= 2
+	}
+	cooldown = min(cooldown, maxBreakerCooldown)
+	s.trips++
+	s.SkippedUntil = at.Add(cooldown)
+	return breakerOpened, cooldown
+}
+
+// rank orders sources for a lookup of family at now: sources whose circuit breaker is open are skipped,
+// the others are sorted by expected cost, sources of similar performance keeping their configured order.
+// If every source is skipped, they are all tried anyway. It also reports whether the order differs
+// from the one of the previous lookup.
+func (t *healthTracker) rank(sources []Source, family Family, now time.Time) ([]Source, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	costs := make(map[string]time.Duration, len(sources))
+	var available, skipped []Source
+	for _, source := range sources {
+		s := t.state(source.Name(), family)
+		costs[source.Name()] = s.cost(t.timeout)
+		if now.Before(s.SkippedUntil) {
+			skipped = append(skipped, source)
+		} else {
+			available = append(available, source)
+		}
+	}
+	if len(available) == 0 {
+		available = skipped
+	}
+
+	ranked := slices.Clone(available)
+	slices.SortStableFunc(ranked, func(a, b Source) int {
+		return cmp.Compare(costs[a.Name()], costs[b.Name()])
+	})
+
+	names := make([]string, len(ranked))
+	for i, source := range ranked {
+		names[i] = source.Name()
+	}
+	if t.order == nil {
+		t.order = map[Family][]string{}
+	}
+	previous, known := t.order[family]
+	t.order[family] = names
+	return ranked, known && !slices.Equal(previous, names)
+}
+
+// Health returns the health of each source, in the order they are configured: for the families looked
+// up so far, for FamilyAny before the first lookup
+func (r *Retriever) Health() []SourceHealth {
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+
+	var health []SourceHealth
+	for _, family := range []Family{FamilyAny, FamilyIPv4, FamilyIPv6} {
+		if _, looked := r.health.order[family]; !looked {
+			continue
+		}
+		for _, source := range r.sources {
+			health = append(health, r.health.state(source.Name(), family).SourceHealth)
+		}
+	}
+	if health == nil {
+		for _, source := range r.sources {
+			health = append(health, SourceHealth{Source: source.Name(), Family: FamilyAny, SuccessRate: 1})
+		}
+	}
+	return health
+}
@@ -0,0 +1,73 @@
+package ip
+
+import (
+	"context"
+)
+
+// hedgedAnswer is the outcome of a query of a hedged lookup
+type hedgedAnswer struct {
+	index int // Index of the source
+	found answer
+	err   error
+}
+
+// lookupHedged queries the sources in order, starting the next one when the queries running did not
+// answer within the hedging delay or when one of them failed. The first valid address wins, the queries
+// still running are canceled. It returns the winning source and its answer, nil if none gave a valid
+// address, and the rejected answers in the order of the sources. Canceling ctx stops every query.
+func (r *Retriever) lookupHedged(ctx context.Context, family Family, sources []Source) (Source, answer, []*SourceError) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Buffered so that the queries canceled after the win do not block
+	answers := make(chan hedgedAnswer, len(sources))
+	next, running := 0, 0
+	start := func() {
+		index := next
+		next++
+		running++
+		go func() {
+			found, err := r.query(ctx, sources[index], family)
+			answers <- hedgedAnswer{index: index, found: found, err: err}
+		}()
+	}
+
+	ticker := r.clock.NewTicker(r.hedgeDelay)
+	defer ticker.Stop()
+
+	errs := make([]error, len(sources))
+	start()
+	for running > 0 {
+		select {
+		case hedged := <-answers:
+			running--
+			if hedged.err == nil {
+				return sources[hedged.index], hedged.found, rejectedAnswers(sources, errs)
+			}
+			errs[hedged.index] = hedged.err
+			if next < len(sources) && ctx.Err() == nil {
+				start()
+				ticker.Reset(r.hedgeDelay)
+			}
+
+		case <-ticker.C():
+			if next < len(sources) && ctx.Err() == nil {
+				r.logger.Debug("No answer within %s, also asking %s", r.hedgeDelay, sources[next].Name())
+				start()
+			}
+		}
+	}
+
+	return nil, answer{}, rejectedAnswers(sources, errs)
+}
+
+// rejectedAnswers lists the failed queries, in the order of the sources
+func rejectedAnswers(sources []Source, errs []error) []*SourceError {
+	var rejected []*SourceError
+	for i, err := range errs {
+		if err != nil {
+			rejected = append(rejected, &SourceError{Source: sources[i].Name(), Err: err})
+		}
+	}
+	return rejected
+}
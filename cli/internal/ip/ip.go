@@ -1,70 +1,403 @@
 package ip
 
 import (
-	"io"
+	"context"
+	"errors"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/qalisa/pierceflare/cli/internal/clock"
 	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/transport"
 )
 
-var ipServices = []string{
-	"https://ifconfig.me",
-	"https://api.ipify.org",
-	"https://icanhazip.com",
+// Family restricts IP detection to an address family
+type Family int
+
+const (
+	// FamilyAny lets the system pick the address family used to reach the IP services
+	FamilyAny Family = iota
+	// FamilyIPv4 detects the public IPv4 address
+	FamilyIPv4
+	// FamilyIPv6 detects the public IPv6 address
+	FamilyIPv6
+)
+
+// String returns the name of the family, as used in machine-readable output
+func (f Family) String() string {
+	switch f {
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	default:
+		return "any"
+	}
+}
+
+// version returns the IP version of the family, 0 for FamilyAny
+func (f Family) version() int {
+	switch f {
+	case FamilyIPv4:
+		return 4
+	case FamilyIPv6:
+		return 6
+	default:
+		return 0
+	}
+}
+
+// Result describes a successful IP detection
+type Result struct {
+	Address  string        // Detected IP address
+	Family   Family        // Family of the detected address (never FamilyAny)
+	Source   string        // Service that gave the address
+	Duration time.Duration // Time spent detecting the address, including failed attempts
+
+	Topology Topology       // Network topology deduced from the local address the detection went out from
+	Local    []LocalAddress // Local addresses of the family on the path of the detection, except loopback and link-local ones
+}
+
+// answer is a valid answer of a source
+type answer struct {
+	addr  netip.Addr // Address given by the source
+	local netip.Addr // Local address of the connection that reached the source, invalid if unknown
+
+	// proxied is set when the source was reached through a proxy: the local address of the connection
+	// is then the one facing the proxy, telling nothing about the path to the Internet
+	proxied bool
 }
 
 // Retriever handles the retrieval of external IP addresses
 type Retriever struct {
-	logger *logger.Logger
-	client *http.Client
+	logger  *logger.Logger
+	clients map[Family]*http.Client
+	sources []Source
+	clock   clock.Clock
+	health  healthTracker
+
+	// hedgeDelay is how long a source is waited for before the next one is queried too, 0 to query
+	// the sources one after the other
+	hedgeDelay time.Duration
+
+	// localAddrs lists the addresses of the host the detection may go out from, when the local address
+	// of the connection to the source is unknown (sources not using the given HTTP client)
+	localAddrs func() ([]netip.Addr, error)
 }
 
-// NewRetriever creates a new instance of Retriever
-func NewRetriever(logger *logger.Logger) *Retriever {
-	return &Retriever{
-		logger: logger,
-		client: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+// Option customizes a Retriever
+type Option func(*Retriever)
+
+// WithSources replaces the sources queried, in order (DefaultSources by default)
+func WithSources(sources ...Source) Option {
+	return func(r *Retriever) {
+		r.sources = sources
+	}
+}
+
+// WithHedging queries the next source when the previous ones did not answer within delay, without
+// canceling them: the first valid answer is used, the queries still running are canceled. A failed
+// query starts the next one right away. With 0 (the default), sources are queried one after the other.
+func WithHedging(delay time.Duration) Option {
+	return func(r *Retriever) {
+		r.hedgeDelay = delay
+	}
+}
+
+// WithClock replaces the clock used to time detections
+func WithClock(c clock.Clock) Option {
+	return func(r *Retriever) {
+		r.clock = c
+	}
+}
+
+// WithLocalAddresses replaces the listing of the local addresses compared to the public address when the
+// local address of the connection to the source is unknown
+func WithLocalAddresses(fn func() ([]netip.Addr, error)) Option {
+	return func(r *Retriever) {
+		r.localAddrs = fn
 	}
 }
 
+// DefaultTimeout is the timeout of each call to an IP service
+const DefaultTimeout = 5 * time.Second
+
+// NewRetriever creates a new instance of Retriever.
+// The transport options apply to the calls to IP services; Timeout defaults to DefaultTimeout.
+func NewRetriever(logger *logger.Logger, opts transport.Options, options ...Option) (*Retriever, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	r := &Retriever{
+		logger:  logger,
+		clients: map[Family]*http.Client{},
+		sources: DefaultSources(),
+		clock:   clock.Real,
+		health:  healthTracker{timeout: opts.Timeout},
+	}
+	r.localAddrs = func() ([]netip.Addr, error) {
+		return localAddresses(opts.SourceAddress, opts.Interface)
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	// One client per family, only dialing connections of that family
+	for _, family := range []Family{FamilyAny, FamilyIPv4, FamilyIPv6} {
+		opts.Family = family.version()
+		client, err := transport.NewClient(opts)
+		if err != nil {
+			return nil, err
+		}
+		traceProxy(client)
+		r.clients[family] = client
+	}
+
+	return r, nil
+}
+
 // IsValidIP checks if a string is a valid IPv4 or IPv6 address
 func IsValidIP(ip string) bool {
 	return net.ParseIP(ip) != nil
 }
 
 // GetCurrentIP attempts to obtain the current external IP address
-func (r *Retriever) GetCurrentIP() (string, error) {
-	for _, service := range ipServices {
-		r.logger.Debug("Attempting to retrieve IP from %s", service)
+func (r *Retriever) GetCurrentIP(ctx context.Context) (string, error) {
+	result, err := r.Lookup(ctx, FamilyAny)
+	if err != nil {
+		return "", err
+	}
+	return result.Address, nil
+}
+
+// Lookup attempts to obtain the current external IP address of the given family.
+// Answers that are not a single public address of that family are rejected; if every source
+// fails, the returned *LookupError explains why each one was rejected. Canceling ctx stops the queries.
+func (r *Retriever) Lookup(ctx context.Context, family Family) (*Result, error) {
+	start := r.clock.Now()
+
+	// Sources failing or slow lately are tried last, or skipped for a while
+	sources, reordered := r.health.rank(r.sources, family, start)
+	if reordered {
+		names := make([]string, len(sources))
+		for i, source := range sources {
+			names[i] = source.Name()
+		}
+		r.logger.Debug("IP sources reordered by recent performance: %s", strings.Join(names, ", "))
+	}
+
+	var source Source
+	var found answer
+	var rejected []*SourceError
+	if r.hedgeDelay > 0 && len(sources) > 1 {
+		source, found, rejected = r.lookupHedged(ctx, family, sources)
+	} else {
+		source, found, rejected = r.lookupInTurn(ctx, family, sources)
+	}
+
+	if source == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if family == FamilyAny {
+			r.logger.Error("Failed to retrieve IP from all services")
+		} else {
+			r.logger.Error("Failed to retrieve %s address from all services", family)
+		}
+		return nil, &LookupError{Family: family, Sources: rejected}
+	}
+
+	r.logger.Debug("IP retrieved: %s", found.addr)
+	result := &Result{
+		Address:  found.addr.String(),
+		Family:   familyOfAddr(found.addr),
+		Source:   source.Name(),
+		Duration: r.clock.Now().Sub(start),
+	}
+	r.classify(result, found)
+	return result, nil
+}
+
+// lookupInTurn queries the sources one after the other, until one gives a valid address. It returns
+// that source and its answer, nil if none did, and the rejected answers.
+func (r *Retriever) lookupInTurn(ctx context.Context, family Family, sources []Source) (Source, answer, []*SourceError) {
+	var rejected []*SourceError
+	for _, source := range sources {
+		found, err := r.query(ctx, source, family)
+		if err == nil {
+			return source, found, rejected
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		rejected = append(rejected, &SourceError{Source: source.Name(), Err: err})
+	}
+	return nil, answer{}, rejected
+}
+
+// query asks source for the address of family, recording the health of the source unless the query
+// was canceled. The local address of the connection that reached the source is traced.
+func (r *Retriever) query(ctx context.Context, source Source, family Family) (answer, error) {
+	r.logger.Debug("Attempting to retrieve %s address from %s", family, source.Name())
+
+	var found answer
+	var proxied atomic.Bool
+	ctx = context.WithValue(ctx, proxiedKey{}, &proxied)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if addr, ok := info.Conn.LocalAddr().(*net.TCPAddr); ok {
+				found.local = addr.AddrPort().Addr().Unmap()
+			}
+		},
+	}
 
-		resp, err := r.client.Get(service)
+	queried := r.clock.Now()
+	raw, err := source.Fetch(httptrace.WithClientTrace(ctx, trace), r.clients[family])
+	found.proxied = proxied.Load()
+	if err == nil {
+		found.addr, err = parseAnswer(raw, family)
+	}
+	if err != nil && ctx.Err() != nil {
+		return found, err // Another source answered first
+	}
+
+	r.recordHealth(source, family, queried, err)
+	if err != nil {
+		r.logger.Debug("Rejected answer of %s: %v", source.Name(), err)
+	}
+	return found, err
+}
+
+// recordHealth records the outcome of a query of source started at queried, logging the changes of
+// its circuit breaker
+func (r *Retriever) recordHealth(source Source, family Family, queried time.Time, err error) {
+	event, cooldown := r.health.record(source.Name(), family, queried, r.clock.Now().Sub(queried), err)
+	switch event {
+	case breakerOpened:
+		r.logger.Info("IP source %s keeps failing (%v), skipped for %s", source.Name(), err, cooldown)
+	case breakerClosed:
+		r.logger.Info("IP source %s answers again", source.Name())
+	}
+}
+
+// classify fills the topology of a detection from the local address it went out from: the one of the
+// connection to the source, the addresses of the egress path when unknown. Addresses of unrelated
+// interfaces (VPN, containers) do not matter. Through a proxy, the topology is unknown.
+func (r *Retriever) classify(result *Result, found answer) {
+	if found.proxied {
+		r.logger.Debug("Network topology unknown, %s reached through a proxy", result.Source)
+		return
+	}
+
+	local := []netip.Addr{found.local}
+	if !found.local.IsValid() {
+		var err error
+		if local, err = r.localAddrs(); err != nil {
+			r.logger.Debug("Unable to list local addresses: %v", err)
+			return
+		}
+	}
+
+	result.Topology = ClassifyTopology(found.addr, local)
+	for _, addr := range local {
+		addr = addr.Unmap()
+		class := Classify(addr)
+		if familyOfAddr(addr) == result.Family && class != ClassLoopback && class != ClassLinkLocal {
+			result.Local = append(result.Local, LocalAddress{Address: addr.String(), Class: class})
+		}
+	}
+	r.logger.Debug("Network topology: %s", result.Topology)
+}
+
+// proxiedKey is the context key of the flag set when a query goes through a proxy
+type proxiedKey struct{}
+
+// traceProxy makes the queries sent through client flag their context when they go through a proxy,
+// configured or from the environment (HTTPS_PROXY)
+func traceProxy(client *http.Client) {
+	t, ok := client.Transport.(*http.Transport)
+	if !ok || t.Proxy == nil {
+		return
+	}
+	proxy := t.Proxy
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := proxy(req)
+		if proxied, ok := req.Context().Value(proxiedKey{}).(*atomic.Bool); ok && proxyURL != nil {
+			proxied.Store(true)
+		}
+		return proxyURL, err
+	}
+}
+
+// localAddresses lists the addresses outgoing connections may use: the source address they are bound
+// to, the addresses of the interface they are bound to, or the addresses the routing table picks to
+// reach the Internet
+func localAddresses(sourceAddress, iface string) ([]netip.Addr, error) {
+	if sourceAddress != "" {
+		addr, err := netip.ParseAddr(sourceAddress)
 		if err != nil {
-			r.logger.Debug("Error connecting to %s: %v", service, err)
+			return nil, err
+		}
+		return []netip.Addr{addr}, nil
+	}
+
+	if iface == "" {
+		return routeAddresses(), nil
+	}
+
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	ifaceAddrs, err := i.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]netip.Addr, 0, len(ifaceAddrs))
+	for _, a := range ifaceAddrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
 			continue
 		}
+		if addr, ok := netip.AddrFromSlice(ipNet.IP); ok {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	return addrs, nil
+}
 
-		defer resp.Body.Close()
+// routeTargets are public addresses whose route gives the egress address of each family
+var routeTargets = []string{"udp4:1.1.1.1:53", "udp6:[2606:4700:4700::1111]:53"}
 
-		body, err := io.ReadAll(resp.Body)
+// routeAddresses returns the local addresses the routing table picks to reach the Internet, for the
+// families with a default route. Nothing is sent: connecting a UDP socket only selects the route.
+func routeAddresses() []netip.Addr {
+	var addrs []netip.Addr
+	for _, target := range routeTargets {
+		network, address, _ := strings.Cut(target, ":")
+		conn, err := net.Dial(network, address)
 		if err != nil {
-			r.logger.Debug("Error reading response: %v", err)
 			continue
 		}
-
-		ip := string(body)
-		if IsValidIP(ip) {
-			r.logger.Debug("IP retrieved: %s", ip)
-			return ip, nil
+		if local, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			addrs = append(addrs, local.AddrPort().Addr().Unmap())
 		}
+		conn.Close()
 	}
-
-	r.logger.Error("Failed to retrieve IP from all services")
-	return "", ErrNoIPFound
+	return addrs
 }
 
+// ErrBehindCGNAT is returned when flaring is refused because the host is behind carrier-grade NAT
+var ErrBehindCGNAT = errors.New("behind carrier-grade NAT: the public address does not reach this host")
+
 // ErrNoIPFound is returned when no valid IP address could be found
 var ErrNoIPFound = net.InvalidAddrError("no valid IP address could be found")
@@ -0,0 +1,175 @@
+package ip_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/clock"
+	"github.com/qalisa/pierceflare/cli/internal/ip"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+	"github.com/qalisa/pierceflare/cli/internal/transport"
+)
+
+const testAddress = "93.184.216.34"
+
+// newRetriever creates a retriever querying a fake echo service per response, in order
+func newRetriever(t *testing.T, timeout time.Duration, responses ...testserver.EchoResponse) (*ip.Retriever, []*testserver.Echo) {
+	t.Helper()
+
+	echoes := make([]*testserver.Echo, len(responses))
+	sources := make([]ip.Source, len(responses))
+	for i, response := range responses {
+		echoes[i] = testserver.NewEcho(response)
+		t.Cleanup(echoes[i].Close)
+		sources[i] = echoes[i].Source()
+	}
+
+	log := logger.New(false, logger.LogLevelDebug, 0)
+	log.SetOutput(io.Discard)
+	r, err := ip.NewRetriever(log, transport.Options{Timeout: timeout},
+		ip.WithSources(sources...),
+		ip.WithClock(clock.NewFake(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC))),
+	)
+	if err != nil {
+		t.Fatalf("NewRetriever: %v", err)
+	}
+	return r, echoes
+}
+
+func TestLookupFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []testserver.EchoResponse
+		family    ip.Family
+		want      int      // Index of the source expected to give testAddress, -1 if none should
+		rejected  []string // Expected reasons of the rejected sources, in order
+	}{
+		{
+			name:      "plain answer",
+			responses: []testserver.EchoResponse{testserver.EchoIP(testAddress)},
+			want:      0,
+		},
+		{
+			name:      "trailing newline",
+			responses: []testserver.EchoResponse{testserver.EchoLine(testAddress)},
+			want:      0,
+		},
+		{
+			name:      "garbage answer",
+			responses: []testserver.EchoResponse{testserver.EchoGarbage(), testserver.EchoLine(testAddress)},
+			want:      1,
+			rejected:  []string{"unexpected content type"},
+		},
+		{
+			name:      "not an address",
+			responses: []testserver.EchoResponse{testserver.EchoIP("Please log in"), testserver.EchoIP(testAddress)},
+			want:      1,
+			rejected:  []string{"not an IP address"},
+		},
+		{
+			name:      "private address",
+			responses: []testserver.EchoResponse{testserver.EchoIP("192.168.1.10"), testserver.EchoIP(testAddress)},
+			want:      1,
+			rejected:  []string{"not a public address"},
+		},
+		{
+			name:      "error status",
+			responses: []testserver.EchoResponse{testserver.EchoStatus(http.StatusServiceUnavailable), testserver.EchoIP(testAddress)},
+			want:      1,
+			rejected:  []string{"HTTP 503"},
+		},
+		{
+			name:      "timeout",
+			responses: []testserver.EchoResponse{testserver.EchoHang(5 * time.Second), testserver.EchoIP(testAddress)},
+			want:      1,
+			rejected:  []string{"Timeout"},
+		},
+		{
+			name:      "wrong family",
+			responses: []testserver.EchoResponse{testserver.EchoIP("2606:2800:220:1:248:1893:25c8:1946"), testserver.EchoIP(testAddress)},
+			family:    ip.FamilyIPv4,
+			want:      1,
+			rejected:  []string{"is not an ipv4 address"},
+		},
+		{
+			name:      "every source failing",
+			responses: []testserver.EchoResponse{testserver.EchoGarbage(), testserver.EchoHang(5 * time.Second)},
+			want:      -1,
+			rejected:  []string{"unexpected content type", "Timeout"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, echoes := newRetriever(t, 200*time.Millisecond, tt.responses...)
+
+			result, err := r.Lookup(context.Background(), tt.family)
+
+			if tt.want < 0 {
+				var lookupErr *ip.LookupError
+				if !errors.Is(err, ip.ErrNoIPFound) || !errors.As(err, &lookupErr) {
+					t.Fatalf("Lookup = %v, %v, want a LookupError", result, err)
+				}
+				checkRejected(t, lookupErr.Sources, tt.rejected)
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Lookup: %v", err)
+			}
+			if result.Address != testAddress || result.Family != ip.FamilyIPv4 {
+				t.Errorf("address = %s (%s), want %s (IPv4)", result.Address, result.Family, testAddress)
+			}
+			if want := echoes[tt.want].Source().Name(); result.Source != want {
+				t.Errorf("source = %s, want %s", result.Source, want)
+			}
+			for i, echo := range echoes[tt.want+1:] {
+				if echo.Hits() != 0 {
+					t.Errorf("source %d queried after a valid answer", tt.want+1+i)
+				}
+			}
+
+			// Rejections are only reported on failure, the health of the sources tells them otherwise
+			health := r.Health()
+			for i, reason := range tt.rejected {
+				if health[i].Failures != 1 || !strings.Contains(health[i].LastError, reason) {
+					t.Errorf("health of source %d = %d failures (%q), want one mentioning %q", i, health[i].Failures, health[i].LastError, reason)
+				}
+			}
+		})
+	}
+}
+
+// checkRejected compares the rejections of the sources with the expected reasons
+func checkRejected(t *testing.T, rejected []*ip.SourceError, want []string) {
+	t.Helper()
+
+	if len(rejected) != len(want) {
+		t.Fatalf("rejected = %v, want %d rejections", rejected, len(want))
+	}
+	for i, reason := range want {
+		if !strings.Contains(rejected[i].Error(), reason) {
+			t.Errorf("rejection %d = %q, want it to mention %q", i, rejected[i], reason)
+		}
+	}
+}
+
+func TestLookupCanceled(t *testing.T) {
+	r, _ := newRetriever(t, 5*time.Second, testserver.EchoHang(5*time.Second), testserver.EchoIP(testAddress))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if result, err := r.Lookup(ctx, ip.FamilyAny); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lookup = %v, %v, want the error of the context", result, err)
+	}
+	if health := r.Health(); health[0].Failures != 0 {
+		t.Errorf("canceled query recorded as a failure of %s", health[0].Source)
+	}
+}
@@ -0,0 +1,80 @@
+package ip
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// maxAnswerDisplay bounds the part of an invalid answer quoted in diagnostics
+const maxAnswerDisplay = 64
+
+// parseAnswer validates the answer of an echo service: a single public address of the expected family,
+// surrounding whitespace (such as the trailing newline of icanhazip.com) being ignored
+func parseAnswer(raw string, family Family) (netip.Addr, error) {
+	answer := strings.TrimSpace(raw)
+	if answer == "" {
+		return netip.Addr{}, fmt.Errorf("empty answer")
+	}
+
+	addr, err := netip.ParseAddr(answer)
+	if err != nil {
+		if len(answer) > maxAnswerDisplay {
+			answer = answer[:maxAnswerDisplay] + "..."
+		}
+		return netip.Addr{}, fmt.Errorf("not an IP address: %q", answer)
+	}
+	if addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("%s is scoped to a zone", addr)
+	}
+
+	// IPv4-mapped IPv6 addresses are IPv4 addresses
+	addr = addr.Unmap()
+
+	if family != FamilyAny && familyOfAddr(addr) != family {
+		return netip.Addr{}, fmt.Errorf("%s is not an %s address", addr, family)
+	}
+
+	if r := reservedRangeOf(addr); r != nil {
+		return netip.Addr{}, fmt.Errorf("%s is not a public address: %s", addr, r.reason)
+	}
+
+	return addr, nil
+}
+
+// SourceError explains why the answer of a source was rejected
+type SourceError struct {
+	Source string
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	return e.Source + ": " + e.Err.Error()
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// LookupError is returned when no source gave a valid address; it matches ErrNoIPFound
+// and details the rejection of each source
+type LookupError struct {
+	Family  Family
+	Sources []*SourceError
+}
+
+func (e *LookupError) Error() string {
+	if len(e.Sources) == 0 {
+		return ErrNoIPFound.Error() + " (no source configured)"
+	}
+
+	details := make([]string, len(e.Sources))
+	for i, s := range e.Sources {
+		details[i] = s.Error()
+	}
+	return ErrNoIPFound.Error() + " (" + strings.Join(details, "; ") + ")"
+}
+
+func (e *LookupError) Unwrap() error {
+	return ErrNoIPFound
+}
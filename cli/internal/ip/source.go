@@ -0,0 +1,79 @@
+package ip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// Source gives the public IP address of the host, as seen by a remote service
+type Source interface {
+	// Name identifies the source in logs and reports
+	Name() string
+	// Fetch returns the raw address given by the source, using client to reach it
+	Fetch(ctx context.Context, client *http.Client) (string, error)
+}
+
+// HTTPSource is an echo service answering the address of the caller in the body of a GET request
+type HTTPSource struct {
+	URL string
+}
+
+// DefaultSources returns the echo services queried by default, in order
+func DefaultSources() []Source {
+	return []Source{
+		HTTPSource{URL: "https://ifconfig.me"},
+		HTTPSource{URL: "https://api.ipify.org"},
+		HTTPSource{URL: "https://icanhazip.com"},
+	}
+}
+
+// Name returns the URL of the service
+func (s HTTPSource) Name() string {
+	return s.URL
+}
+
+// maxAnswerSize bounds the body read from an echo service; an IPv6 address is at most 45 characters
+const maxAnswerSize = 1024
+
+// Fetch queries the service. Only successful plain-text answers of reasonable size are accepted.
+func (s HTTPSource) Fetch(ctx context.Context, client *http.Client) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/plain")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		// Drain what is left so that the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxAnswerSize))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: HTTP %d", resp.StatusCode)
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "text/plain" {
+			return "", fmt.Errorf("unexpected content type %q", contentType)
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAnswerSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxAnswerSize {
+		return "", fmt.Errorf("answer larger than %d bytes", maxAnswerSize)
+	}
+
+	return string(body), nil
+}
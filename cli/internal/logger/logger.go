@@ -2,9 +2,13 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sync"
 	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/clock"
 )
 
 const LogTag = "[PierceFlare CLI]"
@@ -21,14 +25,31 @@ const (
 	LogLevelDebug
 )
 
+// Sink receives log messages instead of the standard output, e.g. to forward them to another logging library.
+// Messages are passed without tag, timestamp nor level marker.
+type Sink interface {
+	Log(level LogLevel, message string)
+}
+
+// FieldSink is a Sink also receiving the context of messages as structured fields (e.g. for journald)
+type FieldSink interface {
+	Sink
+	LogFields(level LogLevel, message string, fields map[string]string)
+}
+
 // Logger is a structure for managing application logs
 type Logger struct {
 	logger        *log.Logger
+	sink          Sink              // Receives messages instead of logger, if set
+	mu            sync.RWMutex      // Guards prefix and fields, messages may be logged from other goroutines
+	prefix        string            // Context prepended to every message (e.g. uplink name)
+	fields        map[string]string // Context passed to a FieldSink as structured fields
 	timestamped   bool
 	level         LogLevel
 	successPeriod int       // Number of successful executions between each success log (0 = log every success)
 	successCount  int       // Counter of successful executions
 	lastLogTime   time.Time // Last time a message was logged
+	clock         clock.Clock
 }
 
 // New creates a new Logger instance
@@ -40,7 +61,56 @@ func New(timestamped bool, level LogLevel, successPeriod int) *Logger {
 		successPeriod: successPeriod,
 		successCount:  0,
 		lastLogTime:   time.Now(),
+		clock:         clock.Real,
+	}
+}
+
+// NewWithSink creates a new Logger passing messages to sink
+func NewWithSink(level LogLevel, successPeriod int, sink Sink) *Logger {
+	l := New(false, level, successPeriod)
+	l.sink = sink
+	return l
+}
+
+// SetClock replaces the clock used to timestamp messages
+func (l *Logger) SetClock(c clock.Clock) {
+	l.clock = c
+	l.lastLogTime = c.Now()
+}
+
+// SetPrefix replaces the context prepended to every message, as "[prefix]" (empty for none)
+func (l *Logger) SetPrefix(prefix string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if prefix == "" {
+		l.prefix = ""
+		return
 	}
+	l.prefix = "[" + prefix + "] "
+}
+
+// SetField sets a structured field passed with every message to a FieldSink (empty value to remove it).
+// Other sinks and the standard output only get the prefix.
+func (l *Logger) SetField(key, value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Copy on write, as sinks may keep the map
+	fields := make(map[string]string, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	if value == "" {
+		delete(fields, key)
+	} else {
+		fields[key] = value
+	}
+	l.fields = fields
+}
+
+// SetOutput redirects log messages to w (stdout by default)
+func (l *Logger) SetOutput(w io.Writer) {
+	l.logger.SetOutput(w)
 }
 
 // ShouldLogSuccess determines if a success message should be logged
@@ -60,29 +130,46 @@ func (l *Logger) ResetSuccessCounter() {
 // formatMessage formats a message with timestamp if needed
 func (l *Logger) formatMessage(message string) string {
 	if l.timestamped {
-		now := time.Now().Format("2006-01-02 15:04:05")
+		now := l.clock.Now().Format("2006-01-02 15:04:05")
 		return fmt.Sprintf("%s - %s - %s", LogTag, now, message)
 	}
 	return fmt.Sprintf("%s - %s", LogTag, message)
 }
 
+// output writes a message to the sink if any, to the standard logger otherwise
+func (l *Logger) output(level LogLevel, marker, message string) {
+	l.mu.RLock()
+	prefix, fields := l.prefix, l.fields
+	l.mu.RUnlock()
+
+	if fieldSink, ok := l.sink.(FieldSink); ok {
+		fieldSink.LogFields(level, prefix+message, fields)
+		return
+	}
+	if l.sink != nil {
+		l.sink.Log(level, prefix+message)
+		return
+	}
+	l.logger.Println(l.formatMessage(prefix + marker + message))
+}
+
 // Log records a message without checking verbosity level
 func (l *Logger) Log(format string, args ...interface{}) {
 	message := fmt.Sprintf(format, args...)
-	l.logger.Println(l.formatMessage(message))
+	l.output(LogLevelInfo, "", message)
 }
 
 // Error records an error message (level LogLevelError)
 func (l *Logger) Error(format string, args ...interface{}) {
 	message := fmt.Sprintf(format, args...)
-	l.logger.Println(l.formatMessage("ERROR: " + message))
+	l.output(LogLevelError, "ERROR: ", message)
 }
 
 // Info records an information message if level is >= LogLevelInfo
 func (l *Logger) Info(format string, args ...interface{}) {
 	if l.level >= LogLevelInfo {
 		message := fmt.Sprintf(format, args...)
-		l.logger.Println(l.formatMessage(message))
+		l.output(LogLevelInfo, "", message)
 	}
 }
 
@@ -90,7 +177,7 @@ func (l *Logger) Info(format string, args ...interface{}) {
 func (l *Logger) Debug(format string, args ...interface{}) {
 	if l.level >= LogLevelDebug {
 		message := fmt.Sprintf(format, args...)
-		l.logger.Println(l.formatMessage("DEBUG: " + message))
+		l.output(LogLevelDebug, "DEBUG: ", message)
 	}
 }
 
@@ -98,7 +185,7 @@ func (l *Logger) Debug(format string, args ...interface{}) {
 func (l *Logger) LogSuccess(format string, args ...interface{}) {
 	if l.level >= LogLevelInfo && l.ShouldLogSuccess() {
 		message := fmt.Sprintf(format, args...)
-		l.logger.Println(l.formatMessage("✓ " + message))
+		l.output(LogLevelInfo, "✓ ", message)
 		l.ResetSuccessCounter()
 	}
 }
@@ -107,6 +194,6 @@ func (l *Logger) LogSuccess(format string, args ...interface{}) {
 func (l *Logger) LogT(format string, args ...interface{}) {
 	message := fmt.Sprintf(format, args...)
 	if l.level >= LogLevelInfo {
-		l.logger.Println(l.formatMessage(message))
+		l.output(LogLevelInfo, "", message)
 	}
 }
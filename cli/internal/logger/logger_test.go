@@ -0,0 +1,59 @@
+package logger
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLogSuccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  LogLevel
+		period int
+		calls  int
+		want   int // Messages logged
+	}{
+		{name: "every success", level: LogLevelInfo, period: 0, calls: 4, want: 4},
+		{name: "period of one", level: LogLevelInfo, period: 1, calls: 4, want: 4},
+		{name: "before the period", level: LogLevelInfo, period: 3, calls: 2, want: 0},
+		{name: "once per period", level: LogLevelInfo, period: 3, calls: 7, want: 2},
+		{name: "errors only", level: LogLevelError, period: 3, calls: 7, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			l := New(false, tt.level, tt.period)
+			l.SetOutput(&out)
+
+			for range tt.calls {
+				l.LogSuccess("IP unchanged")
+			}
+
+			if got := strings.Count(out.String(), "✓ IP unchanged"); got != tt.want {
+				t.Errorf("%d messages logged, want %d:\n%s", got, tt.want, out.String())
+			}
+		})
+	}
+}
+
+func TestLogSuccessResetsOnlyWhenLogged(t *testing.T) {
+	var out bytes.Buffer
+	l := New(false, LogLevelInfo, 3)
+	l.SetOutput(&out)
+
+	var logged []int
+	for i := 1; i <= 9; i++ {
+		before := out.Len()
+		l.LogSuccess("IP unchanged")
+		if out.Len() != before {
+			logged = append(logged, i)
+		}
+	}
+
+	if want := []int{3, 6, 9}; !slices.Equal(logged, want) {
+		t.Errorf("logged at calls %v, want %v", logged, want)
+	}
+}
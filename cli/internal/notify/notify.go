@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+)
+
+// Event identifies what happened
+type Event string
+
+const (
+	// EventAuthRevoked is fired when the server stops accepting the API token
+	EventAuthRevoked Event = "auth_revoked"
+	// EventAuthRestored is fired when a previously refused API token is accepted again
+	EventAuthRestored Event = "auth_restored"
+	// EventRateLimited is fired when the server asks the client to slow down
+	EventRateLimited Event = "rate_limited"
+	// EventUnhealthy is fired when flares start failing
+	EventUnhealthy Event = "unhealthy"
+	// EventRecovered is fired when flares succeed again after a failure
+	EventRecovered Event = "recovered"
+	// EventFallback is fired when records start being updated directly through Cloudflare, the server being unavailable
+	EventFallback Event = "fallback"
+	// EventFallbackEnded is fired when flares go through the server again
+	EventFallbackEnded Event = "fallback_ended"
+	// EventPropagationFailed is fired when resolvers still do not serve a flared address after the verification timeout
+	EventPropagationFailed Event = "propagation_failed"
+)
+
+// Notification is the JSON document posted to the webhook
+type Notification struct {
+	Event   Event     `json:"event"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Notifier sends notifications to a webhook. A Notifier without URL only logs.
+type Notifier struct {
+	url    string
+	logger *logger.Logger
+	client *http.Client
+}
+
+// New creates a new Notifier posting to url (may be empty to disable webhook delivery)
+func New(url string, logger *logger.Logger) *Notifier {
+	return &Notifier{
+		url:    url,
+		logger: logger,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Notify delivers a notification. Delivery failures are logged, never returned.
+func (n *Notifier) Notify(event Event, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	n.logger.Debug("Notification [%s]: %s", event, message)
+
+	if n.url == "" {
+		return
+	}
+
+	if err := n.send(Notification{Event: event, Message: message, Time: time.Now()}); err != nil {
+		n.logger.Error("Failed to deliver notification [%s]: %v", event, err)
+	}
+}
+
+// send posts the notification to the webhook
+func (n *Notifier) send(notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered HTTP %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+// Package queue keeps the flares the server could not be reached for, so that they are retried until
+// delivered, including across restarts when backed by a file. Only the latest address of a domain
+// matters: each domain has a single slot, a new address supersedes the pending one.
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rename replaces the file of the queue, swapped by tests to simulate a failure
+var rename = os.Rename
+
+// Entry is a pending flare
+type Entry struct {
+	Domain      string    `json:"domain"`
+	IP          string    `json:"ip"`
+	Queued      time.Time `json:"queued"`               // When the address was first queued
+	Superseded  int       `json:"superseded,omitempty"` // Number of addresses of the domain this one replaced
+	Attempts    int       `json:"attempts"`             // Failed deliveries, including the one that queued the address
+	LastAttempt time.Time `json:"lastAttempt"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// Queue holds the pending flares, one per domain. It is safe for concurrent use, so that agents
+// of several uplinks share a file.
+type Queue struct {
+	path string // File the queue is persisted to, in memory only if empty
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+}
+
+// document is the content of the file
+type document struct {
+	Entries []*Entry `json:"entries"`
+}
+
+// Open loads the queue persisted to path, creating an empty one if the file does not exist.
+// The queue is kept in memory only if path is empty.
+func Open(path string) (*Queue, error) {
+	q := &Queue{path: path, entries: map[string]*Entry{}}
+	if path == "" {
+		return q, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read queue: %w", err)
+	}
+
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid queue file %s: %w", path, err)
+	}
+	for _, e := range doc.Entries {
+		q.entries[e.Domain] = e
+	}
+	return q, nil
+}
+
+// Path returns the file the queue is persisted to, empty if kept in memory
+func (q *Queue) Path() string {
+	return q.path
+}
+
+// Put records a failed delivery of ip for domain, retried after next. A different address supersedes
+// the pending one, keeping the time the domain started waiting.
+func (q *Queue) Put(domain, ip string, now, next time.Time, cause error) (*Entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.entries[domain]
+	if !ok {
+		e = &Entry{Domain: domain, IP: ip, Queued: now}
+		q.entries[domain] = e
+	} else if e.IP != ip {
+		e.IP = ip
+		e.Superseded++
+		e.Attempts = 0
+	}
+	e.Attempts++
+	e.LastAttempt = now
+	e.NextAttempt = next
+	e.LastError = ""
+	if cause != nil {
+		e.LastError = cause.Error()
+	}
+
+	entry := *e
+	return &entry, q.save()
+}
+
+// Get returns the pending flare of domain, nil if none
+func (q *Queue) Get(domain string) *Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.entries[domain]
+	if !ok {
+		return nil
+	}
+	entry := *e
+	return &entry
+}
+
+// Remove drops the pending flare of domain, once delivered or no longer needed
+func (q *Queue) Remove(domain string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.entries[domain]; !ok {
+		return nil
+	}
+	delete(q.entries, domain)
+	return q.save()
+}
+
+// Move hands the pending flare of from over to the slot of to, where it supersedes the pending flare,
+// if any; e.g. for a flare queued before the domain bound to the API token was known
+func (q *Queue) Move(from, to string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.entries[from]
+	if !ok || from == to {
+		return nil
+	}
+	delete(q.entries, from)
+	if pending, ok := q.entries[to]; ok {
+		if pending.Queued.Before(e.Queued) {
+			e.Queued = pending.Queued
+		}
+		if pending.IP != e.IP {
+			e.Superseded += pending.Superseded + 1
+		}
+	}
+	e.Domain = to
+	q.entries[to] = e
+	return q.save()
+}
+
+// Entries returns the pending flares, oldest first
+func (q *Queue) Entries() []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := make([]Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Queued.Equal(entries[j].Queued) {
+			return entries[i].Queued.Before(entries[j].Queued)
+		}
+		return entries[i].Domain < entries[j].Domain
+	})
+	return entries
+}
+
+// save writes the queue to its file, atomically so that a crash never leaves it truncated.
+// Called with q.mu held.
+func (q *Queue) save() error {
+	if q.path == "" {
+		return nil
+	}
+
+	doc := document{Entries: make([]*Entry, 0, len(q.entries))}
+	for _, e := range q.entries {
+		doc.Entries = append(doc.Entries, e)
+	}
+	sort.Slice(doc.Entries, func(i, j int) bool { return doc.Entries[i].Domain < doc.Entries[j].Domain })
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write queue: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write queue: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write queue: %w", err)
+	}
+	if err := rename(tmp.Name(), q.path); err != nil {
+		return fmt.Errorf("unable to write queue: %w", err)
+	}
+	return nil
+}
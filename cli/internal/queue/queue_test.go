@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+
+	q, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if entries := q.Entries(); len(entries) != 0 {
+		t.Fatalf("entries of a new queue = %+v, want none", entries)
+	}
+
+	steps := []struct {
+		domain, ip string
+		at         time.Duration
+	}{
+		{"home.example.com", "93.184.216.34", 0},
+		{"vpn.example.com", "93.184.216.40", time.Minute},
+		{"home.example.com", "93.184.216.34", 2 * time.Minute},
+		{"home.example.com", "93.184.216.35", 3 * time.Minute}, // Supersedes the pending address
+		{"office.example.com", "93.184.216.50", 4 * time.Minute},
+	}
+	for _, step := range steps {
+		now := start.Add(step.at)
+		if _, err := q.Put(step.domain, step.ip, now, now.Add(15*time.Second), errors.New("HTTP 503")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if err := q.Remove("office.example.com"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	want := []Entry{
+		{
+			Domain: "home.example.com", IP: "93.184.216.35", Queued: start, Superseded: 1, Attempts: 1,
+			LastAttempt: start.Add(3 * time.Minute), NextAttempt: start.Add(3*time.Minute + 15*time.Second), LastError: "HTTP 503",
+		},
+		{
+			Domain: "vpn.example.com", IP: "93.184.216.40", Queued: start.Add(time.Minute), Attempts: 1,
+			LastAttempt: start.Add(time.Minute), NextAttempt: start.Add(time.Minute + 15*time.Second), LastError: "HTTP 503",
+		},
+	}
+	checkEntries(t, q, want)
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	checkEntries(t, reopened, want)
+
+	// Only the queue file is left in its directory
+	if files, _ := os.ReadDir(filepath.Dir(path)); len(files) != 1 {
+		t.Errorf("%d files next to the queue, want only the queue", len(files))
+	}
+}
+
+func TestMove(t *testing.T) {
+	q, _ := Open("")
+	q.Put("default", "93.184.216.35", start.Add(time.Minute), start.Add(2*time.Minute), nil)
+	if err := q.Move("default", "home.example.com"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if q.Get("default") != nil {
+		t.Error("flare still pending in the previous slot")
+	}
+	if e := q.Get("home.example.com"); e == nil || e.Domain != "home.example.com" || e.IP != "93.184.216.35" {
+		t.Fatalf("moved flare = %+v, want 93.184.216.35 for home.example.com", e)
+	}
+
+	// Moving onto a pending flare supersedes it, keeping the time the domain started waiting
+	q.Put("default", "93.184.216.36", start.Add(2*time.Minute), start.Add(3*time.Minute), nil)
+	q.Put("vpn.example.com", "93.184.216.35", start, start.Add(time.Minute), nil)
+	if err := q.Move("default", "vpn.example.com"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if e := q.Get("vpn.example.com"); e == nil || e.IP != "93.184.216.36" || !e.Queued.Equal(start) || e.Superseded != 1 {
+		t.Errorf("moved flare = %+v, want 93.184.216.36 queued at %s superseding one address", e, start)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	valid := `{"entries": [{"domain": "home.example.com", "ip": "93.184.216.34", "queued": "2026-03-02T12:00:00Z", "attempts": 1}]}`
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"corrupt", "not json"},
+		{"truncated", valid[:len(valid)/2]},
+		{"empty", ""},
+		{"wrong type", `{"entries": {"domain": "home.example.com"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "queue.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Open(path); err == nil {
+				t.Errorf("Open succeeded on a %s file", tt.name)
+			}
+		})
+	}
+
+	// A missing file is an empty queue
+	q, err := Open(filepath.Join(t.TempDir(), "queue.json"))
+	if err != nil || len(q.Entries()) != 0 {
+		t.Errorf("Open of a missing file = %v, %d entries, want an empty queue", err, len(q.Entries()))
+	}
+}
+
+func TestSaveFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, _ := Open(path)
+	if _, err := q.Put("home.example.com", "93.184.216.34", start, start.Add(time.Minute), nil); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rename = func(string, string) error { return errors.New("disk full") }
+	defer func() { rename = os.Rename }()
+
+	if _, err := q.Put("vpn.example.com", "93.184.216.40", start, start.Add(time.Minute), nil); err == nil {
+		t.Fatal("Put succeeded while the file could not be replaced")
+	}
+	if err := q.Remove("home.example.com"); err == nil {
+		t.Fatal("Remove succeeded while the file could not be replaced")
+	}
+
+	// The previous file is left intact, without the temporary file
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Errorf("queue file = %s, want it unchanged:\n%s", after, before)
+	}
+	if files, _ := os.ReadDir(filepath.Dir(path)); len(files) != 1 {
+		t.Errorf("%d files next to the queue, want the temporary file removed", len(files))
+	}
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	checkEntries(t, reopened, []Entry{{Domain: "home.example.com", IP: "93.184.216.34", Queued: start, Attempts: 1, LastAttempt: start, NextAttempt: start.Add(time.Minute)}})
+}
+
+// checkEntries compares the pending flares of q to want
+func checkEntries(t *testing.T, q *Queue, want []Entry) {
+	t.Helper()
+
+	got := q.Entries()
+	if len(got) != len(want) {
+		t.Fatalf("entries = %+v, want %+v", got, want)
+	}
+	for i, e := range got {
+		w := want[i]
+		if e.Domain != w.Domain || e.IP != w.IP || e.Superseded != w.Superseded || e.Attempts != w.Attempts || e.LastError != w.LastError ||
+			!e.Queued.Equal(w.Queued) || !e.LastAttempt.Equal(w.LastAttempt) || !e.NextAttempt.Equal(w.NextAttempt) {
+			t.Errorf("entry %d = %+v, want %+v", i, e, w)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package relay accepts dyndns2 updates (GET /nic/update) from routers that cannot run the client,
+// and relays them to the PierceFlare server as flares.
+//
+// Routers authenticate with their PierceFlare API token as password (the username is ignored), so
+// the relay holds no secret: each update is checked and flared with the token it carries, and the
+// hostname must be the domain bound to that token.
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/api"
+	"github.com/qalisa/pierceflare/cli/internal/ip"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/signing"
+)
+
+// Paths accepted for updates, as configured on routers
+var Paths = []string{"/nic/update", "/update"}
+
+// tokenCacheDuration is how long the domain bound to a token is remembered, so that routers
+// updating periodically do not cost a token check each time
+const tokenCacheDuration = 5 * time.Minute
+
+// flareCacheDuration is how long an address flared is remembered: until then, the same address is
+// answered nochg without a flare. Past it, the address is flared again, restoring a record changed
+// meanwhile by other means.
+const flareCacheDuration = time.Hour
+
+// Relay is an http.Handler translating dyndns2 updates into flares
+type Relay struct {
+	serverURL  string
+	httpClient *http.Client
+	log        *logger.Logger
+	sign       bool
+	now        func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]tokenInfo  // API token -> bound domain
+	last   map[string]flaredInfo // API token, hostname and family -> last address flared
+}
+
+// tokenInfo is a token check remembered by the relay
+type tokenInfo struct {
+	domain  string
+	checked time.Time
+}
+
+// flaredInfo is a flare remembered by the relay
+type flaredInfo struct {
+	addr   netip.Addr
+	flared time.Time
+}
+
+// New creates a Relay flaring to the server at serverURL through httpClient
+func New(serverURL string, httpClient *http.Client, log *logger.Logger) *Relay {
+	return &Relay{
+		serverURL:  serverURL,
+		httpClient: httpClient,
+		log:        log,
+		now:        time.Now,
+		tokens:     map[string]tokenInfo{},
+		last:       map[string]flaredInfo{},
+	}
+}
+
+// EnableSigning makes the relay sign its requests to the server with the key derived from each token
+func (r *Relay) EnableSigning() {
+	r.sign = true
+}
+
+// ServeHTTP handles an update. Answers follow the dyndns2 protocol: one line per hostname, "good" or
+// "nochg" followed by the address on success, a return code otherwise.
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	_, token, ok := req.BasicAuth()
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="PierceFlare"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+
+	query := req.URL.Query()
+	hostnames := splitList(query.Get("hostname"))
+	if len(hostnames) == 0 {
+		fmt.Fprintln(w, "notfqdn")
+		return
+	}
+
+	addrs, err := requestedAddresses(query)
+	if err != nil {
+		r.log.Error("Update from %s refused: %v", clientAddress(req), err)
+		fmt.Fprintln(w, "dnserr")
+		return
+	}
+
+	client := api.NewClient(token, r.serverURL, r.httpClient, r.log)
+	if r.sign {
+		signer := signing.NewSigner(token)
+		signer.SetClock(r.now)
+		client.EnableSigning(signer)
+	}
+
+	domain, code := r.boundDomain(req.Context(), client, token)
+	if code != "" {
+		r.log.Error("Update from %s refused: %s", clientAddress(req), code)
+		for range hostnames {
+			fmt.Fprintln(w, code)
+		}
+		return
+	}
+
+	for _, hostname := range hostnames {
+		fmt.Fprintln(w, r.update(client, req, token, domain, hostname, addrs))
+	}
+}
+
+// update flares the addresses of a hostname and returns the answer line
+func (r *Relay) update(client *api.Client, req *http.Request, token, domain, hostname string, addrs []netip.Addr) string {
+	name := strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if name != strings.ToLower(strings.TrimSuffix(domain, ".")) {
+		r.log.Error("Update from %s refused: %s is not the domain bound to the token (%s)", clientAddress(req), hostname, domain)
+		return "nohost"
+	}
+
+	// Without address, the server uses the one the relay connects from
+	if len(addrs) == 0 {
+		result, err := client.SendIPUpdate(req.Context(), "", false)
+		if err != nil {
+			return r.failure(req, hostname, err)
+		}
+		r.log.Info("%s updated by %s (address resolved by the server: %s)", hostname, clientAddress(req), result.ResolvedIP)
+		return "good " + result.ResolvedIP
+	}
+
+	changed := false
+	for _, addr := range addrs {
+		key := token + "\n" + name + "\n" + family(addr)
+
+		if r.flaredRecently(key, addr) {
+			continue
+		}
+
+		if _, err := client.SendIPUpdate(req.Context(), addr.String(), false); err != nil {
+			return r.failure(req, hostname, err)
+		}
+
+		r.mu.Lock()
+		r.last[key] = flaredInfo{addr: addr, flared: r.now()}
+		r.mu.Unlock()
+		changed = true
+		r.log.Info("%s updated to %s by %s", hostname, addr, clientAddress(req))
+	}
+
+	answer := make([]string, len(addrs))
+	for i, addr := range addrs {
+		answer[i] = addr.String()
+	}
+	if !changed {
+		return "nochg " + strings.Join(answer, ",")
+	}
+	return "good " + strings.Join(answer, ",")
+}
+
+// flaredRecently tells whether addr was flared for key less than flareCacheDuration ago, forgetting
+// the flare past it
+func (r *Relay) flaredRecently(key string, addr netip.Addr) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, ok := r.last[key]
+	if ok && r.now().Sub(info.flared) >= flareCacheDuration {
+		delete(r.last, key)
+		return false
+	}
+	return ok && info.addr == addr
+}
+
+// boundDomain returns the domain bound to a token, or the return code refusing the update
+func (r *Relay) boundDomain(ctx context.Context, client *api.Client, token string) (string, string) {
+	r.mu.Lock()
+	info, ok := r.tokens[token]
+	r.mu.Unlock()
+	if ok && r.now().Sub(info.checked) < tokenCacheDuration {
+		return info.domain, ""
+	}
+
+	domain, err := client.GetBoundDomain(ctx)
+	if err != nil {
+		if api.IsAuthError(err) {
+			return "", "badauth"
+		}
+		r.log.Error("Unable to check a token: %v", err)
+		return "", "911"
+	}
+
+	r.mu.Lock()
+	r.tokens[token] = tokenInfo{domain: domain, checked: r.now()}
+	r.mu.Unlock()
+	return domain, ""
+}
+
+// failure logs a failed flare and returns the answer line. Only refused tokens are final, routers
+// retry the other failures later.
+func (r *Relay) failure(req *http.Request, hostname string, err error) string {
+	r.log.Error("Update of %s by %s failed: %v", hostname, clientAddress(req), err)
+	switch {
+	case api.IsAuthError(err):
+		return "badauth"
+	case errors.Is(err, api.ErrUnresolvable):
+		return "dnserr"
+	default:
+		return "911"
+	}
+}
+
+// requestedAddresses returns the public addresses given by myip (possibly a list) and myipv6
+func requestedAddresses(query map[string][]string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	values := append(splitList(first(query["myip"])), splitList(first(query["myipv6"]))...)
+	for _, value := range values {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", value)
+		}
+		addr = addr.Unmap()
+		if class := ip.Classify(addr); class != ip.ClassPublic {
+			return nil, fmt.Errorf("%s is not a public address (%s)", addr, class)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// splitList splits a comma-separated parameter
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// family returns the key of the family of an address, addresses of each family being flared separately
+func family(addr netip.Addr) string {
+	if addr.Is4() {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// clientAddress identifies the router in logs
+func clientAddress(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,176 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+const (
+	testToken   = "test-token"
+	testDomain  = "home.example.com"
+	testAddress = "93.184.216.34"
+)
+
+func TestServeHTTP(t *testing.T) {
+	const otherToken = "other-token"
+
+	srv := testserver.New(map[string]string{testToken: testDomain, otherToken: "other.example.com"})
+	defer srv.Close()
+
+	log := logger.New(false, logger.LogLevelError, 1)
+	log.SetOutput(io.Discard)
+	r := New(srv.URL, srv.Client(), log)
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	relay := httptest.NewServer(r)
+	defer relay.Close()
+
+	tests := []struct {
+		name       string
+		token      string // Password of the update, none if empty
+		query      string
+		setup      func() // Prepares the step, e.g. scripts the server
+		wantStatus int
+		wantAnswer string
+		wantFlares []string // Addresses flared during the step
+	}{
+		{name: "no credentials", query: "hostname=" + testDomain + "&myip=" + testAddress, wantStatus: http.StatusUnauthorized, wantAnswer: "badauth"},
+		{name: "unknown token", token: "unknown", query: "hostname=" + testDomain + "&myip=" + testAddress, wantAnswer: "badauth"},
+		{name: "no hostname", token: testToken, query: "myip=" + testAddress, wantAnswer: "notfqdn"},
+		{name: "other hostname", token: testToken, query: "hostname=other.example.com&myip=" + testAddress, wantAnswer: "nohost"},
+		{name: "private address", token: testToken, query: "hostname=" + testDomain + "&myip=192.168.1.10", wantAnswer: "dnserr"},
+		{name: "invalid address", token: testToken, query: "hostname=" + testDomain + "&myip=invalid", wantAnswer: "dnserr"},
+		{
+			name: "first update", token: testToken, query: "hostname=" + testDomain + "&myip=" + testAddress,
+			wantAnswer: "good " + testAddress, wantFlares: []string{testAddress},
+		},
+		{name: "unchanged", token: testToken, query: "hostname=" + testDomain + "&myip=" + testAddress, wantAnswer: "nochg " + testAddress},
+		{
+			name: "unchanged, case and trailing dot", token: testToken, query: "hostname=HOME.example.com.&myip=" + testAddress,
+			wantAnswer: "nochg " + testAddress,
+		},
+		{
+			name: "unchanged, flare forgotten", token: testToken, query: "hostname=" + testDomain + "&myip=" + testAddress,
+			setup:      func() { now = now.Add(flareCacheDuration) },
+			wantAnswer: "good " + testAddress, wantFlares: []string{testAddress},
+		},
+		{
+			name: "both families", token: testToken, query: "hostname=" + testDomain + "&myip=" + testAddress + "&myipv6=2606:2800:220:1::1",
+			wantAnswer: "good " + testAddress + ",2606:2800:220:1::1", wantFlares: []string{"2606:2800:220:1::1"},
+		},
+		{
+			name: "hostnames answered one per line", token: testToken, query: "hostname=" + testDomain + ",other.example.com&myip=" + testAddress,
+			wantAnswer: "nochg " + testAddress + "\nnohost",
+		},
+		{
+			name: "server unavailable", token: testToken, query: "hostname=" + testDomain + "&myip=93.184.216.35",
+			setup: func() {
+				srv.Script(testserver.Behavior{Route: testserver.RouteFlare, Status: http.StatusServiceUnavailable})
+			},
+			wantAnswer: "911", wantFlares: []string{"93.184.216.35"},
+		},
+		{
+			name: "token check unavailable", token: otherToken, query: "hostname=other.example.com&myip=" + testAddress,
+			setup: func() {
+				srv.Script(testserver.Behavior{Route: testserver.RouteInfos, Status: http.StatusServiceUnavailable})
+			},
+			wantAnswer: "911",
+		},
+		{
+			name: "address resolved by the server", token: otherToken, query: "hostname=other.example.com",
+			setup:      func() { srv.Script(testserver.Behavior{Route: testserver.RouteFlare, ResolvedIP: "93.184.216.36"}) },
+			wantAnswer: "good 93.184.216.36", wantFlares: []string{""},
+		},
+		{
+			name: "token revoked while remembered", token: testToken, query: "hostname=" + testDomain + "&myip=93.184.216.35",
+			setup:      func() { srv.RevokeToken(testToken) },
+			wantAnswer: "badauth", wantFlares: []string{"93.184.216.35"},
+		},
+	}
+
+	for _, tt := range tests {
+		if tt.setup != nil {
+			tt.setup()
+		}
+		before := len(srv.Flares())
+
+		req, err := http.NewRequest(http.MethodGet, relay.URL+"/nic/update?"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			req.SetBasicAuth("router", tt.token)
+		}
+		resp, err := relay.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		wantStatus := tt.wantStatus
+		if wantStatus == 0 {
+			wantStatus = http.StatusOK
+		}
+		if resp.StatusCode != wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, wantStatus)
+		}
+		if got := strings.TrimSpace(string(body)); got != tt.wantAnswer {
+			t.Errorf("%s: answer = %q, want %q", tt.name, got, tt.wantAnswer)
+		}
+
+		var flared []string
+		for _, flare := range srv.Flares()[before:] {
+			address := ""
+			if flare.Flare.IP != nil {
+				address = *flare.Flare.IP
+			}
+			flared = append(flared, address)
+		}
+		if !slices.Equal(flared, tt.wantFlares) {
+			t.Errorf("%s: flared %q, want %q", tt.name, flared, tt.wantFlares)
+		}
+	}
+}
+
+func TestRequestedAddresses(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{query: "", want: ""},
+		{query: "myip=" + testAddress, want: testAddress},
+		{query: "myip=::ffff:" + testAddress, want: testAddress},
+		{query: "myip=" + testAddress + ",2606:2800:220:1::1", want: testAddress + " 2606:2800:220:1::1"},
+		{query: "myip=" + testAddress + "&myipv6=2606:2800:220:1::1", want: testAddress + " 2606:2800:220:1::1"},
+		{query: "myip=10.0.0.1", wantErr: true},
+		{query: "myipv6=fe80::1", wantErr: true},
+		{query: "myip=example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		addrs, err := requestedAddresses(query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("requestedAddresses(%q) error = %v, want error: %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		got := make([]string, len(addrs))
+		for i, addr := range addrs {
+			got[i] = addr.String()
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("requestedAddresses(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
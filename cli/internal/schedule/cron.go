@@ -0,0 +1,206 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronHorizon bounds the search of the next time matching an expression: expressions matching nothing
+// within it (e.g. February 30) are rejected
+const cronHorizon = 5 // years
+
+// descriptors are the shorthands of common expressions
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField describes a field of an expression
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // Names of the values from min, if any
+}
+
+var (
+	minuteField  = cronField{name: "minute", min: 0, max: 59}
+	hourField    = cronField{name: "hour", min: 0, max: 23}
+	dayField     = cronField{name: "day of month", min: 1, max: 31}
+	monthField   = cronField{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	weekdayField = cronField{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// Cron is a parsed cron expression: minute, hour, day of month, month and day of week, in local time
+type Cron struct {
+	expr                              string
+	minutes, hours, days, months, dow uint64 // Bit sets of the matching values
+	anyDay, anyWeekday                bool   // Day fields starting with *, see matchDay
+}
+
+// ParseCron parses a cron expression of 5 fields (minute hour day-of-month month day-of-week) or a
+// descriptor (@hourly, @daily, @weekly, @monthly, @yearly). Fields accept *, values, ranges (1-5),
+// steps (*/10, 0-30/5) and lists (1,15); months and days of week accept their English abbreviations.
+func ParseCron(expr string) (*Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if d, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: 5 fields expected (minute hour day month weekday)", expr)
+	}
+
+	c := &Cron{
+		expr:       expr,
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&c.minutes, minuteField}, {&c.hours, hourField}, {&c.days, dayField},
+		{&c.months, monthField}, {&c.dow, weekdayField},
+	} {
+		if *f.bits, err = f.field.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+	}
+	// Sunday is both 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	now := time.Now()
+	if c.Next(now).IsZero() {
+		return nil, fmt.Errorf("invalid cron expression %q: no matching time in the next %d years", expr, cronHorizon)
+	}
+	return c, nil
+}
+
+// parse returns the bit set of the values matched by spec
+func (f cronField) parse(spec string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepSpec); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepSpec, f.name)
+			}
+		}
+
+		low, high := f.min, f.max
+		if rangeSpec != "*" {
+			lowSpec, highSpec, isRange := strings.Cut(rangeSpec, "-")
+			var err error
+			if low, err = f.value(lowSpec); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = f.value(highSpec); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = f.max // 5/15: from 5, every 15
+			}
+			if high < low {
+				return 0, fmt.Errorf("invalid range %q in %s", rangeSpec, f.name)
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// value parses a value of the field, given as number or name
+func (f cronField) value(spec string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(spec, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(spec)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q (%d-%d expected)", f.name, spec, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time matching the expression strictly after t, in the location of t.
+// It is zero if none is found within the next years. Like cron, times skipped when clocks move forward
+// (daylight saving time) match when the jump ends; the hour repeated when they move back matches again
+// if the hour is not restricted.
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronHorizon, 0, 0)
+
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case c.months&(1<<int(t.Month())) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchDay(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hours&(1<<t.Hour()) == 0:
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minutes&(1<<t.Minute()) == 0:
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		if c.skipped(t, next) {
+			return next
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// skipped tells whether the expression matches a time of day the clock jumped over between t and next
+func (c *Cron) skipped(t, next time.Time) bool {
+	wall := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	}
+	if c.months&(1<<int(next.Month())) == 0 || !c.matchDay(next) {
+		return false
+	}
+	for m := wall(t).Add(next.Sub(t)); m.Before(wall(next)); m = m.Add(time.Minute) {
+		if c.hours&(1<<m.Hour()) != 0 && c.minutes&(1<<m.Minute()) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDay tells whether the day of t matches. Like cron, when both the day of month and the day of
+// week are restricted, matching either is enough.
+func (c *Cron) matchDay(t time.Time) bool {
+	day := c.days&(1<<t.Day()) != 0
+	weekday := c.dow&(1<<int(t.Weekday())) != 0
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// String returns the expression as given
+func (c *Cron) String() string {
+	return c.expr
+}
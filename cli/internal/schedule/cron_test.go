@@ -0,0 +1,225 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Daylight saving time transitions, whatever the zoneinfo of the host
+)
+
+// date returns a time of 2026 in loc, 2026-03-02 being a Monday
+func date(loc *time.Location, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2026, month, day, hour, minute, 0, 0, loc)
+}
+
+// paris is a zone observing daylight saving time: clocks skip 02:00-03:00 on 2026-03-29 and repeat
+// 02:00-03:00 on 2026-10-25
+func paris(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseCron(t *testing.T) {
+	utc := time.UTC
+	tests := []struct {
+		expr string
+		from time.Time
+		want []time.Time // Next times, in order
+	}{
+		{
+			expr: "*/15 * * * *",
+			from: date(utc, time.March, 2, 10, 7),
+			want: []time.Time{date(utc, time.March, 2, 10, 15), date(utc, time.March, 2, 10, 30), date(utc, time.March, 2, 10, 45), date(utc, time.March, 2, 11, 0)},
+		},
+		{
+			expr: "0-30/10 9-10 * * *",
+			from: date(utc, time.March, 2, 9, 25),
+			want: []time.Time{date(utc, time.March, 2, 9, 30), date(utc, time.March, 2, 10, 0), date(utc, time.March, 2, 10, 10), date(utc, time.March, 2, 10, 20), date(utc, time.March, 2, 10, 30), date(utc, time.March, 3, 9, 0)},
+		},
+		{
+			expr: "5/20 8 * * *",
+			from: date(utc, time.March, 2, 0, 0),
+			want: []time.Time{date(utc, time.March, 2, 8, 5), date(utc, time.March, 2, 8, 25), date(utc, time.March, 2, 8, 45), date(utc, time.March, 3, 8, 5)},
+		},
+		{
+			expr: "0 6,18 1,15 * *",
+			from: date(utc, time.March, 2, 0, 0),
+			want: []time.Time{date(utc, time.March, 15, 6, 0), date(utc, time.March, 15, 18, 0), date(utc, time.April, 1, 6, 0)},
+		},
+		{
+			expr: "30 12 * JAN,apr Mon-Wed",
+			from: date(utc, time.March, 2, 0, 0),
+			want: []time.Time{date(utc, time.April, 1, 12, 30), date(utc, time.April, 6, 12, 30), date(utc, time.April, 7, 12, 30), date(utc, time.April, 8, 12, 30), date(utc, time.April, 13, 12, 30)},
+		},
+		{
+			expr: "0 0 * * 7", // Sunday
+			from: date(utc, time.March, 2, 0, 0),
+			want: []time.Time{date(utc, time.March, 8, 0, 0), date(utc, time.March, 15, 0, 0)},
+		},
+		{
+			expr: "0 0 * * 5-7", // Friday to Sunday
+			from: date(utc, time.March, 2, 0, 0),
+			want: []time.Time{date(utc, time.March, 6, 0, 0), date(utc, time.March, 7, 0, 0), date(utc, time.March, 8, 0, 0), date(utc, time.March, 13, 0, 0)},
+		},
+		{
+			expr: "0 0 13 * fri", // The 13th or a Friday
+			from: date(utc, time.March, 28, 0, 0),
+			want: []time.Time{date(utc, time.April, 3, 0, 0), date(utc, time.April, 10, 0, 0), date(utc, time.April, 13, 0, 0), date(utc, time.April, 17, 0, 0)},
+		},
+		{
+			expr: "0 0 */10 * mon", // Starting with *: the 1st, 11th, 21st or 31st and a Monday
+			from: date(utc, time.January, 1, 0, 0),
+			want: []time.Time{date(utc, time.May, 11, 0, 0), date(utc, time.June, 1, 0, 0)},
+		},
+		{
+			expr: "0 0 29 2 *",
+			from: date(utc, time.March, 2, 0, 0),
+			want: []time.Time{time.Date(2028, time.February, 29, 0, 0, 0, 0, utc)},
+		},
+		{
+			expr: "@hourly",
+			from: date(utc, time.March, 2, 23, 30),
+			want: []time.Time{date(utc, time.March, 3, 0, 0), date(utc, time.March, 3, 1, 0)},
+		},
+		{
+			expr: "@weekly",
+			from: date(utc, time.March, 2, 0, 0),
+			want: []time.Time{date(utc, time.March, 8, 0, 0), date(utc, time.March, 15, 0, 0)},
+		},
+		{
+			expr: "@Monthly",
+			from: date(utc, time.March, 1, 0, 0),
+			want: []time.Time{date(utc, time.April, 1, 0, 0), date(utc, time.May, 1, 0, 0)},
+		},
+		{
+			expr: "59 23 31 12 *", // Midnight of the new year
+			from: date(utc, time.December, 31, 23, 59).Add(-time.Second),
+			want: []time.Time{date(utc, time.December, 31, 23, 59), time.Date(2027, time.December, 31, 23, 59, 0, 0, utc)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron: %v", err)
+			}
+			at := tt.from
+			for i, want := range tt.want {
+				if at = c.Next(at); !at.Equal(want) {
+					t.Fatalf("next time %d = %s, want %s", i, at, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * foo *",
+		"30-10 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"1,,2 * * * *",
+		"@reboot",
+		"0 0 30 2 *", // Never
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) accepted", expr)
+		}
+	}
+}
+
+func TestCronDaylightSaving(t *testing.T) {
+	loc := paris(t)
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{
+			// 02:30 does not exist on March 29th: the check runs when clocks jump to 03:00
+			name: "skipped time",
+			expr: "30 2 * * *",
+			from: date(loc, time.March, 28, 12, 0),
+			want: []time.Time{date(loc, time.March, 29, 3, 0), date(loc, time.March, 30, 2, 30)},
+		},
+		{
+			name: "skipped hour",
+			expr: "*/30 * * * *",
+			from: date(loc, time.March, 29, 1, 0),
+			want: []time.Time{date(loc, time.March, 29, 1, 30), date(loc, time.March, 29, 3, 0), date(loc, time.March, 29, 3, 30)},
+		},
+		{
+			name: "time after the skipped hour",
+			expr: "0 3 * * *",
+			from: date(loc, time.March, 28, 12, 0),
+			want: []time.Time{date(loc, time.March, 29, 3, 0), date(loc, time.March, 30, 3, 0)},
+		},
+		{
+			// 02:00-03:00 is repeated on October 25th: every 30 minutes of the hour, twice
+			name: "repeated hour",
+			expr: "*/30 * * * *",
+			from: date(loc, time.October, 25, 1, 45),
+			want: []time.Time{
+				time.Date(2026, time.October, 25, 0, 0, 0, 0, time.UTC), // 02:00 CEST
+				time.Date(2026, time.October, 25, 0, 30, 0, 0, time.UTC),
+				time.Date(2026, time.October, 25, 1, 0, 0, 0, time.UTC), // 02:00 CET
+				time.Date(2026, time.October, 25, 1, 30, 0, 0, time.UTC),
+				time.Date(2026, time.October, 25, 2, 0, 0, 0, time.UTC), // 03:00 CET
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron: %v", err)
+			}
+			at := tt.from
+			for i, want := range tt.want {
+				if at = c.Next(at); !at.Equal(want) {
+					t.Fatalf("next time %d = %s, want %s", i, at, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCronDaylightSavingOnce(t *testing.T) {
+	loc := paris(t)
+	c, err := ParseCron("30 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A daily check runs once on the day clocks move back, and once the days around it
+	at := date(loc, time.October, 23, 12, 0)
+	days := map[int]int{}
+	for range 4 {
+		at = c.Next(at)
+		if at.Hour() != 2 || at.Minute() != 30 {
+			t.Fatalf("next time = %s, want 02:30", at)
+		}
+		days[at.Day()]++
+	}
+	for day := 24; day <= 27; day++ {
+		if days[day] != 1 {
+			t.Errorf("%d checks on October %d, want one", days[day], day)
+		}
+	}
+}
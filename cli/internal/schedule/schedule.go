@@ -0,0 +1,154 @@
+// Package schedule decides when the continuous mode checks the address: at a fixed interval or
+// following a cron expression, with random delays spreading the checks of hosts started together,
+// outside of quiet windows.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// Options configures a Scheduler
+type Options struct {
+	Interval   time.Duration // Between two checks, unless Cron is set
+	Cron       string        // Cron expression (see ParseCron) giving the times of the checks, in local time
+	Jitter     time.Duration // Each check is delayed by a random duration up to Jitter
+	StartDelay time.Duration // The first check is delayed by a random duration up to StartDelay
+	QuietHours string        // Daily windows without checks, e.g. "22:00-06:00,12:00-13:00" (see ParseWindows)
+}
+
+// Scheduler gives the times of the checks
+type Scheduler struct {
+	opts   Options
+	cron   *Cron
+	quiet  []Window
+	random func(n int64) int64
+}
+
+// OptionError tells which option of Options is invalid
+type OptionError struct {
+	Option string // Name of the field of Options
+	Err    error
+}
+
+func (e *OptionError) Error() string {
+	return e.Option + ": " + e.Err.Error()
+}
+
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+// New creates a Scheduler. Invalid options are reported as an *OptionError.
+func New(opts Options) (*Scheduler, error) {
+	s := &Scheduler{opts: opts, random: rand.Int64N}
+
+	var err error
+	if strings.TrimSpace(opts.Cron) != "" {
+		if s.cron, err = ParseCron(opts.Cron); err != nil {
+			return nil, &OptionError{Option: "Cron", Err: err}
+		}
+	} else if opts.Interval <= 0 {
+		return nil, &OptionError{Option: "Interval", Err: errors.New("interval between checks must be positive")}
+	}
+	if opts.Jitter < 0 {
+		return nil, &OptionError{Option: "Jitter", Err: errors.New("jitter cannot be negative")}
+	}
+	if opts.StartDelay < 0 {
+		return nil, &OptionError{Option: "StartDelay", Err: errors.New("start delay cannot be negative")}
+	}
+
+	if s.quiet, err = ParseWindows(opts.QuietHours); err != nil {
+		return nil, &OptionError{Option: "QuietHours", Err: err}
+	}
+	if s.alwaysQuiet() {
+		return nil, &OptionError{Option: "QuietHours", Err: fmt.Errorf("quiet hours %q leave no time for checks", opts.QuietHours)}
+	}
+	return s, nil
+}
+
+// alwaysQuiet tells whether the quiet windows cover the whole day
+func (s *Scheduler) alwaysQuiet() bool {
+	if len(s.quiet) == 0 {
+		return false
+	}
+	day := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for minute := 0; minute < minutesPerDay; minute++ {
+		if !s.Quiet(day.Add(time.Duration(minute) * time.Minute)) {
+			return false
+		}
+	}
+	return true
+}
+
+// First returns the time of the first check for a start at now: now, delayed by up to StartDelay and
+// until the end of the quiet window it falls in
+func (s *Scheduler) First(now time.Time) time.Time {
+	return s.outOfQuiet(now.Add(s.delay(s.opts.StartDelay)))
+}
+
+// Next returns the time of the check following one at now: a full interval later or at the next time
+// matching the cron expression, moved to the end of the quiet window it falls in, then delayed by up
+// to Jitter
+func (s *Scheduler) Next(now time.Time) time.Time {
+	next := now.Add(s.opts.Interval)
+	if s.cron != nil {
+		next = s.cron.Next(now)
+	}
+	return s.outOfQuiet(s.outOfQuiet(next).Add(s.delay(s.opts.Jitter)))
+}
+
+// Quiet tells whether t is in a quiet window
+func (s *Scheduler) Quiet(t time.Time) bool {
+	for _, w := range s.quiet {
+		if in, _ := w.contains(t); in {
+			return true
+		}
+	}
+	return false
+}
+
+// outOfQuiet returns t, or the end of the quiet windows it falls in
+func (s *Scheduler) outOfQuiet(t time.Time) time.Time {
+	for range len(s.quiet) + 1 {
+		moved := false
+		for _, w := range s.quiet {
+			if in, end := w.contains(t); in {
+				t, moved = end, true
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+	return t
+}
+
+// delay returns a random duration up to max
+func (s *Scheduler) delay(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(s.random(int64(max)))
+}
+
+// String describes the schedule, e.g. "every 5m0s, jitter 30s, quiet 22:00-06:00"
+func (s *Scheduler) String() string {
+	desc := []string{fmt.Sprintf("every %s", s.opts.Interval)}
+	if s.cron != nil {
+		desc[0] = fmt.Sprintf("cron %q", s.cron)
+	}
+	if s.opts.StartDelay > 0 {
+		desc = append(desc, fmt.Sprintf("start delay up to %s", s.opts.StartDelay))
+	}
+	if s.opts.Jitter > 0 {
+		desc = append(desc, fmt.Sprintf("jitter up to %s", s.opts.Jitter))
+	}
+	for _, w := range s.quiet {
+		desc = append(desc, fmt.Sprintf("quiet %s", w))
+	}
+	return strings.Join(desc, ", ")
+}
@@ -0,0 +1,172 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newScheduler creates a Scheduler whose random delays are half of their maximum
+func newScheduler(t *testing.T, opts Options) *Scheduler {
+	t.Helper()
+	s, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s.random = func(n int64) int64 { return n / 2 }
+	return s
+}
+
+func TestSchedulerNext(t *testing.T) {
+	utc := time.UTC
+	loc := paris(t)
+	tests := []struct {
+		name string
+		opts Options
+		now  time.Time
+		want time.Time
+	}{
+		{
+			name: "interval",
+			opts: Options{Interval: 5 * time.Minute},
+			now:  date(utc, time.March, 2, 10, 0),
+			want: date(utc, time.March, 2, 10, 5),
+		},
+		{
+			name: "interval across midnight",
+			opts: Options{Interval: 5 * time.Minute},
+			now:  date(utc, time.March, 2, 23, 58),
+			want: date(utc, time.March, 3, 0, 3),
+		},
+		{
+			name: "interval across daylight saving time",
+			opts: Options{Interval: time.Hour},
+			now:  date(loc, time.March, 29, 1, 30),
+			want: date(loc, time.March, 29, 3, 30),
+		},
+		{
+			name: "jitter",
+			opts: Options{Interval: 5 * time.Minute, Jitter: time.Minute},
+			now:  date(utc, time.March, 2, 10, 0),
+			want: date(utc, time.March, 2, 10, 5).Add(30 * time.Second),
+		},
+		{
+			name: "cron across midnight",
+			opts: Options{Cron: "@daily"},
+			now:  date(utc, time.March, 2, 23, 59),
+			want: date(utc, time.March, 3, 0, 0),
+		},
+		{
+			name: "cron across daylight saving time",
+			opts: Options{Cron: "30 2 * * *"},
+			now:  date(loc, time.March, 28, 2, 30),
+			want: date(loc, time.March, 29, 3, 0),
+		},
+		{
+			name: "cron with jitter",
+			opts: Options{Cron: "0 * * * *", Jitter: 10 * time.Minute},
+			now:  date(utc, time.March, 2, 10, 5),
+			want: date(utc, time.March, 2, 11, 5),
+		},
+		{
+			name: "quiet window across midnight",
+			opts: Options{Interval: time.Hour, QuietHours: "22:00-06:00"},
+			now:  date(utc, time.March, 2, 21, 30),
+			want: date(utc, time.March, 3, 6, 0),
+		},
+		{
+			name: "jitter after a quiet window",
+			opts: Options{Interval: time.Hour, Jitter: 10 * time.Minute, QuietHours: "22:00-06:00"},
+			now:  date(utc, time.March, 2, 21, 30),
+			want: date(utc, time.March, 3, 6, 5),
+		},
+		{
+			name: "jitter into a quiet window",
+			opts: Options{Interval: time.Hour, Jitter: 10 * time.Minute, QuietHours: "12:00-13:00"},
+			now:  date(utc, time.March, 2, 10, 58),
+			want: date(utc, time.March, 2, 13, 0),
+		},
+		{
+			name: "adjacent quiet windows",
+			opts: Options{Interval: time.Hour, QuietHours: "23:00-24:00,00:00-02:00"},
+			now:  date(utc, time.March, 2, 22, 30),
+			want: date(utc, time.March, 3, 2, 0),
+		},
+		{
+			name: "cron in a quiet window",
+			opts: Options{Cron: "*/30 * * * *", QuietHours: "23:00-01:00"},
+			now:  date(utc, time.March, 2, 22, 45),
+			want: date(utc, time.March, 3, 1, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newScheduler(t, tt.opts).Next(tt.now); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerFirst(t *testing.T) {
+	utc := time.UTC
+	tests := []struct {
+		name string
+		opts Options
+		now  time.Time
+		want time.Time
+	}{
+		{
+			name: "immediate",
+			opts: Options{Interval: time.Hour},
+			now:  date(utc, time.March, 2, 10, 0),
+			want: date(utc, time.March, 2, 10, 0),
+		},
+		{
+			name: "start delay",
+			opts: Options{Interval: time.Hour, StartDelay: 10 * time.Minute},
+			now:  date(utc, time.March, 2, 10, 0),
+			want: date(utc, time.March, 2, 10, 5),
+		},
+		{
+			name: "start in a quiet window",
+			opts: Options{Interval: time.Hour, StartDelay: 10 * time.Minute, QuietHours: "22:00-06:00"},
+			now:  date(utc, time.March, 2, 23, 0),
+			want: date(utc, time.March, 3, 6, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newScheduler(t, tt.opts).First(tt.now); !got.Equal(tt.want) {
+				t.Errorf("First(%s) = %s, want %s", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		opts       Options
+		wantOption string
+	}{
+		{opts: Options{}, wantOption: "Interval"},
+		{opts: Options{Interval: -time.Minute}, wantOption: "Interval"},
+		{opts: Options{Interval: time.Minute, Jitter: -time.Second}, wantOption: "Jitter"},
+		{opts: Options{Interval: time.Minute, StartDelay: -time.Second}, wantOption: "StartDelay"},
+		{opts: Options{Cron: "* * *"}, wantOption: "Cron"},
+		{opts: Options{Cron: "* * *", Interval: time.Minute}, wantOption: "Cron"},
+		{opts: Options{Interval: time.Minute, QuietHours: "22:00"}, wantOption: "QuietHours"},
+		{opts: Options{Interval: time.Minute, QuietHours: "00:00-24:00"}, wantOption: "QuietHours"},
+		{opts: Options{Interval: time.Minute, QuietHours: "12:00-00:00,00:00-12:00"}, wantOption: "QuietHours"},
+	}
+
+	for _, tt := range tests {
+		_, err := New(tt.opts)
+		var optErr *OptionError
+		if !errors.As(err, &optErr) || optErr.Option != tt.wantOption {
+			t.Errorf("New(%+v) = %v, want an error on %s", tt.opts, err, tt.wantOption)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minutesPerDay is the number of minutes in a day
+const minutesPerDay = 24 * 60
+
+// Window is a daily time range, in local time. A window whose end precedes its start spans midnight.
+type Window struct {
+	Start, End int // Minutes since midnight, End being excluded
+}
+
+// ParseWindows parses a comma-separated list of daily windows such as "22:00-06:00,12:00-13:30"
+func ParseWindows(spec string) ([]Window, error) {
+	var windows []Window
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		startSpec, endSpec, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid window %q: HH:MM-HH:MM expected", part)
+		}
+		start, err := parseClock(startSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", part, err)
+		}
+		end, err := parseClock(endSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", part, err)
+		}
+		if start == end || start == minutesPerDay {
+			return nil, fmt.Errorf("invalid window %q: empty", part)
+		}
+		windows = append(windows, Window{Start: start, End: end % minutesPerDay})
+	}
+	return windows, nil
+}
+
+// parseClock parses a time of day (HH:MM, 24:00 for the end of the day) as minutes since midnight
+func parseClock(spec string) (int, error) {
+	hourSpec, minuteSpec, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	hour, hourErr := strconv.Atoi(hourSpec)
+	minute, minuteErr := strconv.Atoi(minuteSpec)
+	if !ok || hourErr != nil || minuteErr != nil || hour < 0 || minute < 0 || minute > 59 ||
+		hour > 24 || hour == 24 && minute != 0 {
+		return 0, fmt.Errorf("invalid time of day %q", spec)
+	}
+	return hour*60 + minute, nil
+}
+
+// contains tells whether t is in the window, and when the window ends if so
+func (w Window) contains(t time.Time) (bool, time.Time) {
+	minute := t.Hour()*60 + t.Minute()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	endOf := func(days int) time.Time {
+		return time.Date(midnight.Year(), midnight.Month(), midnight.Day()+days, 0, w.End, 0, 0, t.Location())
+	}
+
+	switch {
+	case w.Start < w.End || w.End == 0:
+		end := w.End
+		if end == 0 {
+			end = minutesPerDay
+		}
+		if minute >= w.Start && minute < end {
+			if w.End == 0 {
+				return true, endOf(1)
+			}
+			return true, endOf(0)
+		}
+	case minute >= w.Start: // Spanning midnight, before it
+		return true, endOf(1)
+	case minute < w.End: // Spanning midnight, after it
+		return true, endOf(0)
+	}
+	return false, time.Time{}
+}
+
+// String returns the window as HH:MM-HH:MM
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
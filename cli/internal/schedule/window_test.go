@@ -0,0 +1,83 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindows(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []Window
+	}{
+		{spec: "", want: nil},
+		{spec: "12:00-13:30", want: []Window{{Start: 720, End: 810}}},
+		{spec: " 22:00-06:00 , 12:00-13:00 ", want: []Window{{Start: 1320, End: 360}, {Start: 720, End: 780}}},
+		{spec: "20:00-24:00", want: []Window{{Start: 1200, End: 0}}},
+		{spec: "0:00-6:5", want: []Window{{Start: 0, End: 365}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseWindows(tt.spec)
+		if err != nil || len(got) != len(tt.want) {
+			t.Errorf("ParseWindows(%q) = %v, %v, want %v", tt.spec, got, err, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParseWindows(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		}
+	}
+
+	for _, spec := range []string{"22:00", "22:00-", "25:00-06:00", "22:60-06:00", "24:30-06:00", "24:00-06:00", "12:00-12:00", "noon-13:00"} {
+		if got, err := ParseWindows(spec); err == nil {
+			t.Errorf("ParseWindows(%q) = %v, want an error", spec, got)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	utc := time.UTC
+	loc := paris(t)
+	tests := []struct {
+		window string
+		at     time.Time
+		in     bool
+		end    time.Time
+	}{
+		{window: "12:00-13:30", at: date(utc, time.March, 2, 11, 59), in: false},
+		{window: "12:00-13:30", at: date(utc, time.March, 2, 12, 0), in: true, end: date(utc, time.March, 2, 13, 30)},
+		{window: "12:00-13:30", at: date(utc, time.March, 2, 13, 29), in: true, end: date(utc, time.March, 2, 13, 30)},
+		{window: "12:00-13:30", at: date(utc, time.March, 2, 13, 30), in: false},
+
+		// Spanning midnight
+		{window: "22:00-06:00", at: date(utc, time.March, 2, 21, 59), in: false},
+		{window: "22:00-06:00", at: date(utc, time.March, 2, 22, 0), in: true, end: date(utc, time.March, 3, 6, 0)},
+		{window: "22:00-06:00", at: date(utc, time.March, 2, 23, 59), in: true, end: date(utc, time.March, 3, 6, 0)},
+		{window: "22:00-06:00", at: date(utc, time.March, 3, 0, 0), in: true, end: date(utc, time.March, 3, 6, 0)},
+		{window: "22:00-06:00", at: date(utc, time.March, 3, 5, 59), in: true, end: date(utc, time.March, 3, 6, 0)},
+		{window: "22:00-06:00", at: date(utc, time.March, 3, 6, 0), in: false},
+		{window: "22:00-06:00", at: date(utc, time.December, 31, 23, 0), in: true, end: time.Date(2027, time.January, 1, 6, 0, 0, 0, utc)},
+
+		// Ending at midnight
+		{window: "20:00-24:00", at: date(utc, time.March, 2, 20, 0), in: true, end: date(utc, time.March, 3, 0, 0)},
+		{window: "20:00-24:00", at: date(utc, time.March, 2, 23, 59), in: true, end: date(utc, time.March, 3, 0, 0)},
+		{window: "20:00-24:00", at: date(utc, time.March, 3, 0, 0), in: false},
+		{window: "00:00-06:00", at: date(utc, time.March, 3, 0, 0), in: true, end: date(utc, time.March, 3, 6, 0)},
+
+		// Over a change of daylight saving time, the window ends at the local time
+		{window: "22:00-06:00", at: date(loc, time.March, 28, 23, 0), in: true, end: date(loc, time.March, 29, 6, 0)},
+		{window: "01:00-03:00", at: date(loc, time.October, 25, 2, 30), in: true, end: date(loc, time.October, 25, 3, 0)},
+	}
+
+	for _, tt := range tests {
+		windows, err := ParseWindows(tt.window)
+		if err != nil {
+			t.Fatalf("ParseWindows(%q): %v", tt.window, err)
+		}
+		in, end := windows[0].contains(tt.at)
+		if in != tt.in || !end.Equal(tt.end) {
+			t.Errorf("%s contains %s = %v, %s, want %v, %s", tt.window, tt.at, in, end, tt.in, tt.end)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package systemd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+)
+
+// journalSocket is where journald receives native protocol messages
+const journalSocket = "/run/systemd/journal/socket"
+
+// JournalStream reports whether the standard error is connected to the journal ($JOURNAL_STREAM),
+// in which case messages are better sent with the native protocol
+func JournalStream() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(int(os.Stderr.Fd()), &stat); err != nil {
+		return false
+	}
+
+	dev, ino, _ := strings.Cut(stream, ":")
+	return dev == strconv.FormatUint(uint64(stat.Dev), 10) && ino == strconv.FormatUint(stat.Ino, 10)
+}
+
+// Journal sends log messages to journald with the native protocol, the context of the logger
+// becoming structured fields (e.g. PIERCEFLARE_UPLINK, PIERCEFLARE_DOMAIN)
+type Journal struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+// NewJournal connects to journald, messages being tagged with identifier (SYSLOG_IDENTIFIER)
+func NewJournal(identifier string) (*Journal, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{conn: conn, identifier: identifier}, nil
+}
+
+// Log sends a message without fields
+func (j *Journal) Log(level logger.LogLevel, message string) {
+	j.LogFields(level, message, nil)
+}
+
+// LogFields sends a message with structured fields, prefixed with PIERCEFLARE_.
+// Messages that cannot be sent are written to the standard error instead.
+func (j *Journal) LogFields(level logger.LogLevel, message string, fields map[string]string) {
+	var buf bytes.Buffer
+	writeField(&buf, "MESSAGE", message)
+	writeField(&buf, "PRIORITY", priority(level))
+	writeField(&buf, "SYSLOG_IDENTIFIER", j.identifier)
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeField(&buf, "PIERCEFLARE_"+strings.ToUpper(key), fields[key])
+	}
+
+	if _, err := j.conn.Write(buf.Bytes()); err != nil {
+		os.Stderr.WriteString(message + "\n")
+	}
+}
+
+// priority maps a log level to a syslog priority
+func priority(level logger.LogLevel) string {
+	switch level {
+	case logger.LogLevelError:
+		return "3"
+	case logger.LogLevelDebug:
+		return "7"
+	default:
+		return "6"
+	}
+}
+
+// writeField encodes a field, using the binary form for values spanning several lines
+func writeField(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,68 @@
+package systemd
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+)
+
+func TestWriteField(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       []byte
+	}{
+		{key: "MESSAGE", value: "IP address changed", want: []byte("MESSAGE=IP address changed\n")},
+		{key: "MESSAGE", value: "", want: []byte("MESSAGE=\n")},
+		{
+			// Binary form: key, newline, length as a little-endian uint64, value, newline
+			key: "MESSAGE", value: "line 1\nline 2",
+			want: append([]byte("MESSAGE\n\x0d\x00\x00\x00\x00\x00\x00\x00"), "line 1\nline 2\n"...),
+		},
+		{key: "PIERCEFLARE_ERROR", value: "\n", want: []byte("PIERCEFLARE_ERROR\n\x01\x00\x00\x00\x00\x00\x00\x00\n\n")},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		writeField(&buf, tt.key, tt.value)
+		if !bytes.Equal(buf.Bytes(), tt.want) {
+			t.Errorf("writeField(%q, %q) = %q, want %q", tt.key, tt.value, buf.Bytes(), tt.want)
+		}
+	}
+}
+
+func TestJournalLogFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	journald, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram: %v", err)
+	}
+	defer journald.Close()
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("DialUnix: %v", err)
+	}
+	defer conn.Close()
+	j := &Journal{conn: conn, identifier: "pierceflare-cli"}
+
+	j.LogFields(logger.LogLevelError, "Flare failed:\nHTTP 503", map[string]string{"uplink": "fiber", "domain": "home.example.com"})
+
+	journald.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, err := journald.Read(buf)
+	if err != nil {
+		t.Fatalf("no message received: %v", err)
+	}
+	want := append([]byte("MESSAGE\n\x16\x00\x00\x00\x00\x00\x00\x00"), "Flare failed:\nHTTP 503\n"+
+		"PRIORITY=3\n"+
+		"SYSLOG_IDENTIFIER=pierceflare-cli\n"+
+		"PIERCEFLARE_DOMAIN=home.example.com\n"+
+		"PIERCEFLARE_UPLINK=fiber\n"...)
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("message = %q, want %q", buf[:n], want)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package systemd
+
+import (
+	"fmt"
+
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+)
+
+// JournalStream reports whether the standard error is connected to the journal, never outside of Linux
+func JournalStream() bool {
+	return false
+}
+
+// Journal sends log messages to journald, only available on Linux
+type Journal struct{}
+
+// NewJournal fails outside of Linux
+func NewJournal(identifier string) (*Journal, error) {
+	return nil, fmt.Errorf("journald is only available on Linux")
+}
+
+// Log does nothing outside of Linux
+func (j *Journal) Log(level logger.LogLevel, message string) {}
+
+// LogFields does nothing outside of Linux
+func (j *Journal) LogFields(level logger.LogLevel, message string, fields map[string]string) {}
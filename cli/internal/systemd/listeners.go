@@ -0,0 +1,52 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+// Listeners returns the sockets passed by systemd socket activation, by name (FileDescriptorName=
+// of the socket unit, "unknown" by default). The environment variables describing them are unset,
+// so that they are not inherited by child processes.
+func Listeners() (map[string][]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	listeners := map[string][]net.Listener{}
+	for i := 0; i < count; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close() // FileListener works on a copy
+		if err != nil {
+			return nil, fmt.Errorf("socket %s passed by systemd: %w", name, err)
+		}
+		listeners[name] = append(listeners[name], listener)
+	}
+
+	return listeners, nil
+}
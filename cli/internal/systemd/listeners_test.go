@@ -0,0 +1,91 @@
+//go:build unix
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestListeners runs TestListenersHelper in a child process, the sockets being passed to it as
+// systemd does: from file descriptor 3, with LISTEN_PID set to the pid of the child
+func TestListeners(t *testing.T) {
+	dir := t.TempDir()
+	var files []*os.File
+	var addrs []string
+	for _, name := range []string{"control", "dyndns", "dyndns"} {
+		path := filepath.Join(dir, name+strconv.Itoa(len(files))+".sock")
+		listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		if err != nil {
+			t.Fatalf("ListenUnix: %v", err)
+		}
+		defer listener.Close()
+		file, err := listener.File()
+		if err != nil {
+			t.Fatalf("File: %v", err)
+		}
+		defer file.Close()
+		files = append(files, file)
+		addrs = append(addrs, name+"="+path)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListenersHelper$")
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		"PIERCEFLARE_TEST_LISTENERS="+strings.Join(addrs, ","),
+		"LISTEN_FDS=3",
+		"LISTEN_FDNAMES=control:dyndns:dyndns",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("helper failed: %v\n%s", err, out)
+	}
+}
+
+// TestListenersHelper checks, in the child process of TestListeners, the sockets it was passed
+func TestListenersHelper(t *testing.T) {
+	want := os.Getenv("PIERCEFLARE_TEST_LISTENERS")
+	if want == "" {
+		t.Skip("run by TestListeners")
+	}
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	listeners, err := Listeners()
+	if err != nil {
+		t.Fatalf("Listeners: %v", err)
+	}
+	var got []string
+	for _, name := range []string{"control", "dyndns"} {
+		for _, listener := range listeners[name] {
+			got = append(got, name+"="+listener.Addr().String())
+			listener.Close()
+		}
+	}
+	if strings.Join(got, ",") != want || len(listeners) != 2 {
+		t.Errorf("listeners = %v, want %s", listeners, want)
+	}
+
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if value, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s = %q, want unset", name, value)
+		}
+	}
+}
+
+func TestListenersOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "control")
+
+	listeners, err := Listeners()
+	if listeners != nil || err != nil {
+		t.Errorf("Listeners = %v, %v, want none for another process", listeners, err)
+	}
+	if value, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Errorf("LISTEN_FDS = %q, want unset", value)
+	}
+}
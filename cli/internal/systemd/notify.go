@@ -0,0 +1,65 @@
+// Package systemd integrates the CLI with systemd: readiness, status and watchdog notifications
+// (sd_notify), socket activation and the native journald protocol. Outside of systemd, notifications
+// are silently dropped and no socket is passed.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification states understood by systemd
+const (
+	Ready    = "READY=1"    // Startup is complete
+	Stopping = "STOPPING=1" // Shutdown has begun
+	Watchdog = "WATCHDOG=1" // The service is alive
+)
+
+// Status returns the state describing the service in `systemctl status`
+func Status(status string) string {
+	return "STATUS=" + strings.ReplaceAll(status, "\n", " ")
+}
+
+// Notify sends states to the service manager through $NOTIFY_SOCKET.
+// It reports whether the notification was sent, which is not the case outside of systemd.
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// Abstract namespace socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the interval within which systemd expects Watchdog notifications
+// (WatchdogSec= of the unit), 0 if the watchdog is disabled
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	// The watchdog may be meant for another process of the unit
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,105 @@
+//go:build unix
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenNotify creates a socket receiving notifications, at path ("@name" for the abstract namespace)
+func listenNotify(t *testing.T, path string) *net.UnixConn {
+	t.Helper()
+
+	name := path
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// receive returns the next notification received on conn
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no notification received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := listenNotify(t, filepath.Join(t.TempDir(), "notify.sock"))
+
+	sent, err := Notify(Ready, Status("Checking\nevery 5 minutes"))
+	if !sent || err != nil {
+		t.Fatalf("Notify = %v, %v, want sent", sent, err)
+	}
+	if got, want := receive(t, conn), "READY=1\nSTATUS=Checking every 5 minutes"; got != want {
+		t.Errorf("notification = %q, want %q", got, want)
+	}
+}
+
+func TestNotifyAbstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are only available on Linux")
+	}
+	conn := listenNotify(t, "@pierceflare-test-"+strconv.Itoa(os.Getpid()))
+
+	if sent, err := Notify(Watchdog); !sent || err != nil {
+		t.Fatalf("Notify = %v, %v, want sent", sent, err)
+	}
+	if got := receive(t, conn); got != Watchdog {
+		t.Errorf("notification = %q, want %q", got, Watchdog)
+	}
+}
+
+func TestNotifyOutsideSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("Notify = %v, %v, want not sent without error", sent, err)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	if sent, err := Notify(Ready); sent || err == nil {
+		t.Errorf("Notify = %v, %v, want an error for a missing socket", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{usec: "", want: 0},
+		{usec: "30000000", want: 30 * time.Second},
+		{usec: "30000000", pid: pid, want: 30 * time.Second},
+		{usec: "30000000", pid: strconv.Itoa(os.Getpid() + 1), want: 0},
+		{usec: "0", want: 0},
+		{usec: "-1", want: 0},
+		{usec: "30s", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := WatchdogInterval(); got != tt.want {
+			t.Errorf("WatchdogInterval() with WATCHDOG_USEC=%q, WATCHDOG_PID=%q = %s, want %s", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package testserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CloudflarePath is the prefix of the API routes of the fake Cloudflare API
+const CloudflarePath = "/client/v4"
+
+// CloudflareRecord is a DNS record held by the fake Cloudflare API
+type CloudflareRecord struct {
+	ID      string `json:"id"`
+	ZoneID  string `json:"zone_id"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+// Cloudflare is a fake Cloudflare API implementing the zone and DNS record endpoints used by the
+// direct-to-Cloudflare fallback, accepting a single API token
+type Cloudflare struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	token    string
+	zones    map[string]string // Zone name -> ID
+	records  []*CloudflareRecord
+	status   int // Answers every request with this status when not 0
+	requests int
+}
+
+// NewCloudflare starts a fake Cloudflare API accepting token and holding the given zones. Close it when done.
+func NewCloudflare(token string, zones ...string) *Cloudflare {
+	c := &Cloudflare{token: token, zones: map[string]string{}}
+	for i, zone := range zones {
+		c.zones[zone] = "zone" + strconv.Itoa(i+1)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+CloudflarePath+"/zones", c.handleZones)
+	mux.HandleFunc("GET "+CloudflarePath+"/zones/{zone}/dns_records", c.handleListRecords)
+	mux.HandleFunc("POST "+CloudflarePath+"/zones/{zone}/dns_records", c.handleCreateRecord)
+	mux.HandleFunc("PATCH "+CloudflarePath+"/zones/{zone}/dns_records/{id}", c.handleUpdateRecord)
+	c.Server = httptest.NewServer(c.authenticate(mux))
+
+	return c
+}
+
+// APIURL returns the base URL to configure the client with
+func (c *Cloudflare) APIURL() string {
+	return c.URL + CloudflarePath
+}
+
+// ZoneID returns the ID of a zone, empty if unknown
+func (c *Cloudflare) ZoneID(zone string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.zones[zone]
+}
+
+// SetRecord creates or replaces a record, in the zone enclosing its name
+func (c *Cloudflare) SetRecord(recordType, name, content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if record := c.find(recordType, name); record != nil {
+		record.Content = content
+		return
+	}
+	c.records = append(c.records, &CloudflareRecord{
+		ID: "record" + strconv.Itoa(len(c.records)+1), ZoneID: c.zoneOf(name),
+		Type: recordType, Name: name, Content: content, TTL: 1,
+	})
+}
+
+// Record returns the content of a record, and whether it exists
+func (c *Cloudflare) Record(recordType, name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if record := c.find(recordType, name); record != nil {
+		return record.Content, true
+	}
+	return "", false
+}
+
+// SetStatus makes the API answer every request with an error status (0 to answer normally)
+func (c *Cloudflare) SetStatus(status int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+}
+
+// Requests returns the number of requests received so far
+func (c *Cloudflare) Requests() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests
+}
+
+// authenticate counts requests, checks the token and applies the scripted status
+func (c *Cloudflare) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		c.requests++
+		status := c.status
+		token := c.token
+		c.mu.Unlock()
+
+		switch {
+		case status != 0:
+			writeCloudflare(w, status, nil, "scripted failure")
+		case r.Header.Get("Authorization") != "Bearer "+token:
+			writeCloudflare(w, http.StatusForbidden, nil, "Invalid API Token")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (c *Cloudflare) handleZones(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type zone struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	zones := []zone{}
+	if id, ok := c.zones[r.URL.Query().Get("name")]; ok {
+		zones = append(zones, zone{ID: id, Name: r.URL.Query().Get("name")})
+	}
+	writeCloudflare(w, http.StatusOK, zones, "")
+}
+
+func (c *Cloudflare) handleListRecords(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	query := r.URL.Query()
+	records := []CloudflareRecord{}
+	for _, record := range c.records {
+		if record.ZoneID == r.PathValue("zone") &&
+			(query.Get("type") == "" || query.Get("type") == record.Type) &&
+			(query.Get("name") == "" || query.Get("name") == record.Name) {
+			records = append(records, *record)
+		}
+	}
+	writeCloudflare(w, http.StatusOK, records, "")
+}
+
+func (c *Cloudflare) handleCreateRecord(w http.ResponseWriter, r *http.Request) {
+	var record CloudflareRecord
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil || record.Name == "" || record.Content == "" {
+		writeCloudflare(w, http.StatusBadRequest, nil, "Invalid record")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.find(record.Type, record.Name) != nil {
+		writeCloudflare(w, http.StatusBadRequest, nil, "An identical record already exists.")
+		return
+	}
+	record.ID = "record" + strconv.Itoa(len(c.records)+1)
+	record.ZoneID = r.PathValue("zone")
+	c.records = append(c.records, &record)
+	writeCloudflare(w, http.StatusOK, record, "")
+}
+
+func (c *Cloudflare) handleUpdateRecord(w http.ResponseWriter, r *http.Request) {
+	var patch struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch.Content == "" {
+		writeCloudflare(w, http.StatusBadRequest, nil, "Invalid record")
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, record := range c.records {
+		if record.ID == r.PathValue("id") && record.ZoneID == r.PathValue("zone") {
+			record.Content = patch.Content
+			writeCloudflare(w, http.StatusOK, record, "")
+			return
+		}
+	}
+	writeCloudflare(w, http.StatusNotFound, nil, "Record not found")
+}
+
+// find returns a record by type and name, nil if none
+func (c *Cloudflare) find(recordType, name string) *CloudflareRecord {
+	for _, record := range c.records {
+		if record.Type == recordType && record.Name == name {
+			return record
+		}
+	}
+	return nil
+}
+
+// zoneOf returns the ID of the closest zone enclosing name
+func (c *Cloudflare) zoneOf(name string) string {
+	for candidate := name; candidate != ""; _, candidate, _ = strings.Cut(candidate, ".") {
+		if id, ok := c.zones[candidate]; ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// writeCloudflare answers in the envelope of the Cloudflare API
+func writeCloudflare(w http.ResponseWriter, status int, result interface{}, message string) {
+	type apiError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	body := struct {
+		Success bool       `json:"success"`
+		Errors  []apiError `json:"errors"`
+		Result  any        `json:"result"`
+	}{Success: message == "", Errors: []apiError{}, Result: result}
+	if message != "" {
+		body.Errors = append(body.Errors, apiError{Code: 1000 + status, Message: message})
+	}
+	writeJSON(w, status, body)
+}
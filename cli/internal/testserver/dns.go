@@ -0,0 +1,142 @@
+package testserver
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+
+	"github.com/qalisa/pierceflare/cli/internal/dnswire"
+)
+
+// DNSAnswer scripts the answer of a fake resolver
+type DNSAnswer struct {
+	Rcode int      // Response code (dnswire.RcodeSuccess by default)
+	Addrs []string // Addresses answered, those of the family queried
+	TTL   uint32   // TTL of the records
+	Drop  bool     // No answer at all, to trigger timeouts
+}
+
+// DNS is a fake recursive resolver answering scripted answers over UDP and DNS over HTTPS
+type DNS struct {
+	udp net.PacketConn
+	doh *httptest.Server
+
+	mu      sync.Mutex
+	answers []DNSAnswer // Consumed in order, the last one repeats
+	queries int
+}
+
+// NewDNS starts a fake resolver answering the answers in order, the last one repeating. Close it when done.
+func NewDNS(answers ...DNSAnswer) *DNS {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic("testserver: unable to listen: " + err.Error())
+	}
+	d := &DNS{udp: udp, answers: answers}
+	d.doh = httptest.NewTLSServer(http.HandlerFunc(d.handleDoH))
+
+	go d.serveUDP()
+	return d
+}
+
+// Addr returns the host:port of the resolver over UDP
+func (d *DNS) Addr() string {
+	return d.udp.LocalAddr().String()
+}
+
+// DoHURL returns the URL of the resolver over DNS over HTTPS, reachable with DoHClient
+func (d *DNS) DoHURL() string {
+	return d.doh.URL + "/dns-query"
+}
+
+// DoHClient returns an HTTP client trusting the certificate of the DoH endpoint
+func (d *DNS) DoHClient() *http.Client {
+	return d.doh.Client()
+}
+
+// Queries returns the number of queries received so far
+func (d *DNS) Queries() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queries
+}
+
+// Close stops the resolver
+func (d *DNS) Close() {
+	d.udp.Close()
+	d.doh.Close()
+}
+
+func (d *DNS) serveUDP() {
+	buf := make([]byte, dnswire.MaxMessageSize)
+	for {
+		n, addr, err := d.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := d.answer(buf[:n]); resp != nil {
+			d.udp.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (d *DNS) handleDoH(w http.ResponseWriter, r *http.Request) {
+	query, _ := io.ReadAll(r.Body)
+	resp := d.answer(query)
+	if resp == nil {
+		<-r.Context().Done()
+		return
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(resp)
+}
+
+// answer builds the response to a query with the next scripted answer, nil to drop it
+func (d *DNS) answer(query []byte) []byte {
+	d.mu.Lock()
+	d.queries++
+	next := DNSAnswer{Rcode: 2} // SERVFAIL once out of script
+	if len(d.answers) > 0 {
+		next = d.answers[0]
+		if len(d.answers) > 1 {
+			d.answers = d.answers[1:]
+		}
+	}
+	d.mu.Unlock()
+
+	h, err := dnswire.ParseHeader(query)
+	if err != nil || next.Drop {
+		return nil
+	}
+	name, off, err := dnswire.ReadName(query, dnswire.HeaderSize)
+	if err != nil || off+4 > len(query) {
+		return nil
+	}
+	rrType := binary.BigEndian.Uint16(query[off:])
+
+	var records []byte
+	count := 0
+	for _, a := range next.Addrs {
+		addr := netip.MustParseAddr(a)
+		if addr.Is4() != (rrType == dnswire.TypeA) {
+			continue
+		}
+		if records, err = dnswire.AppendRR(records, name, rrType, dnswire.ClassIN, next.TTL, addr.AsSlice()); err != nil {
+			return nil
+		}
+		count++
+	}
+
+	resp := dnswire.AppendHeader(nil, dnswire.Header{
+		ID:      h.ID,
+		Flags:   dnswire.FlagQR | h.Flags&dnswire.FlagRD | uint16(next.Rcode),
+		QDCount: 1,
+		ANCount: uint16(count),
+	})
+	resp = append(resp, query[dnswire.HeaderSize:off+4]...)
+	return append(resp, records...)
+}
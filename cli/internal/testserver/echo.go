@@ -0,0 +1,109 @@
+package testserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/qalisa/pierceflare/cli/internal/ip"
+)
+
+// EchoResponse scripts the answer of a fake IP echo service
+type EchoResponse struct {
+	Body        string        // Body answered
+	Status      int           // Status code (200 by default)
+	ContentType string        // Content-Type header ("text/plain" by default)
+	Delay       time.Duration // Delay before answering, to trigger client timeouts
+}
+
+// EchoIP answers the address as-is, like api.ipify.org
+func EchoIP(address string) EchoResponse {
+	return EchoResponse{Body: address}
+}
+
+// EchoLine answers the address followed by a newline, like icanhazip.com
+func EchoLine(address string) EchoResponse {
+	return EchoResponse{Body: address + "\n"}
+}
+
+// EchoGarbage answers an HTML page instead of an address, like a captive portal
+func EchoGarbage() EchoResponse {
+	return EchoResponse{
+		Body:        "<html><body>Please log in to access the Internet</body></html>",
+		ContentType: "text/html",
+	}
+}
+
+// EchoStatus answers an error status code
+func EchoStatus(status int) EchoResponse {
+	return EchoResponse{Body: http.StatusText(status), Status: status}
+}
+
+// EchoHang answers only after the delay, to trigger client timeouts
+func EchoHang(delay time.Duration) EchoResponse {
+	return EchoResponse{Delay: delay}
+}
+
+// Echo is a fake IP echo service
+type Echo struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses []EchoResponse // Consumed in order, the last one repeats
+	hits      int
+}
+
+// NewEcho starts a fake echo service answering the responses in order, the last one repeating.
+// Close it when done.
+func NewEcho(responses ...EchoResponse) *Echo {
+	e := &Echo{responses: responses}
+	e.Server = httptest.NewServer(http.HandlerFunc(e.handle))
+	return e
+}
+
+// Source returns the ip.Source querying the service
+func (e *Echo) Source() ip.Source {
+	return ip.HTTPSource{URL: e.URL}
+}
+
+// Hits returns the number of requests received
+func (e *Echo) Hits() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.hits
+}
+
+// handle answers the next scripted response
+func (e *Echo) handle(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	e.hits++
+	response := EchoResponse{Status: http.StatusNotFound}
+	if len(e.responses) > 0 {
+		response = e.responses[0]
+		if len(e.responses) > 1 {
+			e.responses = e.responses[1:]
+		}
+	}
+	e.mu.Unlock()
+
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if response.ContentType == "" {
+		response.ContentType = "text/plain; charset=utf-8"
+	}
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", response.ContentType)
+	w.WriteHeader(response.Status)
+	_, _ = io.WriteString(w, response.Body)
+}
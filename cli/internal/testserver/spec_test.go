@@ -0,0 +1,238 @@
+package testserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+
+	"github.com/qalisa/pierceflare/cli/internal/api"
+	genapi "github.com/qalisa/pierceflare/cli/internal/gen/api"
+	"github.com/qalisa/pierceflare/cli/internal/logger"
+	"github.com/qalisa/pierceflare/cli/internal/testserver"
+)
+
+// The spec tests check that the committed OpenAPI document of the service, the Go client generated from
+// it and the fake server still agree: the requests of api.Client go through a validating proxy in front
+// of the fake server, and every request and response is checked against the document (routes, IP
+// format, dummy flag, response shapes). Run them after make gen-api.
+
+const (
+	specToken  = "spec-token"
+	specDomain = "spec.example.com"
+)
+
+// scenario is a call of the client, checked against the document
+type scenario struct {
+	name     string
+	behavior *testserver.Behavior // Scripted answer of the fake server, if any
+	call     func(c *api.Client) error
+}
+
+var scenarios = []scenario{
+	{
+		name: "GET /api/infos",
+		call: func(c *api.Client) error {
+			got, err := c.GetBoundDomain(context.Background())
+			if err == nil && got != specDomain {
+				err = fmt.Errorf("domain %q decoded, %q expected", got, specDomain)
+			}
+			return err
+		},
+	},
+	{
+		name: "PUT /api/flare (IPv4)",
+		call: func(c *api.Client) error {
+			result, err := c.SendIPUpdate(context.Background(), "93.184.216.34", false)
+			if err == nil && (result.Op != "batch" || result.ResolvedIP == "") {
+				err = fmt.Errorf("unexpected decoded result %+v", result)
+			}
+			return err
+		},
+	},
+	{
+		name: "PUT /api/flare (IPv6, dummy)",
+		call: func(c *api.Client) error {
+			result, err := c.SendIPUpdate(context.Background(), "2606:4700:4700::1111", true)
+			if err == nil && result.Op != "dummy" {
+				err = fmt.Errorf("unexpected decoded result %+v", result)
+			}
+			return err
+		},
+	},
+	{
+		name:     "PUT /api/flare (unresolvable)",
+		behavior: &testserver.Behavior{Route: testserver.RouteFlare, Unresolvable: true},
+		call: func(c *api.Client) error {
+			_, err := c.SendIPUpdate(context.Background(), "93.184.216.34", false)
+			if !errors.Is(err, api.ErrUnresolvable) {
+				return fmt.Errorf("UNRESOLVABLE error expected, got %v", err)
+			}
+			return nil
+		},
+	},
+}
+
+func TestSpec(t *testing.T) {
+	// Keep schema errors on one line
+	openapi3.SchemaErrorDetailsDisabled = true
+
+	// The service describes IP addresses with the non-standard "ip" format
+	openapi3.DefineStringFormatValidator("ip", openapi3.NewCallbackValidator(func(value string) error {
+		_, err := netip.ParseAddr(value)
+		return err
+	}))
+
+	doc, err := openapi3.NewLoader().LoadFromData(genapi.Spec)
+	if err != nil {
+		t.Fatalf("unable to load the document: %v", err)
+	}
+	if err := doc.Validate(context.Background(), openapi3.EnableSchemaFormatValidation()); err != nil {
+		t.Fatalf("invalid document: %v", err)
+	}
+	router, err := legacy.NewRouter(doc, openapi3.EnableSchemaFormatValidation())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := testserver.New(map[string]string{specToken: specDomain})
+	defer server.Close()
+	validator := &proxy{router: router, upstream: server.URL}
+	front := httptest.NewServer(validator)
+	defer front.Close()
+
+	client := api.NewClient(specToken, front.URL, nil, logger.New(false, logger.LogLevelError, 0))
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if s.behavior != nil {
+				server.Script(*s.behavior)
+			}
+			if err := s.call(client); err != nil {
+				t.Error(err)
+			}
+			for _, err := range validator.takeErrors() {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// proxy forwards requests to the fake server, validating requests and responses against the document
+type proxy struct {
+	router   routers.Router
+	upstream string
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// takeErrors returns and clears the divergences found so far
+func (p *proxy) takeErrors() []error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	errs := p.errs
+	p.errs = nil
+	return errs
+}
+
+func (p *proxy) fail(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errs = append(p.errs, fmt.Errorf(format, args...))
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, _ := io.ReadAll(r.Body)
+
+	// The request must be described by the document...
+	route, pathParams, err := p.router.FindRoute(r)
+	if err != nil {
+		p.fail("%s %s: not in the document: %v", r.Method, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: pathParams,
+		Route:      route,
+		Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := openapi3filter.ValidateRequest(ctx, input); err != nil {
+		p.fail("%s %s: invalid request: %v", r.Method, r.URL.Path, err)
+	}
+	p.checkDeclaredFields(route, r, body)
+
+	// ...and so must the answer of the server
+	req, err := http.NewRequestWithContext(ctx, r.Method, p.upstream+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	req.Header = r.Header.Clone()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		p.fail("%s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+
+	responseInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 resp.StatusCode,
+		Header:                 resp.Header,
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	}
+	responseInput.SetBodyBytes(respBody)
+	if err := openapi3filter.ValidateResponse(ctx, responseInput); err != nil {
+		p.fail("%s %s: invalid response (HTTP %d): %v", r.Method, r.URL.Path, resp.StatusCode, err)
+	}
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
+
+// checkDeclaredFields reports JSON fields sent by the client but not declared by the document:
+// the schemas of the service do not forbid additional properties, so ValidateRequest accepts them
+func (p *proxy) checkDeclaredFields(route *routers.Route, r *http.Request, body []byte) {
+	if route.Operation.RequestBody == nil || len(body) == 0 {
+		return
+	}
+
+	mediaType := route.Operation.RequestBody.Value.Content.Get("application/json")
+	if mediaType == nil || mediaType.Schema == nil {
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return
+	}
+
+	for name := range fields {
+		if _, declared := mediaType.Schema.Value.Properties[name]; !declared {
+			p.fail("%s %s: field %q is not declared by the document", r.Method, r.URL.Path, name)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+// Package testserver provides an in-process fake PierceFlare server, implementing the API described
+// by internal/gen/api/swagger.json, to test the CLI end-to-end without network access.
+package testserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	genapi "github.com/qalisa/pierceflare/cli/internal/gen/api"
+	"github.com/qalisa/pierceflare/cli/signing"
+)
+
+// API routes, as defined by the OpenAPI document of the service
+const (
+	RouteInfos = "/api/infos"
+	RouteFlare = "/api/flare"
+	RouteDoc   = "/swagger/doc" // OpenAPI document, outside of the authenticated and rate-limited API
+)
+
+// Behavior scripts the answer to a request
+type Behavior struct {
+	Route          string        // Only applies to requests on this route ("" = any route)
+	Latency        time.Duration // Delay before answering
+	Status         int           // Answers with this status code instead of handling the request (e.g. 403, 500)
+	Unresolvable   bool          // Answers flares with 500 UNRESOLVABLE, like a server unable to get the emitter address
+	RateLimited    bool          // Answers with 429 and the RateLimit-* headers
+	RateLimitReset time.Duration // Advised delay before retrying, when RateLimited
+	ResolvedIP     string        // Overrides the resolvedIp answered to flares
+}
+
+// Request is a request received by the server
+type Request struct {
+	Time   time.Time
+	Method string
+	Path   string
+	Header http.Header
+	Token  string     // Bearer token, empty if none
+	Flare  *FlareBody // Decoded body of flares, nil for other routes
+	Status int        // Status code answered
+}
+
+// FlareBody is the body of PUT /api/flare
+type FlareBody struct {
+	IP    *string `json:"ip,omitempty"`
+	Dummy *bool   `json:"dummy,omitempty"`
+}
+
+// FlareResponse is the body of a successful PUT /api/flare
+type FlareResponse struct {
+	Op         string `json:"op"`
+	ResolvedIP string `json:"resolvedIp"`
+}
+
+// ErrorResponse is the body of a failed PUT /api/flare
+type ErrorResponse struct {
+	ErrCode string `json:"errCode"`
+	Message string `json:"message"`
+}
+
+// Server is a fake PierceFlare server
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	tokens   map[string]string // API token -> domain
+	script   []Behavior        // Behaviors consumed by the next matching requests
+	fallback Behavior          // Behavior once the script is exhausted
+	requests []Request
+	doc      []byte            // OpenAPI document served, nil = 404
+	verifier *signing.Verifier // Verifies request signatures when set
+
+	rateLimit   int // Requests allowed per window, 0 = unlimited
+	rateWindow  time.Duration
+	windowStart time.Time
+	windowCount int
+}
+
+// New starts a fake server accepting the given tokens (API token -> bound domain).
+// It serves the OpenAPI document the client was generated from. Close it when done.
+func New(tokens map[string]string) *Server {
+	s := &Server{tokens: map[string]string{}, doc: genapi.Spec}
+	for token, domain := range tokens {
+		s.tokens[token] = domain
+	}
+
+	api := http.NewServeMux()
+	api.HandleFunc("GET "+RouteInfos, s.handleInfos)
+	api.HandleFunc("PUT "+RouteFlare, s.handleFlare)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", s.authenticate(api))
+	mux.HandleFunc("GET "+RouteDoc, s.handleDoc)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// RequireSignatures makes the server refuse (HTTP 401) API requests not signed with the key derived
+// from their token, replayed or signed outside of the clock skew tolerated by v (nil disables the check)
+func (s *Server) RequireSignatures(v *signing.Verifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verifier = v
+}
+
+// SetDoc replaces the OpenAPI document served, e.g. to simulate another version of the server
+// (nil answers 404, like a server predating the document)
+func (s *Server) SetDoc(doc []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc = doc
+}
+
+// AddToken makes the server accept a token, bound to a domain
+func (s *Server) AddToken(token, domain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = domain
+}
+
+// RevokeToken makes the server refuse a token, like when its key is deleted
+func (s *Server) RevokeToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+}
+
+// Script queues behaviors, each one consumed by the next request matching its route
+func (s *Server) Script(behaviors ...Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, behaviors...)
+}
+
+// SetDefault sets the behavior applying once the script is exhausted
+func (s *Server) SetDefault(behavior Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallback = behavior
+}
+
+// SetRateLimit enforces a limit of requests per window, like the server's rate limiter (0 = unlimited)
+func (s *Server) SetRateLimit(limit int, window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimit = limit
+	s.rateWindow = window
+	s.windowStart = time.Now()
+	s.windowCount = 0
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Flares returns the flare requests received so far
+func (s *Server) Flares() []Request {
+	var flares []Request
+	for _, req := range s.Requests() {
+		if req.Flare != nil {
+			flares = append(flares, req)
+		}
+	}
+	return flares
+}
+
+// Reset forgets recorded requests and scripted behaviors
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+	s.script = nil
+	s.fallback = Behavior{}
+}
+
+// nextBehavior pops the first scripted behavior matching the route
+func (s *Server) nextBehavior(route string) Behavior {
+	for i, behavior := range s.script {
+		if behavior.Route == "" || behavior.Route == route {
+			s.script = append(s.script[:i], s.script[i+1:]...)
+			return behavior
+		}
+	}
+	return s.fallback
+}
+
+// record stores a handled request
+func (s *Server) record(r *http.Request, token string, flare *FlareBody, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{
+		Time:   time.Now(),
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Token:  token,
+		Flare:  flare,
+		Status: status,
+	})
+}
+
+// statusRecorder captures the status code answered by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// authenticate applies scripted behaviors, rate limiting and bearer authentication, in the same order as the service
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		// Flare bodies are recorded even when the request is refused
+		var flare *FlareBody
+		if r.URL.Path == RouteFlare {
+			body, _ := io.ReadAll(r.Body)
+			flare = &FlareBody{}
+			_ = json.Unmarshal(body, flare)
+			r.Body = io.NopCloser(strings.NewReader(string(body)))
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() { s.record(r, token, flare, rec.status) }()
+
+		s.mu.Lock()
+		behavior := s.nextBehavior(r.URL.Path)
+		limited, remaining, reset := s.consumeRateLimit()
+		rateLimit := s.rateLimit
+		_, known := s.tokens[token]
+		verifier := s.verifier
+		s.mu.Unlock()
+
+		if behavior.Latency > 0 {
+			time.Sleep(behavior.Latency)
+		}
+
+		if rateLimit > 0 {
+			setRateLimitHeaders(rec.Header(), rateLimit, remaining, reset)
+		}
+
+		switch {
+		case behavior.RateLimited || limited:
+			if behavior.RateLimited {
+				reset = behavior.RateLimitReset
+				setRateLimitHeaders(rec.Header(), rateLimit, 0, reset)
+			}
+			http.Error(rec, "Too many requests, please try again later.", http.StatusTooManyRequests)
+		case behavior.Status != 0:
+			http.Error(rec, http.StatusText(behavior.Status), behavior.Status)
+		case !hasToken || token == "":
+			rec.Header().Set("WWW-Authenticate", `Bearer realm=""`)
+			http.Error(rec, "Unauthorized", http.StatusUnauthorized)
+		case !known:
+			http.Error(rec, "Unauthorized", http.StatusForbidden)
+		case verifier != nil && verifier.Verify(r, token) != nil:
+			http.Error(rec, "Invalid request signature", http.StatusUnauthorized)
+		case behavior.Unresolvable && r.URL.Path == RouteFlare:
+			writeJSON(rec, http.StatusInternalServerError, ErrorResponse{
+				ErrCode: "UNRESOLVABLE",
+				Message: "Remote IP of flare emitter is unresolvable.",
+			})
+		default:
+			ctx := withBehavior(r.Context(), behavior)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+		}
+	})
+}
+
+// consumeRateLimit counts a request against the rate limit window.
+// It returns whether the request exceeds the limit, the remaining budget and the delay until the window resets.
+func (s *Server) consumeRateLimit() (limited bool, remaining int, reset time.Duration) {
+	if s.rateLimit <= 0 {
+		return false, 0, 0
+	}
+
+	now := time.Now()
+	if now.Sub(s.windowStart) >= s.rateWindow {
+		s.windowStart = now
+		s.windowCount = 0
+	}
+
+	s.windowCount++
+	reset = s.windowStart.Add(s.rateWindow).Sub(now)
+	remaining = max(s.rateLimit-s.windowCount, 0)
+
+	return s.windowCount > s.rateLimit, remaining, reset
+}
+
+// setRateLimitHeaders sets the draft-6 RateLimit-* headers, as sent by the service
+func setRateLimitHeaders(header http.Header, limit, remaining int, reset time.Duration) {
+	header.Set("RateLimit-Limit", strconv.Itoa(limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(int((reset+time.Second-1)/time.Second)))
+}
+
+// handleDoc serves the OpenAPI document, without authentication like the service
+func (s *Server) handleDoc(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	doc := s.doc
+	s.mu.Unlock()
+
+	if doc == nil {
+		s.record(r, "", nil, http.StatusNotFound)
+		http.NotFound(w, r)
+		return
+	}
+
+	s.record(r, "", nil, http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
+
+// handleInfos answers the domain bound to the token
+func (s *Server) handleInfos(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	domain := s.tokens[token]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, domain)
+}
+
+// handleFlare acknowledges a flare, resolving the address like the service does
+func (s *Server) handleFlare(w http.ResponseWriter, r *http.Request) {
+	var body FlareBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if body.IP != nil && net.ParseIP(*body.IP) == nil {
+		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return
+	}
+
+	// The remote address is used, unless it is private and the emitter gave its own
+	resolvedIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if remote := net.ParseIP(resolvedIP); remote != nil && (remote.IsPrivate() || remote.IsLoopback()) && body.IP != nil {
+		resolvedIP = *body.IP
+	}
+
+	if behavior := behaviorFrom(r.Context()); behavior.ResolvedIP != "" {
+		resolvedIP = behavior.ResolvedIP
+	}
+
+	op := "batch"
+	if body.Dummy != nil && *body.Dummy {
+		op = "dummy"
+	}
+
+	writeJSON(w, http.StatusOK, FlareResponse{Op: op, ResolvedIP: resolvedIP})
+}
+
+type behaviorKey struct{}
+
+// withBehavior passes the behavior of a request to its handler
+func withBehavior(ctx context.Context, behavior Behavior) context.Context {
+	return context.WithValue(ctx, behaviorKey{}, behavior)
+}
+
+// behaviorFrom returns the behavior of a request
+func behaviorFrom(ctx context.Context) Behavior {
+	behavior, _ := ctx.Value(behaviorKey{}).(Behavior)
+	return behavior
+}
+
+// writeJSON answers a JSON document
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,20 @@
+package transport
+
+import (
+	"syscall"
+)
+
+// bindToInterface returns a dialer control function binding sockets to the interface (SO_BINDTODEVICE).
+// This usually requires the CAP_NET_RAW capability.
+func bindToInterface(iface string) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package transport
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToInterface is only supported on Linux, use a source address instead
+func bindToInterface(iface string) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, fmt.Errorf("binding to interface '%s' is only supported on Linux, use a source address instead", iface)
+}
@@ -0,0 +1,149 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Options configures the HTTP clients used to detect the IP address and to talk to the PierceFlare server
+type Options struct {
+	Proxy         string        // Proxy URL (http://, https:// or socks5://), empty to connect directly
+	SourceAddress string        // Local IP address outgoing connections are bound to
+	Interface     string        // Network interface outgoing connections are bound to (Linux only)
+	CAFile        string        // PEM bundle of additional trusted certificate authorities
+	CertFile      string        // PEM client certificate, for mutual TLS
+	KeyFile       string        // PEM private key of the client certificate
+	Timeout       time.Duration // Timeout of each HTTP call, 0 for none
+	Family        int           // Restricts connections to IPv4 (4) or IPv6 (6), 0 for any
+}
+
+// NewClient creates an HTTP client honoring the options
+func NewClient(opts Options) (*http.Client, error) {
+	transport, err := NewTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+	}, nil
+}
+
+// NewTransport creates an HTTP transport honoring the options (except Timeout)
+func NewTransport(opts Options) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// Proxy: http(s) proxies use CONNECT, socks5 is handled natively by net/http
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme '%s' (supported: http, https, socks5)", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	// Outgoing connections
+	dialer, err := newDialer(opts)
+	if err != nil {
+		return nil, err
+	}
+	network := func(network string) string {
+		switch opts.Family {
+		case 4:
+			return network + "4"
+		case 6:
+			return network + "6"
+		default:
+			return network
+		}
+	}
+	transport.DialContext = func(ctx context.Context, n, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network(n), addr)
+	}
+
+	// TLS
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+// newDialer creates a dialer bound to the source address and/or interface
+func newDialer(opts Options) (*net.Dialer, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if opts.SourceAddress != "" {
+		sourceIP := net.ParseIP(opts.SourceAddress)
+		if sourceIP == nil {
+			return nil, fmt.Errorf("invalid source address '%s'", opts.SourceAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: sourceIP}
+	}
+
+	if opts.Interface != "" {
+		if _, err := net.InterfaceByName(opts.Interface); err != nil {
+			return nil, fmt.Errorf("invalid interface '%s': %w", opts.Interface, err)
+		}
+		control, err := bindToInterface(opts.Interface)
+		if err != nil {
+			return nil, err
+		}
+		dialer.Control = control
+	}
+
+	return dialer, nil
+}
+
+// newTLSConfig creates the TLS configuration trusting the CA bundle and presenting the client certificate
+func newTLSConfig(opts Options) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %w", err)
+		}
+
+		// Additional authorities are trusted on top of the system ones
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA bundle %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, fmt.Errorf("both client certificate and key must be provided")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
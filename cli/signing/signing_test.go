@@ -0,0 +1,184 @@
+package signing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testToken = "test-token"
+	testBody  = `{"ip":"93.184.216.34"}`
+)
+
+var signedAt = time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+
+// newTestSigner signs at signedAt, with nonces drawn from a counter (00010203… for the first one)
+func newTestSigner(token string) *Signer {
+	s := NewSigner(token)
+	s.SetClock(func() time.Time { return signedAt })
+	nonces := make([]byte, 256)
+	for i := range nonces {
+		nonces[i] = byte(i)
+	}
+	s.rand = bytes.NewReader(nonces)
+	return s
+}
+
+// newTestVerifier verifies at signedAt + offset
+func newTestVerifier(offset time.Duration) *Verifier {
+	v := NewVerifier(0)
+	v.SetClock(func() time.Time { return signedAt.Add(offset) })
+	return v
+}
+
+// signedRequest returns a flare request signed by s
+func signedRequest(t *testing.T, s *Signer) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, "https://pierceflare.example.com/api/flare", strings.NewReader(testBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sign(req); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return req
+}
+
+func TestSignKnownAnswer(t *testing.T) {
+	req := signedRequest(t, newTestSigner(testToken))
+
+	want := map[string]string{
+		HeaderTimestamp: "1772452800",
+		HeaderNonce:     "000102030405060708090a0b0c0d0e0f",
+		HeaderDigest:    "sha-256=358a0b70e2e86c786adc56c751fc536b4b8a57230afad748fca1c81d0e20d2d8",
+		HeaderSignature: "v1=8a521c49a2287a2bb4145585ec3cbf419bd0454a88dec48abd4c64c907ee006e",
+	}
+	for header, value := range want {
+		if got := req.Header.Get(header); got != value {
+			t.Errorf("%s = %s, want %s", header, got, value)
+		}
+	}
+
+	// The body is restored, for sending and for retries
+	for _, read := range []func() (io.ReadCloser, error){
+		func() (io.ReadCloser, error) { return req.Body, nil },
+		req.GetBody,
+	} {
+		body, _ := read()
+		if got, _ := io.ReadAll(body); string(got) != testBody {
+			t.Errorf("body = %q after signing, want %q", got, testBody)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string        // Token the server checks the signature against
+		offset time.Duration // Time of the verification, after the signature
+		alter  func(req *http.Request)
+		want   error
+	}{
+		{name: "valid"},
+		{name: "skew in the past", offset: DefaultSkew},
+		{name: "skew in the future", offset: -DefaultSkew},
+		{
+			name:  "body altered",
+			alter: func(req *http.Request) { req.Body = io.NopCloser(strings.NewReader(`{"ip":"93.184.216.35"}`)) },
+			want:  ErrDigestMismatch,
+		},
+		{
+			name:  "digest altered with the body",
+			alter: func(req *http.Request) { req.Header.Set(HeaderDigest, bodyDigest(nil)); req.Body = http.NoBody },
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "URI changed",
+			alter: func(req *http.Request) { req.URL.RawQuery = "dummy=true" },
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "method changed",
+			alter: func(req *http.Request) { req.Method = http.MethodPut },
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "timestamp changed",
+			alter: func(req *http.Request) { req.Header.Set(HeaderTimestamp, "1772452801") },
+			want:  ErrInvalidSignature,
+		},
+		{name: "other token", token: "other-token", want: ErrInvalidSignature},
+		{
+			name:  "unsupported version",
+			alter: func(req *http.Request) { req.Header.Set(HeaderSignature, "v2="+req.Header.Get(HeaderSignature)[3:]) },
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "unsigned",
+			alter: func(req *http.Request) { req.Header.Del(HeaderSignature) },
+			want:  ErrMissingSignature,
+		},
+		{name: "too old", offset: DefaultSkew + time.Second, want: ErrExpired},
+		{name: "too far in the future", offset: -DefaultSkew - time.Second, want: ErrExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedRequest(t, newTestSigner(testToken))
+			if tt.alter != nil {
+				tt.alter(req)
+			}
+			token := tt.token
+			if token == "" {
+				token = testToken
+			}
+
+			err := newTestVerifier(tt.offset).Verify(req, token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	signer := newTestSigner(testToken)
+	v := newTestVerifier(0)
+
+	req := signedRequest(t, signer)
+	if err := v.Verify(req, testToken); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	replayed, _ := http.NewRequest(req.Method, req.URL.String(), strings.NewReader(testBody))
+	replayed.Header = req.Header.Clone()
+	if err := v.Verify(replayed, testToken); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Verify of a replayed request = %v, want ErrReplayed", err)
+	}
+
+	// Each signature draws a new nonce
+	if err := v.Verify(signedRequest(t, signer), testToken); err != nil {
+		t.Errorf("Verify of a new request = %v, want nil", err)
+	}
+
+	// Nonces are forgotten once their requests are refused as expired anyway
+	if len(v.nonces) != 2 {
+		t.Fatalf("%d nonces remembered, want 2", len(v.nonces))
+	}
+	v.SetClock(func() time.Time { return signedAt.Add(DefaultSkew + time.Second) })
+	signer.SetClock(func() time.Time { return signedAt.Add(DefaultSkew + time.Second) })
+	if err := v.Verify(signedRequest(t, signer), testToken); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(v.nonces) != 1 {
+		t.Errorf("%d nonces remembered, want only the one of the last request", len(v.nonces))
+	}
+	if err := v.Verify(replayed, testToken); !errors.Is(err, ErrExpired) {
+		t.Errorf("Verify of an old replayed request = %v, want ErrExpired", err)
+	}
+}